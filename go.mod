@@ -8,13 +8,15 @@ require (
 	github.com/anyswap/CrossChain-Bridge v0.3.9
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/deckarep/golang-set v1.8.0 // indirect
-	github.com/ethereum/go-ethereum v1.10.17 // indirect
+	github.com/ethereum/go-ethereum v1.10.17
 	github.com/fsnotify/fsnotify v1.5.1
 	github.com/go-stack/stack v1.8.1 // indirect
 	github.com/jowenshaw/gethclient v0.3.2-0.20220120140355-13b20d7441c2
+	github.com/jowenshaw/gethrpc v1.10.6
+	github.com/lib/pq v1.10.9
+	github.com/prometheus/client_golang v1.12.2
 	github.com/rs/cors v1.8.2 // indirect
 	github.com/urfave/cli/v2 v2.3.0
-	golang.org/x/crypto v0.0.0-20220112180741-5e0467b6c7ce // indirect
-	golang.org/x/sys v0.0.0-20220114195835-da31bd327af9 // indirect
-	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
+	go.etcd.io/bbolt v1.3.6
+	go.mongodb.org/mongo-driver v1.17.6
 )
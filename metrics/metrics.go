@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gethscan"
+
+var (
+	// LatestHeight latest block height of chain
+	LatestHeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "latest_height",
+		Help:      "Latest block height of the chain.",
+	}, []string{"chain"})
+
+	// ScannedHeight highest scanned block height
+	ScannedHeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scanned_height",
+		Help:      "Highest block height scanned by the scan loop.",
+	}, []string{"chain"})
+
+	// ScanLag blocks between latest height and scanned height
+	ScanLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scan_lag",
+		Help:      "Blocks between latest height and scanned height.",
+	}, []string{"chain"})
+
+	// BlocksScanned scanned blocks per job
+	BlocksScanned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_scanned_total",
+		Help:      "Number of scanned blocks.",
+	}, []string{"chain", "job"})
+
+	// TxsScanned scanned transactions per job
+	TxsScanned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "txs_scanned_total",
+		Help:      "Number of scanned transactions.",
+	}, []string{"chain", "job"})
+
+	// RPCDuration rpc call latency by method
+	RPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of gateway rpc calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"chain", "method"})
+
+	// RPCErrors rpc call errors by method
+	RPCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed gateway rpc calls.",
+	}, []string{"chain", "method"})
+
+	// SwapsDetected detected swaps by tx type
+	SwapsDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "swaps_detected_total",
+		Help:      "Number of detected swaps.",
+	}, []string{"chain", "txtype"})
+
+	// SwapPosts swap post results by outcome
+	SwapPosts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "swap_posts_total",
+		Help:      "Number of swap posts to swap server.",
+	}, []string{"chain", "outcome"})
+
+	// PostQueueDepth detected swaps waiting to be posted
+	PostQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "post_queue_depth",
+		Help:      "Number of detected swaps waiting in post queue.",
+	}, []string{"chain"})
+
+	// SwapServerCircuit circuit breaker state of swap server
+	SwapServerCircuit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "swap_server_circuit",
+		Help:      "Circuit breaker state of swap server (0 closed, 1 open, 2 half-open).",
+	}, []string{"server"})
+
+	// PendingSwaps size of pending collection
+	PendingSwaps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_swaps",
+		Help:      "Number of swaps in mongodb pending collection.",
+	}, []string{"chain"})
+
+	// UnconfirmedSwaps detected swaps waiting for block confirmation
+	UnconfirmedSwaps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "unconfirmed_swaps",
+		Help:      "Number of detected swaps waiting for block confirmation.",
+	}, []string{"chain"})
+
+	// OutboxDepth items in outbox by state
+	OutboxDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_depth",
+		Help:      "Number of swap posts in outbox.",
+	}, []string{"chain", "state"})
+
+	// BloomSkips rpc calls skipped by block logs bloom
+	BloomSkips = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bloom_skips_total",
+		Help:      "Number of rpc calls skipped by block logs bloom.",
+	}, []string{"chain", "method"})
+
+	// MongodbErrors mongodb operation errors
+	MongodbErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mongodb_errors_total",
+		Help:      "Number of failed mongodb operations.",
+	}, []string{"operation"})
+
+	// StorageErrors operation errors of postgres and bolt storage
+	StorageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_errors_total",
+		Help:      "Number of failed storage operations.",
+	}, []string{"backend", "operation"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		LatestHeight,
+		ScannedHeight,
+		ScanLag,
+		BlocksScanned,
+		TxsScanned,
+		RPCDuration,
+		RPCErrors,
+		SwapsDetected,
+		SwapPosts,
+		PostQueueDepth,
+		SwapServerCircuit,
+		PendingSwaps,
+		UnconfirmedSwaps,
+		OutboxDepth,
+		BloomSkips,
+		MongodbErrors,
+		StorageErrors,
+	)
+}
+
+// ObserveRPC record rpc call latency and error
+func ObserveRPC(chain, method string, latency time.Duration, err error) {
+	RPCDuration.WithLabelValues(chain, method).Observe(latency.Seconds())
+	if err != nil {
+		RPCErrors.WithLabelValues(chain, method).Inc()
+	}
+}
+
+// SetHeights set latest and scanned height
+func SetHeights(chain string, latest, scanned uint64) {
+	LatestHeight.WithLabelValues(chain).Set(float64(latest))
+	ScannedHeight.WithLabelValues(chain).Set(float64(scanned))
+	var lag uint64
+	if latest > scanned {
+		lag = latest - scanned
+	}
+	ScanLag.WithLabelValues(chain).Set(float64(lag))
+}
+
+// MongodbError record mongodb operation error
+func MongodbError(operation string, err error) {
+	if err != nil {
+		MongodbErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// StorageError count failed storage operation
+func StorageError(backend, operation string, err error) {
+	if err != nil {
+		StorageErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// StartServer start metrics http listener
+func StartServer(listen string) {
+	if listen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Info("start metrics server", "listen", listen)
+	go func() {
+		if err := http.ListenAndServe(listen, mux); err != nil {
+			log.Error("metrics server stopped", "listen", listen, "err", err)
+		}
+	}()
+}
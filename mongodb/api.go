@@ -5,8 +5,11 @@ import (
 	"time"
 
 	"github.com/anyswap/CrossChain-Bridge/log"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/weijun-sh/gethscan/metrics"
+	"github.com/weijun-sh/gethscan/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -14,11 +17,14 @@ const (
 	retryDBInterval = 1 * time.Second
 )
 
+// ErrNotFound no document is found
+var ErrNotFound = storage.ErrNotFound
+
 // TryDoTimes try do again if meet error
 func TryDoTimes(name string, f func() error) (err error) {
 	for i := 0; i < retryDBCount; i++ {
 		err = f()
-		if err == nil || mgo.IsDup(err) {
+		if err == nil || mongo.IsDuplicateKeyError(err) {
 			return nil
 		}
 		time.Sleep(retryDBInterval)
@@ -27,104 +33,157 @@ func TryDoTimes(name string, f func() error) (err error) {
 	return err
 }
 
-// --------------- add ---------------------------------
+func insert(collection *mongo.Collection, doc interface{}) error {
+	ctx, cancel := newContext()
+	defer cancel()
+	_, err := collection.InsertOne(ctx, doc)
+	return err
+}
 
-// AddSwap and swap
-func AddSwap(ms *MgoSwap, overwrite bool) (err error) {
-	if overwrite {
-		_, err = collectionSwap.UpsertId(ms.Id, ms)
-		return err
-	} else {
-		err = collectionSwap.Insert(ms)
-	}
-	if err == nil {
-		log.Info("[mongodb] AddSwap success", "swap", ms)
-	} else {
-		log.Warn("[mongodb] AddSwap failed", "swap", ms, "err", err)
-	}
+func upsertID(collection *mongo.Collection, id string, doc interface{}) error {
+	ctx, cancel := newContext()
+	defer cancel()
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": id}, doc, options.Replace().SetUpsert(true))
 	return err
 }
 
-// AddSwapPending add pending
-func AddSwapPending(ms *MgoSwap, overwrite bool) (err error) {
-	if overwrite {
-		_, err = collectionSwapPending.UpsertId(ms.Id, ms)
-	} else {
-		err = collectionSwapPending.Insert(ms)
-	}
-	if err == nil {
-		log.Info("[mongodb] AddSwapPending success", "pending", ms)
-	} else {
-		log.Warn("[mongodb] AddSwapPending failed", "pending", ms, "err", err)
+// removeID returns ErrNotFound if not exist
+func removeID(collection *mongo.Collection, id string) error {
+	ctx, cancel := newContext()
+	defer cancel()
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err == nil && res.DeletedCount == 0 {
+		err = ErrNotFound
 	}
 	return err
 }
 
-// AddSwapDeleted add deleted
-func AddSwapDeleted(ms *MgoSwap, overwrite bool) (err error) {
-	if overwrite {
-		_, err = collectionSwapDeleted.UpsertId(ms.Id, ms)
-	} else {
-		err = collectionSwapDeleted.Insert(ms)
+func findSwaps(collection *mongo.Collection, filter interface{}, opts ...*options.FindOptions) ([]*MgoSwap, error) {
+	ctx, cancel := newContext()
+	defer cancel()
+	cur, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*MgoSwap, 0)
+	err = cur.All(ctx, &result)
+	return result, err
+}
+
+// --------------- add ---------------------------------
+
+// AddSwap add new swap, returns storage.ErrSwapExists if exists
+func AddSwap(ms *MgoSwap) (err error) {
+	ms.TxID = storage.NormalizeTxID(ms.TxID)
+	ms.Version = 1
+	err = insert(collectionSwap, ms)
+	if mongo.IsDuplicateKeyError(err) {
+		err = storage.ErrSwapExists
 	}
+	metrics.MongodbError("AddSwap", err)
 	if err == nil {
-		log.Info("[mongodb] AddSwapDeleted success", "delete", ms)
+		log.Info("[mongodb] AddSwap success", "swap", ms)
 	} else {
-		log.Warn("[mongodb] AddSwapDeleted failed", "delete", ms, "err", err)
+		log.Warn("[mongodb] AddSwap failed", "swap", ms, "err", err)
 	}
 	return err
 }
 
-// RemoveSwapPending add remove pending
-func RemoveSwapPending(ms *MgoSwap) (err error) {
-	err = collectionSwapPending.Remove(ms)
-	if err == nil {
-		log.Info("[mongodb] RemoveSwapPending success", "pending", ms)
-	} else {
-		log.Warn("[mongodb] RemoveSwapPending failed", "pending", ms, "err", err)
+// --------------- update ---------------------------------
+
+// UpdateSwap apply f to swap and replace it if the version is not changed by others, retry if changed
+func UpdateSwap(key string, f func(ms *MgoSwap) error) (*MgoSwap, error) {
+	for i := 0; i < retryDBCount; i++ {
+		ms, err := FindSwap(key)
+		if err != nil {
+			return nil, err
+		}
+		version := ms.Version
+		if err = f(ms); err != nil {
+			return nil, err
+		}
+		ms.Version = version + 1
+		ms.TxID = storage.NormalizeTxID(ms.TxID)
+		ctx, cancel := newContext()
+		res, err := collectionSwap.ReplaceOne(ctx, bson.M{"_id": key, "version": version}, ms)
+		cancel()
+		metrics.MongodbError("UpdateSwap", err)
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount != 0 {
+			log.Info("[mongodb] UpdateSwap success", "key", key, "state", ms.State, "attempts", ms.Attempts)
+			return ms, nil
+		}
+		log.Warn("[mongodb] UpdateSwap conflict, retry", "key", key, "version", version)
 	}
-	return err
+	return nil, storage.ErrSwapConflict
 }
 
 // --------------- find ---------------------------------
-// FindswapPending find by swap
-func FindswapPending(swap string) (*MgoSwap, error) {
+
+// FindSwap find swap by key
+func FindSwap(key string) (*MgoSwap, error) {
 	var res MgoSwap
-	//err := collectionSwapPending.Find(bson.M{"rpcMethod":swap}).One(&res)
-	err := collectionSwapPending.Find(nil).One(&res)
+	ctx, cancel := newContext()
+	defer cancel()
+	err := collectionSwap.FindOne(ctx, bson.M{"_id": key}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
-// FindAllSwapPending find SwapPending
-func findAllSwapPending(chain string) *mgo.Iter {
-	iter := collectionSwapPending.Find(bson.M{"chain": chain}).Iter()
-	return iter
+// FindSwapsByTxID find swaps of tx, txid is matched after normalized
+func FindSwapsByTxID(txid string) ([]*MgoSwap, error) {
+	result, err := findSwaps(collectionSwap, bson.M{"txid": storage.NormalizeTxID(txid)})
+	metrics.MongodbError("FindSwapsByTxID", err)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-// FindAllTokenAccounts find accounts
-func FindAllSwapPending(chain string, offset, limit int) ([]*MgoSwap, error) {
-	result := make([]*MgoSwap, 0, limit)
-	q := collectionSwapPending.Find(bson.M{"chain": chain}).Skip(offset).Limit(limit)
-	err := q.All(&result)
+// FindSwaps find swaps of chain in state by page, sorted by block number
+func FindSwaps(chain, state string, offset, limit int) ([]*MgoSwap, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "blockNumber", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).SetLimit(int64(limit))
+	result, err := findSwaps(collectionSwap, bson.M{"chain": chain, "state": state}, opts)
+	metrics.MongodbError("FindSwaps", err)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func UpdateSwapPending(swap *MgoSwap) {
-	RemoveSwapPending(swap)
+// FindSwapsByBlock find swaps of block
+func FindSwapsByBlock(chain, blockHash string) ([]*MgoSwap, error) {
+	result, err := findSwaps(collectionSwap, bson.M{"chain": chain, "blockHash": blockHash})
+	metrics.MongodbError("FindSwapsByBlock", err)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	swap.Timestamp = uint64(time.Now().Unix())
-	AddSwap(swap, false)
+// CountSwaps count swaps of chain in state
+func CountSwaps(chain, state string) (int, error) {
+	ctx, cancel := newContext()
+	defer cancel()
+	count, err := collectionSwap.CountDocuments(ctx, bson.M{"chain": chain, "state": state})
+	metrics.MongodbError("CountSwaps", err)
+	return int(count), err
 }
 
 func FindSyncedBlockNumber(chain string) (uint64, error) {
 	var res SyncedBlock
-	err := collectionSyncedBlock.Find(bson.M{"chain": chain}).One(&res)
+	ctx, cancel := newContext()
+	defer cancel()
+	err := collectionSyncedBlock.FindOne(ctx, bson.M{"chain": chain}).Decode(&res)
+	metrics.MongodbError("FindSyncedBlockNumber", err)
 	if err != nil {
 		return 0, errors.New("mgo find failed")
 	}
@@ -136,7 +195,7 @@ func InsertSyncedBlockNumber(chain string, number uint64) error {
 	sb.Id = chain
 	sb.Chain = chain
 	sb.BlockNumber = number
-	return collectionSyncedBlock.Insert(&sb)
+	return insert(collectionSyncedBlock, &sb)
 }
 
 func InitSyncedBlockNumber(chain string, number uint64) error {
@@ -148,13 +207,51 @@ func InitSyncedBlockNumber(chain string, number uint64) error {
 	sb.Id = chain
 	sb.Chain = chain
 	sb.BlockNumber = number
-	return collectionSyncedBlock.Insert(&sb)
+	return insert(collectionSyncedBlock, &sb)
 }
 
 func UpdateSyncedBlockNumber(chain string, number uint64) error {
 	selector := bson.M{"chain": chain}
 	data := bson.M{"$set": bson.M{"blocknumber": number}}
-	err := collectionSyncedBlock.Update(selector, data)
+	ctx, cancel := newContext()
+	defer cancel()
+	res, err := collectionSyncedBlock.UpdateOne(ctx, selector, data)
+	if err == nil && res.MatchedCount == 0 {
+		err = ErrNotFound
+	}
+	metrics.MongodbError("UpdateSyncedBlockNumber", err)
 	return err
 }
 
+// FindRangeJobs find slices of all range jobs of chain
+func FindRangeJobs(chain string) ([]*MgoRangeJob, error) {
+	var result []*MgoRangeJob
+	ctx, cancel := newContext()
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "start", Value: 1}, {Key: "end", Value: 1}, {Key: "from", Value: 1}})
+	cur, err := collectionRangeJob.Find(ctx, bson.M{"chain": chain}, opts)
+	if err == nil {
+		err = cur.All(ctx, &result)
+	}
+	metrics.MongodbError("FindRangeJobs", err)
+	return result, err
+}
+
+// UpsertRangeJob add or update slice of range job
+func UpsertRangeJob(job *MgoRangeJob) error {
+	job.Timestamp = uint64(time.Now().Unix())
+	err := TryDoTimes("UpsertRangeJob "+job.Id, func() error {
+		return upsertID(collectionRangeJob, job.Id, job)
+	})
+	metrics.MongodbError("UpsertRangeJob", err)
+	return err
+}
+
+// DeleteRangeJobs delete slices of range job [start, end)
+func DeleteRangeJobs(chain string, start, end uint64) error {
+	ctx, cancel := newContext()
+	defer cancel()
+	_, err := collectionRangeJob.DeleteMany(ctx, bson.M{"chain": chain, "start": start, "end": end})
+	metrics.MongodbError("DeleteRangeJobs", err)
+	return err
+}
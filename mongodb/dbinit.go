@@ -1,96 +1,117 @@
 package mongodb
 
 import (
-	"fmt"
+	"context"
+	"strings"
 	"time"
 
 	"github.com/anyswap/CrossChain-Bridge/log"
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+const defaultOpTimeout = 10 * time.Second
+
 var (
-	database *mgo.Database
-	session  *mgo.Session
+	client   *mongo.Client
+	database *mongo.Database
 
-	dialInfo *mgo.DialInfo
+	opTimeout = defaultOpTimeout
 )
 
-// MongoServerInit int mongodb server session
+// SetOpTimeout set timeout of every mongodb operation, call it before init
+func SetOpTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		opTimeout = timeout
+	}
+}
+
+// newContext context of one mongodb operation
+func newContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), opTimeout)
+}
+
+// MongoServerInit int mongodb server session,
+// addrs are host:port items, or a connection uri like 'mongodb://host1,host2/?replicaSet=rs0&tls=true'.
+// The client reconnects and fails over replica set members by itself.
 func MongoServerInit(addrs []string, dbname, user, pass string) {
 	log.Info("MongoServerInit", "dbname", dbname)
-	initDialInfo(addrs, dbname, user, pass)
-	mongoConnect()
+	opts := clientOptions(addrs, dbname, user, pass)
+	mongoConnect(opts, dbname)
+	if err := migrate(database); err != nil {
+		log.Fatal("[mongodb] migrate failed", "err", err)
+	}
 	initCollections()
-	go checkMongoSession()
 }
 
-func initDialInfo(addrs []string, db, user, pass string) {
-	dialInfo = &mgo.DialInfo{
-		Addrs:    addrs,
-		Database: db,
-		Username: user,
-		Password: pass,
+func clientOptions(addrs []string, dbname, user, pass string) *options.ClientOptions {
+	uri := strings.Join(addrs, ",")
+	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		uri = "mongodb://" + uri
+	}
+	opts := options.Client().ApplyURI(uri)
+	if user != "" {
+		cred := options.Credential{
+			AuthSource: dbname, // same as mgo, use the uri 'authSource' option to specify another one
+			Username:   user,
+			Password:   pass,
+		}
+		if opts.Auth != nil {
+			if opts.Auth.AuthSource != "" {
+				cred.AuthSource = opts.Auth.AuthSource
+			}
+			cred.AuthMechanism = opts.Auth.AuthMechanism
+			cred.AuthMechanismProperties = opts.Auth.AuthMechanismProperties
+		}
+		opts.SetAuth(cred)
+	}
+	if opts.WriteConcern == nil {
+		opts.SetWriteConcern(writeconcern.Journaled())
 	}
+	opts.SetConnectTimeout(opTimeout)
+	opts.SetServerSelectionTimeout(opTimeout)
+	return opts
 }
 
-func mongoConnect() {
-	if session != nil { // when reconnect
-		session.Close()
-	}
-	log.Info("[mongodb] connect database start.", "addrs", dialInfo.Addrs, "dbName", dialInfo.Database)
+func mongoConnect(opts *options.ClientOptions, dbname string) {
+	log.Info("[mongodb] connect database start.", "hosts", opts.Hosts, "replicaSet", opts.ReplicaSet, "tls", opts.TLSConfig != nil, "dbName", dbname)
 	var err error
 	for {
-		session, err = mgo.DialWithInfo(dialInfo)
+		err = tryConnect(opts)
 		if err == nil {
 			break
 		}
 		log.Warn("[mongodb] dial error", "err", err)
 		time.Sleep(1 * time.Second)
 	}
-	session.SetMode(mgo.Monotonic, true)
-	session.SetSafe(&mgo.Safe{FSync: true})
-	database = session.DB(dialInfo.Database)
-	deinintCollections()
-	log.Info("[mongodb] connect database finished.", "dbName", dialInfo.Database)
+	database = client.Database(dbname)
+	log.Info("[mongodb] connect database finished.", "dbName", dbname)
 }
 
-// fix 'read tcp 127.0.0.1:43502->127.0.0.1:27917: i/o timeout'
-func checkMongoSession() {
-	for {
-		time.Sleep(60 * time.Second)
-		if err := ensureMongoConnected(); err != nil {
-			log.Warn("[mongodb] check session error", "err", err)
-			log.Info("[mongodb] reconnect database", "dbName", dialInfo.Database)
-			mongoConnect()
-		}
+func tryConnect(opts *options.ClientOptions) error {
+	ctx, cancel := newContext()
+	defer cancel()
+	cli, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return err
 	}
-}
-
-func sessionPing() (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("recover from error %v", r)
-		}
-	}()
-	for i := 0; i < 6; i++ {
-		err = session.Ping()
-		if err == nil {
-			break
-		}
-		time.Sleep(10 * time.Second)
+	if err = cli.Ping(ctx, nil); err != nil {
+		_ = cli.Disconnect(context.Background())
+		return err
 	}
-	return err
+	client = cli
+	return nil
 }
 
-func ensureMongoConnected() (err error) {
-	err = sessionPing()
-	if err != nil {
-		log.Error("[mongodb] session ping error", "err", err)
-		log.Info("[mongodb] refresh session.", "dbName", dialInfo.Database)
-		session.Refresh()
-		database = session.DB(dialInfo.Database)
-		deinintCollections()
-		err = sessionPing()
+// MongoServerClose disconnect from mongodb
+func MongoServerClose() {
+	if client == nil {
+		return
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	if err := client.Disconnect(ctx); err != nil {
+		log.Warn("[mongodb] disconnect error", "err", err)
 	}
-	return err
 }
@@ -0,0 +1,196 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/weijun-sh/gethscan/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	tbMigrations = "migrations"
+	migrationID  = "version"
+
+	errCodeNamespaceNotFound = 26
+	errCodeIndexNotFound     = 27
+)
+
+// migrations in order, the applied version is kept in migrations collection.
+// Indexes are created by `initCollections` after migrating.
+var migrations = []func(*mongo.Database) error{
+	dropLegacyIndexes,
+	migrateSwapStates,
+	rekeySwaps,
+}
+
+// legacy swap collections, they are merged into swap collection by migrateSwapStates.
+// Swaps in swap collection take precedence over pending and unconfirmed swaps,
+// and deleted swaps take precedence over all.
+var legacySwapCollections = []struct {
+	table     string
+	overwrite bool
+}{
+	{storage.LegacySwap, true},
+	{storage.LegacyPending, false},
+	{storage.LegacyUnconfirmed, false},
+	{storage.LegacyDeleted, true},
+}
+
+type migrationVersion struct {
+	Id      string `bson:"_id"`
+	Version int    `bson:"version"`
+}
+
+// migrate apply the migrations which are not applied
+func migrate(db *mongo.Database) error {
+	collection := db.Collection(tbMigrations)
+	var applied migrationVersion
+	ctx, cancel := newContext()
+	err := collection.FindOne(ctx, bson.M{"_id": migrationID}).Decode(&applied)
+	cancel()
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	for version := applied.Version + 1; version <= len(migrations); version++ {
+		if err = migrations[version-1](db); err != nil {
+			return fmt.Errorf("migration %v failed: %w", version, err)
+		}
+		ctx, cancel = newContext()
+		_, err = collection.ReplaceOne(ctx, bson.M{"_id": migrationID},
+			&migrationVersion{Id: migrationID, Version: version}, options.Replace().SetUpsert(true))
+		cancel()
+		if err != nil {
+			return err
+		}
+		log.Info("[mongodb] apply migration success", "version", version)
+	}
+	return nil
+}
+
+// dropLegacyIndexes drop the indexes created by mgo, 'txid' is not a field of swaps,
+// and the index of synced block chain is replaced by an unique one of the same name.
+func dropLegacyIndexes(db *mongo.Database) error {
+	legacy := map[string]string{
+		tbSwap:                "txid_1",
+		storage.LegacyPending: "txid_1",
+		storage.LegacyDeleted: "txid_1",
+		tbSyncedBlock:         "chain_1",
+	}
+	for table, index := range legacy {
+		ctx, cancel := newContext()
+		_, err := db.Collection(table).Indexes().DropOne(ctx, index)
+		cancel()
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.Code == errCodeNamespaceNotFound || cmdErr.Code == errCodeIndexNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("drop index %v of %v: %w", index, table, err)
+		}
+		log.Info("[mongodb] drop legacy index", "collection", table, "index", index)
+	}
+	return nil
+}
+
+// migrateSwapStates set state of swaps and move them into swap collection,
+// every swap is written before removed from the legacy collection, so it's safe to run again if interrupted.
+func migrateSwapStates(db *mongo.Database) error {
+	for _, item := range legacySwapCollections {
+		collection := db.Collection(item.table)
+		filter := bson.M{}
+		if item.table == tbSwap {
+			filter = bson.M{"state": bson.M{"$exists": false}}
+		}
+		cur, err := collection.Find(context.Background(), filter)
+		if err != nil {
+			return err
+		}
+		count := 0
+		for cur.Next(context.Background()) {
+			var ms MgoSwap
+			if err = cur.Decode(&ms); err != nil {
+				break
+			}
+			storage.MigrateLegacySwap(&ms, item.table)
+			ms.Version = 1
+			if item.overwrite {
+				err = upsertID(db.Collection(tbSwap), ms.Id, &ms)
+			} else if err = insert(db.Collection(tbSwap), &ms); mongo.IsDuplicateKeyError(err) {
+				err = nil
+			}
+			if err == nil && item.table != tbSwap {
+				if err = removeID(collection, ms.Id); errors.Is(err, ErrNotFound) {
+					err = nil
+				}
+			}
+			if err != nil {
+				break
+			}
+			count++
+		}
+		if err == nil {
+			err = cur.Err()
+		}
+		_ = cur.Close(context.Background())
+		if err != nil {
+			return fmt.Errorf("migrate swaps of %v: %w", item.table, err)
+		}
+		if item.table != tbSwap {
+			ctx, cancel := newContext()
+			err = collection.Drop(ctx)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+		log.Info("[mongodb] migrate swap states", "collection", item.table, "count", count)
+	}
+	return nil
+}
+
+// rekeySwaps key the swaps by storage.SwapKey instead of txid, the txid is kept in swap.
+// The rekeyed swap is inserted before the old one is removed, so it's safe to run again if interrupted.
+func rekeySwaps(db *mongo.Database) error {
+	collection := db.Collection(tbSwap)
+	cur, err := collection.Find(context.Background(), bson.M{"txid": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	count := 0
+	for cur.Next(context.Background()) {
+		var ms MgoSwap
+		if err = cur.Decode(&ms); err != nil {
+			break
+		}
+		txid := ms.Id
+		if !storage.RekeyLegacySwap(&ms) {
+			continue
+		}
+		if err = insert(collection, &ms); mongo.IsDuplicateKeyError(err) {
+			err = nil
+		}
+		if err == nil {
+			if err = removeID(collection, txid); errors.Is(err, ErrNotFound) {
+				err = nil
+			}
+		}
+		if err != nil {
+			break
+		}
+		count++
+	}
+	if err == nil {
+		err = cur.Err()
+	}
+	_ = cur.Close(context.Background())
+	if err != nil {
+		return fmt.Errorf("rekey swaps: %w", err)
+	}
+	log.Info("[mongodb] rekey swaps", "count", count)
+	return nil
+}
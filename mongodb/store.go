@@ -0,0 +1,87 @@
+package mongodb
+
+import (
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+// Store implements storage.Store with the mongodb collections
+type Store struct{}
+
+var _ storage.Store = (*Store)(nil)
+
+// NewStore connect mongodb, migrate and return store
+func NewStore(addrs []string, dbname, user, pass string) *Store {
+	MongoServerInit(addrs, dbname, user, pass)
+	return &Store{}
+}
+
+// AddSwap implements storage.Store
+func (s *Store) AddSwap(swap *storage.Swap) error {
+	return AddSwap(swap)
+}
+
+// UpdateSwap implements storage.Store
+func (s *Store) UpdateSwap(key string, f func(swap *storage.Swap) error) (*storage.Swap, error) {
+	return UpdateSwap(key, f)
+}
+
+// FindSwap implements storage.Store
+func (s *Store) FindSwap(key string) (*storage.Swap, error) {
+	return FindSwap(key)
+}
+
+// FindSwapsByTxID implements storage.Store
+func (s *Store) FindSwapsByTxID(txid string) ([]*storage.Swap, error) {
+	return FindSwapsByTxID(txid)
+}
+
+// FindSwaps implements storage.Store
+func (s *Store) FindSwaps(chain, state string, offset, limit int) ([]*storage.Swap, error) {
+	return FindSwaps(chain, state, offset, limit)
+}
+
+// FindSwapsByBlock implements storage.Store
+func (s *Store) FindSwapsByBlock(chain, blockHash string) ([]*storage.Swap, error) {
+	return FindSwapsByBlock(chain, blockHash)
+}
+
+// CountSwaps implements storage.Store
+func (s *Store) CountSwaps(chain, state string) (int, error) {
+	return CountSwaps(chain, state)
+}
+
+// FindSyncedBlockNumber implements storage.Store
+func (s *Store) FindSyncedBlockNumber(chain string) (uint64, error) {
+	return FindSyncedBlockNumber(chain)
+}
+
+// InitSyncedBlockNumber implements storage.Store
+func (s *Store) InitSyncedBlockNumber(chain string, number uint64) error {
+	return InitSyncedBlockNumber(chain, number)
+}
+
+// UpdateSyncedBlockNumber implements storage.Store
+func (s *Store) UpdateSyncedBlockNumber(chain string, number uint64) error {
+	return UpdateSyncedBlockNumber(chain, number)
+}
+
+// FindRangeJobs implements storage.Store
+func (s *Store) FindRangeJobs(chain string) ([]*storage.RangeJob, error) {
+	return FindRangeJobs(chain)
+}
+
+// UpsertRangeJob implements storage.Store
+func (s *Store) UpsertRangeJob(job *storage.RangeJob) error {
+	return UpsertRangeJob(job)
+}
+
+// DeleteRangeJobs implements storage.Store
+func (s *Store) DeleteRangeJobs(chain string, start, end uint64) error {
+	return DeleteRangeJobs(chain, start, end)
+}
+
+// Close implements storage.Store
+func (s *Store) Close() error {
+	MongoServerClose()
+	return nil
+}
@@ -1,34 +1,46 @@
 package mongodb
 
 import (
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
 )
 
 var (
-	collectionSwap        *mgo.Collection
-	collectionSwapPending *mgo.Collection
-	collectionSwapDeleted *mgo.Collection
-	collectionSyncedBlock *mgo.Collection
+	collectionSwap        *mongo.Collection
+	collectionSyncedBlock *mongo.Collection
+	collectionRangeJob    *mongo.Collection
 )
 
-// do this when reconnect to the database
-func deinintCollections() {
-	collectionSwap = database.C(tbSwap)
-	collectionSwapPending = database.C(tbSwapPending)
-	collectionSwapDeleted = database.C(tbSwapDeleted)
-	collectionSyncedBlock = database.C(tbSyncedBlock)
+// swaps of orphaned blocks are found by block hash, and swaps of state are sorted by block number
+var swapIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "txid", Value: 1}}},
+	{Keys: bson.D{{Key: "chain", Value: 1}, {Key: "blockHash", Value: 1}}},
+	{Keys: bson.D{{Key: "chain", Value: 1}, {Key: "state", Value: 1}, {Key: "blockNumber", Value: 1}}},
 }
 
 func initCollections() {
-	initCollection(tbSwap, &collectionSwap, "txid")
-	initCollection(tbSwapPending, &collectionSwapPending, "txid")
-	initCollection(tbSwapDeleted, &collectionSwapDeleted, "txid")
-	initCollection(tbSyncedBlock, &collectionSyncedBlock, "chain")
+	initCollection(tbSwap, &collectionSwap, swapIndexes...)
+	initCollection(tbSyncedBlock, &collectionSyncedBlock,
+		mongo.IndexModel{Keys: bson.D{{Key: "chain", Value: 1}}, Options: options.Index().SetUnique(true)})
+	initCollection(tbRangeJob, &collectionRangeJob,
+		mongo.IndexModel{Keys: bson.D{{Key: "chain", Value: 1}, {Key: "start", Value: 1}, {Key: "end", Value: 1}, {Key: "from", Value: 1}}})
 }
 
-func initCollection(table string, collection **mgo.Collection, indexKey ...string) {
-	*collection = database.C(table)
-	if len(indexKey) != 0 && indexKey[0] != "" {
-		_ = (*collection).EnsureIndexKey(indexKey...)
+// initCollection create the missing indexes, existing indexes are kept
+func initCollection(table string, collection **mongo.Collection, indexes ...mongo.IndexModel) {
+	*collection = database.Collection(table)
+	if len(indexes) == 0 {
+		return
+	}
+	ctx, cancel := newContext()
+	defer cancel()
+	names, err := (*collection).Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		log.Warn("[mongodb] create indexes failed", "collection", table, "err", err)
+		return
 	}
+	log.Info("[mongodb] create indexes success", "collection", table, "indexes", names)
 }
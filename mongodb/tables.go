@@ -1,30 +1,28 @@
 package mongodb
 
 import (
-//"gopkg.in/mgo.v2/bson"
+	"github.com/weijun-sh/gethscan/storage"
 )
 
 const (
-	tbSwap        string = "swap"
-	tbSwapPending string = "pending"
-	tbSwapDeleted string = "deleted"
+	tbSwap        string = "swap" // swaps of all states
 	tbSyncedBlock string = "syncedBlock"
+	tbRangeJob    string = "rangeJob"
 )
 
-type MgoSwap struct {
-	Id         string `bson:"_id"` //txid
-	PairID     string `bson:"pairID"`    //"FXSv4"
-	RpcMethod  string `bson:"rpcMethod"` //"swap.Swapin"
-	SwapServer string `bson:"swapServer"`
-        ChainID    string `bson:"chainid"`
-        LogIndex   string `bson:"logIndex"`
-	Chain      string `bson:"chain"`
-	Timestamp  uint64 `bson:"timestamp"`
-}
+// MgoSwap swap record in swap collections
+type MgoSwap = storage.Swap
+
+// MgoSwapDetail decoded payload of router swap event
+type MgoSwapDetail = storage.SwapDetail
 
+// SyncedBlock the field names are lowercase of struct fields as before, '_id' is generated
 type SyncedBlock struct {
-	Id          string `bson: "_id"` //"chain"
-	Chain       string `bson: "chain"`
-	BlockNumber uint64 `bson: "blocknumber"`
+	Id          string `bson:"id"` //"chain"
+	Chain       string `bson:"chain"`
+	BlockNumber uint64 `bson:"blocknumber"`
 }
 
+
+// MgoRangeJob checkpoint of a slice of range job
+type MgoRangeJob = storage.RangeJob
@@ -1,11 +1,16 @@
 package params
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/BurntSushi/toml"
 	"github.com/anyswap/CrossChain-Bridge/common"
@@ -23,35 +28,143 @@ const (
 	TxRouterGas = "gasswap"
 )
 
+// chain types
+const (
+	ChainTypeEVM   = "evm"
+	ChainTypeAptos = "aptos"
+)
+
 var (
 	configFile string
-	scanConfig = &ScanConfig{}
+	chainConfigs []*ChainConfig
+	multiChain bool
 	mongodbConfig = &MongoDBConfig{}
-	blockchainConfig = &BlockChainConfig{}
-	HaveReloadConfig bool = false
+	outboxConfig = &OutboxConfig{}
+	metricsConfig = &MetricsConfig{}
+	adminConfig = &AdminConfig{}
+	postQueueConfig = &PostQueueConfig{}
+	configHash string
 	reloadMutex sync.Mutex
 )
 
 type Config struct {
        MongoDB *MongoDBConfig
+	Storage *StorageConfig `toml:",omitempty" json:",omitempty"` // storage of swaps and synced block numbers, mongodb by default
 	BlockChain *BlockChainConfig
+	Chains []*ChainConfig `toml:",omitempty" json:",omitempty"` // multi-chain mode, exclusive with 'BlockChain' and 'Tokens'
+	Outbox *OutboxConfig
+	Metrics *MetricsConfig
+	Admin *AdminConfig
+	PostQueue *PostQueueConfig
+	PostErrorRules []*PostErrorRule
+	Sinks []*SinkConfig `toml:",omitempty" json:",omitempty"` // extra swap sinks chosen by token 'Sinks'
+	SwapServers []*SwapServerConfig `toml:",omitempty" json:",omitempty"` // circuit breakers and rate limits of swap servers
        Tokens  []*TokenConfig
 }
 
 // MongoDBConfig mongodb config
 type MongoDBConfig struct {
-       DBURL      string
+       DBURL      string // host:port, or uri like 'mongodb://host1,host2/?replicaSet=rs0&tls=true&tlsCAFile=ca.pem'
        DBName     string
        UserName   string `json:"-"`
        Password   string `json:"-"`
 	Enable    bool
+	Timeout   uint64 // seconds timeout of every mongodb operation, default 10
 }
 
 type BlockChainConfig struct {
 	Chain string
+	ChainType string // 'evm' (default) or 'aptos'
 	StableHeight uint64
 	ScanBackHeight uint64
 	SyncNumber uint64
+	GetLogsMaxBlocks uint64 // max blocks every getLogs in log scan mode
+	GetLogsInterval uint64 // seconds interval every getLogs in log scan mode
+	ReorgDepth uint64 // max blocks of chain reorganization to detect
+
+	Gateways []string `toml:",omitempty" json:",omitempty"` // gateway pool, '--gateway' is prepended if specified
+	GatewayMaxLag uint64 // max blocks a gateway can fall behind the highest one
+	GatewayCheckInterval uint64 // seconds interval of gateway health checking
+	DisableBloomFilter bool // do not skip receipts and logs by block logs bloom
+	EventABIDir string // dir of extra router event abi files, placed as '<dir>/<txType>/<name>.json'
+	TraceMethod string // 'debug_traceBlockByNumber' or 'trace_block' to detect native swapins of internal txs, empty to disable
+	SubscribeNewHeads bool // scan new blocks on newHeads of websocket gateways, fall back to polling if the subscription drops
+	ConfirmPolicy string // post swaps after their blocks are 'depth' ('StableHeight' blocks deep), 'finalized' or 'safe', empty to post on detection
+}
+
+// ChainConfig config of one chain, several chains are scanned in one process with '[[Chains]]'
+type ChainConfig struct {
+	Gateway    string // the first gateway, '--gateway' in single chain mode
+	BlockChain *BlockChainConfig
+	Tokens     []*TokenConfig
+
+	scanConfig atomic.Value // *ScanConfig, replaced as a whole when reloading config
+}
+
+// NewChainConfig new chain config with checked tokens, eg. config of the embedded scanner
+func NewChainConfig(bcConfig *BlockChainConfig, tokens []*TokenConfig) (*ChainConfig, error) {
+	scanConfig := &ScanConfig{Tokens: tokens}
+	if err := scanConfig.CheckConfig(bcConfig); err != nil {
+		return nil, err
+	}
+	chainCfg := &ChainConfig{
+		BlockChain: bcConfig,
+		Tokens:     tokens,
+	}
+	chainCfg.scanConfig.Store(scanConfig)
+	return chainCfg, nil
+}
+
+// GetScanConfig get scan config of chain, do not keep it as it's replaced when reloading config
+func (c *ChainConfig) GetScanConfig() *ScanConfig {
+	if scanConfig, ok := c.scanConfig.Load().(*ScanConfig); ok {
+		return scanConfig
+	}
+	return &ScanConfig{}
+}
+
+func (c *ChainConfig) setScanConfig(tokens []*TokenConfig) {
+	c.scanConfig.Store(&ScanConfig{Tokens: tokens})
+}
+
+// OutboxConfig outbox of failed swap posts
+type OutboxConfig struct {
+	File             string // default 'outbox-<chain>.log', '-<chain>' is appended in multi-chain mode
+	MaxAttempts      int    // move to dead letters after max attempts
+	RetryInterval    uint64 // seconds of first retry, doubled every retry
+	MaxRetryInterval uint64 // seconds of max retry interval
+}
+
+// MetricsConfig prometheus metrics config
+type MetricsConfig struct {
+	Listen string // eg. "127.0.0.1:9091", empty to disable
+}
+
+// AdminConfig admin api config
+type AdminConfig struct {
+	Listen string // eg. "127.0.0.1:9092", empty to disable
+	Token  string `toml:",omitempty" json:"-"` // bearer token of requests, required if not listening on loopback
+}
+
+// CheckConfig check admin config, the admin api can only be exposed with token
+func (c *AdminConfig) CheckConfig() error {
+	if c.Listen == "" || c.Token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return fmt.Errorf("wrong admin 'Listen' %v: %w", c.Listen, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("admin 'Token' is required to listen on %v", c.Listen)
+	}
+	return nil
+}
+
+// PostQueueConfig queue and workers of posting detected swaps
+type PostQueueConfig struct {
+	Workers int // concurrent posts of every chain, default 8
+	Size    int // max queued swaps of every chain, scanning waits if the queue is full, default 1000
 }
 
 // ScanConfig scan config
@@ -73,8 +186,12 @@ type TokenConfig struct {
 	DepositAddress string `toml:",omitempty" json:",omitempty"`
 
 	// router
-	ChainID        string `toml:",omitempty" json:",omitempty"`
-	RouterContract string `toml:",omitempty" json:",omitempty"`
+	ChainID        string   `toml:",omitempty" json:",omitempty"`
+	RouterContract string   `toml:",omitempty" json:",omitempty"`
+	EventTypes     []string `toml:",omitempty" json:",omitempty"` // router event types of non evm chains
+
+	// sink names, the swap server of 'SwapServer' if empty
+	Sinks []string `toml:",omitempty" json:",omitempty"`
 }
 
 // GetMongodbConfig get mongodb config
@@ -82,9 +199,58 @@ func GetMongodbConfig() *MongoDBConfig {
        return mongodbConfig
 }
 
-// GetBlockChainConfig get blockchain config
-func GetBlockChainConfig() *BlockChainConfig {
-       return blockchainConfig
+// GetChainConfigs get configs of the chains to scan
+func GetChainConfigs() []*ChainConfig {
+	return chainConfigs
+}
+
+// IsMultiChain is multi-chain mode
+func IsMultiChain() bool {
+	return multiChain
+}
+
+// IsAptos is aptos chain
+func (c *BlockChainConfig) IsAptos() bool {
+	return c != nil && strings.EqualFold(c.ChainType, ChainTypeAptos)
+}
+
+// GetOutboxConfig get outbox config
+func GetOutboxConfig() *OutboxConfig {
+	return outboxConfig
+}
+
+// GetMetricsConfig get metrics config
+func GetMetricsConfig() *MetricsConfig {
+	return metricsConfig
+}
+
+// GetPostQueueConfig get post queue config
+func GetPostQueueConfig() *PostQueueConfig {
+	return postQueueConfig
+}
+
+// GetAdminConfig get admin api config
+func GetAdminConfig() *AdminConfig {
+	return adminConfig
+}
+
+// GetConfigHash get sha256 hash of the loaded config file
+func GetConfigHash() string {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	return configHash
+}
+
+func updateConfigHash(filePath string) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Warn("read config file failed", "file", filePath, "err", err)
+		return
+	}
+	hash := sha256.Sum256(data)
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	configHash = hex.EncodeToString(hash[:])
 }
 
 // IsNativeToken is native token
@@ -92,13 +258,57 @@ func (c *TokenConfig) IsNativeToken() bool {
 	return c.TokenAddress == "native"
 }
 
-// GetScanConfig get scan config
-func GetScanConfig() *ScanConfig {
-	return scanConfig
+// getChainConfigs get chain configs of '[[Chains]]', or of 'BlockChain' and 'Tokens' in single chain mode
+func (c *Config) getChainConfigs() ([]*ChainConfig, error) {
+	if len(c.Chains) == 0 {
+		if c.BlockChain == nil {
+			return nil, errors.New("no 'BlockChain' or 'Chains' config")
+		}
+		return []*ChainConfig{{BlockChain: c.BlockChain, Tokens: c.Tokens}}, nil
+	}
+	if c.BlockChain != nil || len(c.Tokens) != 0 {
+		return nil, errors.New("'BlockChain' and 'Tokens' can not be used with 'Chains'")
+	}
+	exist := make(map[string]struct{})
+	for _, chainCfg := range c.Chains {
+		if chainCfg.BlockChain == nil || chainCfg.BlockChain.Chain == "" {
+			return nil, errors.New("empty 'Chain' of 'Chains' config")
+		}
+		name := strings.ToLower(chainCfg.BlockChain.Chain)
+		if _, ok := exist[name]; ok {
+			return nil, fmt.Errorf("duplicate chain '%v' of 'Chains' config", chainCfg.BlockChain.Chain)
+		}
+		exist[name] = struct{}{}
+		if chainCfg.Gateway == "" && len(chainCfg.BlockChain.Gateways) == 0 {
+			return nil, fmt.Errorf("no gateway of chain '%v'", chainCfg.BlockChain.Chain)
+		}
+	}
+	return c.Chains, nil
+}
+
+// checkChainConfigs check tokens of chains
+func checkChainConfigs(chains []*ChainConfig) error {
+	for _, chainCfg := range chains {
+		scanConfig := &ScanConfig{Tokens: chainCfg.Tokens}
+		if err := scanConfig.CheckConfig(chainCfg.BlockChain); err != nil {
+			return fmt.Errorf("chain '%v': %w", chainCfg.BlockChain.Chain, err)
+		}
+	}
+	return nil
+}
+
+// findChainConfig find chain config by name
+func findChainConfig(chains []*ChainConfig, name string) *ChainConfig {
+	for _, chainCfg := range chains {
+		if strings.EqualFold(chainCfg.BlockChain.Chain, name) {
+			return chainCfg
+		}
+	}
+	return nil
 }
 
 // LoadConfig load config
-func LoadConfig(filePath string) *ScanConfig {
+func LoadConfig(filePath string) {
 	log.Println("LoadConfig Config file is", filePath)
 	if !common.FileExist(filePath) {
 		log.Fatalf("LoadConfig error: config file '%v' not exist", filePath)
@@ -118,54 +328,119 @@ func LoadConfig(filePath string) *ScanConfig {
 	log.Println("LoadConfig finished.", string(bs))
 
        mongodbConfig = config.MongoDB
-	blockchainConfig = config.BlockChain
-       scanConfig.Tokens = config.Tokens
-
-       if err := scanConfig.CheckConfig(); err != nil {
+	storage, err := config.getStorageConfig()
+	if err != nil {
+		log.Fatalf("LoadConfig Check storage config failed. %v", err)
+	}
+	storageConfig = storage
+	if config.Outbox != nil {
+		outboxConfig = config.Outbox
+	}
+	if config.Metrics != nil {
+		metricsConfig = config.Metrics
+	}
+	if config.Admin != nil {
+		if err = config.Admin.CheckConfig(); err != nil {
+			log.Fatalf("LoadConfig Check admin config failed. %v", err)
+		}
+		adminConfig = config.Admin
+	}
+	if config.PostQueue != nil {
+		if config.PostQueue.Workers < 0 || config.PostQueue.Size < 0 {
+			log.Fatalf("LoadConfig Check post queue config failed. negative 'Workers' or 'Size'")
+		}
+		postQueueConfig = config.PostQueue
+	}
+	chains, err := config.getChainConfigs()
+	if err != nil {
+		log.Fatalf("LoadConfig Check chains config failed. %v", err)
+	}
+	if err = checkChainConfigs(chains); err != nil {
 		log.Fatalf("LoadConfig Check config failed. %v", err)
 	}
+	if err = checkSinkConfigs(config.Sinks); err != nil {
+		log.Fatalf("LoadConfig Check sinks config failed. %v", err)
+	}
+	if err = checkTokenSinks(chains, config.Sinks); err != nil {
+		log.Fatalf("LoadConfig Check token sinks failed. %v", err)
+	}
+	sinkConfigs = config.Sinks
+	for _, chainCfg := range chains {
+		chainCfg.setScanConfig(chainCfg.Tokens)
+	}
+	chainConfigs = chains
+	multiChain = len(config.Chains) != 0
+	if err := checkPostErrorRules(config.PostErrorRules); err != nil {
+		log.Fatalf("LoadConfig Check post error rules failed. %v", err)
+	}
+	setPostErrorRules(config.PostErrorRules)
+	if err := checkSwapServerConfigs(config.SwapServers); err != nil {
+		log.Fatalf("LoadConfig Check swap servers config failed. %v", err)
+	}
+	setSwapServerConfigs(config.SwapServers)
 
 	configFile = filePath // init config file path
-	return scanConfig
+	updateConfigHash(filePath)
 }
 
 // ReloadConfig reload config
-func ReloadConfig() {
+func ReloadConfig() error {
 	log.Println("ReloadConfig Config file is", configFile)
 	if !common.FileExist(configFile) {
 		log.Errorf("ReloadConfig error: config file '%v' not exist", configFile)
-		return
+		return fmt.Errorf("config file '%v' not exist", configFile)
 	}
 
 	config := &Config{}
 	if _, err := toml.DecodeFile(configFile, &config); err != nil {
 		log.Errorf("ReloadConfig error (toml DecodeFile): %v", err)
-		return
+		return err
 	}
 
-       scanConfig.Tokens = config.Tokens
-       if err := scanConfig.CheckConfig(); err != nil {
+	chains, err := config.getChainConfigs()
+	if err == nil {
+		err = checkChainConfigs(chains)
+	}
+	if err != nil {
 		log.Errorf("ReloadConfig Check config failed. %v", err)
-		return
+		return err
+	}
+	if err := checkPostErrorRules(config.PostErrorRules); err != nil {
+		log.Errorf("ReloadConfig Check post error rules failed. %v", err)
+		return err
+	}
+	// sinks are created at startup, tokens can only choose the existing sinks
+	if err := checkTokenSinks(chains, sinkConfigs); err != nil {
+		log.Errorf("ReloadConfig Check token sinks failed. %v", err)
+		return err
+	}
+	for _, sink := range config.Sinks {
+		if findSinkConfig(sinkConfigs, sink.Name) == nil {
+			log.Warnf("ReloadConfig ignore new sink '%v', restart to use it", sink.Name)
+		}
+	}
+	if err := checkSwapServerConfigs(config.SwapServers); err != nil {
+		log.Errorf("ReloadConfig Check swap servers config failed. %v", err)
+		return err
 	}
-	UpdateHaveReloadConfig(true)
+	setPostErrorRules(config.PostErrorRules)
+	setSwapServerConfigs(config.SwapServers)
+	// only tokens are reloaded, adding or removing chains needs restart
+	for _, chainCfg := range chains {
+		old := findChainConfig(chainConfigs, chainCfg.BlockChain.Chain)
+		if old == nil {
+			log.Warnf("ReloadConfig ignore new chain '%v', restart to scan it", chainCfg.BlockChain.Chain)
+			continue
+		}
+		old.setScanConfig(chainCfg.Tokens)
+	}
+	updateConfigHash(configFile)
 	log.Println("ReloadConfig success.")
-}
-
-func UpdateHaveReloadConfig(ok bool) {
-	reloadMutex.Lock()
-	defer reloadMutex.Unlock()
-	HaveReloadConfig = ok
-}
-
-func GetHaveReloadConfig() bool {
-	reloadMutex.Lock()
-	defer reloadMutex.Unlock()
-	return HaveReloadConfig
+	return nil
 }
 
 // CheckConfig check scan config
-func (c *ScanConfig) CheckConfig() (err error) {
+func (c *ScanConfig) CheckConfig(bcConfig *BlockChainConfig) (err error) {
 	if len(c.Tokens) == 0 {
 		return errors.New("no token config exist")
 	}
@@ -174,7 +449,7 @@ func (c *ScanConfig) CheckConfig() (err error) {
 	routerswapMap := make(map[string]struct{})
 	exist := false
 	for _, tokenCfg := range c.Tokens {
-		err = tokenCfg.CheckConfig()
+		err = tokenCfg.CheckConfig(bcConfig)
 		if err != nil {
 			return err
 		}
@@ -267,13 +542,24 @@ func (c *TokenConfig) IsRouterAnycallSwap() bool {
 }
 
 // CheckConfig check token config
-func (c *TokenConfig) CheckConfig() error {
+func (c *TokenConfig) CheckConfig(bcConfig *BlockChainConfig) error {
 	if !c.IsValidSwapType() {
 		return errors.New("invalid 'TxType' " + c.TxType)
 	}
 	if c.SwapServer == "" {
 		return errors.New("empty 'SwapServer'")
 	}
+	sinks := make(map[string]struct{})
+	for _, name := range c.Sinks {
+		key := strings.ToLower(name)
+		if _, exist := sinks[key]; exist || name == "" {
+			return errors.New("empty or duplicate sink name " + name)
+		}
+		sinks[key] = struct{}{}
+	}
+	if bcConfig.IsAptos() {
+		return c.checkAptosConfig()
+	}
 	if c.CallByContract != "" && !common.IsHexAddress(c.CallByContract) {
 		return errors.New("wrong 'CallByContract' " + c.CallByContract)
 	}
@@ -306,3 +592,23 @@ func (c *TokenConfig) CheckConfig() error {
 	}
 	return nil
 }
+
+// checkAptosConfig check token config of aptos chain
+func (c *TokenConfig) checkAptosConfig() error {
+	if !c.IsRouterSwapAll() {
+		return errors.New("only router swap is supported on aptos, 'TxType' " + c.TxType)
+	}
+	if len(c.EventTypes) == 0 {
+		return errors.New("empty 'EventTypes' of aptos router " + c.RouterContract)
+	}
+	for _, eventType := range c.EventTypes {
+		// eg. 0x1::coin::DepositEvent
+		if len(strings.Split(eventType, "::")) != 3 {
+			return errors.New("wrong aptos event type " + eventType)
+		}
+	}
+	if _, err := common.GetBigIntFromStr(c.ChainID); err != nil {
+		return fmt.Errorf("wrong chainID '%v', %w", c.ChainID, err)
+	}
+	return nil
+}
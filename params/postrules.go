@@ -0,0 +1,96 @@
+package params
+
+import (
+	"errors"
+	"fmt"
+)
+
+// outcomes of posting swap to swap server
+const (
+	PostSuccess           = "Success"
+	PostAlreadyRegistered = "AlreadyRegistered"
+	PostClosed            = "Closed"
+	PostNotSupported      = "NotSupported"
+	PostWrongBindAddress  = "WrongBindAddress"
+	PostTransient         = "Transient" // retry later
+	PostPermanent         = "Permanent" // never succeed by retrying
+)
+
+// PostErrorRule classify swap server error by json-rpc error code and message.
+// Code 0 matches any code, empty Contains matches any message.
+type PostErrorRule struct {
+	Code     int    `toml:",omitempty" json:",omitempty"`
+	Contains string `toml:",omitempty" json:",omitempty"`
+	Outcome  string
+}
+
+var (
+	postErrorRules = defaultPostErrorRules
+
+	// rules from config are checked before these default rules
+	defaultPostErrorRules = []*PostErrorRule{
+		{Contains: "already registered", Outcome: PostAlreadyRegistered},
+		{Contains: "alreday registered", Outcome: PostAlreadyRegistered}, // typo of old swap servers
+		{Contains: "mgoError: Item is duplicate", Outcome: PostAlreadyRegistered},
+		{Contains: "swap is closed", Outcome: PostClosed},
+		{Contains: "swap trade not support", Outcome: PostNotSupported},
+		{Contains: "tx with wrong contract", Outcome: PostNotSupported},
+		{Contains: "wrong bind address", Outcome: PostWrongBindAddress},
+		{Contains: "deposit log not found or removed", Outcome: PostPermanent},
+		{Contains: "tx not found", Outcome: PostTransient},
+		{Contains: "Client.Timeout exceeded while awaiting headers", Outcome: PostTransient},
+		{Contains: "connect: connection refused", Outcome: PostTransient},
+		{Contains: "You have reached maximum request limit", Outcome: PostTransient},
+		{Contains: "rpc query error", Outcome: PostTransient},
+	}
+)
+
+// GetPostErrorRules get post error rules (config rules first)
+func GetPostErrorRules() []*PostErrorRule {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	return postErrorRules
+}
+
+func setPostErrorRules(rules []*PostErrorRule) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	postErrorRules = append(append([]*PostErrorRule{}, rules...), defaultPostErrorRules...)
+}
+
+// IsValidPostOutcome is valid post outcome
+func IsValidPostOutcome(outcome string) bool {
+	switch outcome {
+	case
+		PostSuccess,
+		PostAlreadyRegistered,
+		PostClosed,
+		PostNotSupported,
+		PostWrongBindAddress,
+		PostTransient,
+		PostPermanent:
+		return true
+	default:
+		return false
+	}
+}
+
+// CheckConfig check post error rule
+func (r *PostErrorRule) CheckConfig() error {
+	if r.Code == 0 && r.Contains == "" {
+		return errors.New("post error rule has no 'Code' and 'Contains'")
+	}
+	if !IsValidPostOutcome(r.Outcome) {
+		return fmt.Errorf("invalid post error rule 'Outcome' %v", r.Outcome)
+	}
+	return nil
+}
+
+func checkPostErrorRules(rules []*PostErrorRule) error {
+	for _, rule := range rules {
+		if err := rule.CheckConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
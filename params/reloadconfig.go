@@ -1,12 +1,14 @@
 package params
 
 import (
+	"context"
+
 	"github.com/anyswap/CrossChain-Bridge/log"
 	"github.com/fsnotify/fsnotify"
 )
 
 // WatchAndReloadScanConfig reload scan config if modified
-func WatchAndReloadScanConfig(cf chan bool) {
+func WatchAndReloadScanConfig(ctx context.Context, cf chan bool) {
 	log.Info("start job of watch and reload config")
 	watch, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -41,8 +43,12 @@ func WatchAndReloadScanConfig(cf chan bool) {
 			log.Info("fsnotify watch event", "event", ev)
 			for _, op := range ops {
 				if ev.Op&op == op {
-					ReloadConfig()
-					cf <- true
+					_ = ReloadConfig()
+					select {
+					case cf <- true:
+					case <-ctx.Done():
+						return
+					}
 					break
 				}
 			}
@@ -51,6 +57,8 @@ func WatchAndReloadScanConfig(cf chan bool) {
 				continue
 			}
 			log.Warn("fsnotify watch error", "err", werr)
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,108 @@
+package params
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sink types
+const (
+	SinkSwapServer = "swapserver" // json-rpc register to the swap server of token config
+	SinkWebhook    = "webhook"    // http post of swap json, signed with hmac-sha256
+	SinkNDJSON     = "ndjson"     // one swap json per line to file or stdout
+	SinkNATS       = "nats"       // publish swap json to nats subject
+)
+
+// DefaultSinkName name of the builtin swap server sink, used by tokens without 'Sinks'
+const DefaultSinkName = "swapserver"
+
+// SinkConfig config of swap sink, tokens choose sinks by 'Name'
+type SinkConfig struct {
+	Name    string
+	Type    string
+	URL     string `toml:",omitempty" json:",omitempty"` // webhook url, or nats server url 'nats://[user:pass@]host:port' ('tls://' for tls)
+	Secret  string `toml:",omitempty" json:"-"`          // hmac-sha256 key of webhook body
+	File    string `toml:",omitempty" json:",omitempty"` // ndjson file, '-' for stdout
+	Subject string `toml:",omitempty" json:",omitempty"` // nats subject
+	Timeout uint64 `toml:",omitempty" json:",omitempty"` // seconds of webhook post or nats publish, default 10
+}
+
+var sinkConfigs []*SinkConfig
+
+// GetSinkConfigs get configs of the extra sinks
+func GetSinkConfigs() []*SinkConfig {
+	return sinkConfigs
+}
+
+// CheckConfig check sink config
+func (c *SinkConfig) CheckConfig() error {
+	if c.Name == "" {
+		return errors.New("empty sink 'Name'")
+	}
+	if strings.EqualFold(c.Name, DefaultSinkName) {
+		return fmt.Errorf("sink name '%v' is reserved", c.Name)
+	}
+	switch c.Type {
+	case SinkSwapServer:
+	case SinkWebhook:
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			return fmt.Errorf("sink '%v' has wrong webhook 'URL' %v", c.Name, c.URL)
+		}
+	case SinkNDJSON:
+		if c.File == "" {
+			return fmt.Errorf("sink '%v' has empty 'File'", c.Name)
+		}
+	case SinkNATS:
+		if !strings.HasPrefix(c.URL, "nats://") && !strings.HasPrefix(c.URL, "tls://") {
+			return fmt.Errorf("sink '%v' has wrong nats 'URL' %v", c.Name, c.URL)
+		}
+		if c.Subject == "" || strings.ContainsAny(c.Subject, " \t\r\n") {
+			return fmt.Errorf("sink '%v' has wrong 'Subject' '%v'", c.Name, c.Subject)
+		}
+	default:
+		return fmt.Errorf("sink '%v' has unknown 'Type' '%v'", c.Name, c.Type)
+	}
+	return nil
+}
+
+func checkSinkConfigs(sinks []*SinkConfig) error {
+	names := make(map[string]struct{})
+	for _, sink := range sinks {
+		if err := sink.CheckConfig(); err != nil {
+			return err
+		}
+		key := strings.ToLower(sink.Name)
+		if _, exist := names[key]; exist {
+			return fmt.Errorf("duplicate sink name '%v'", sink.Name)
+		}
+		names[key] = struct{}{}
+	}
+	return nil
+}
+
+func findSinkConfig(sinks []*SinkConfig, name string) *SinkConfig {
+	for _, sink := range sinks {
+		if strings.EqualFold(sink.Name, name) {
+			return sink
+		}
+	}
+	return nil
+}
+
+// checkTokenSinks check the sinks chosen by tokens are configed
+func checkTokenSinks(chains []*ChainConfig, sinks []*SinkConfig) error {
+	for _, chainCfg := range chains {
+		for _, tokenCfg := range chainCfg.Tokens {
+			for _, name := range tokenCfg.Sinks {
+				if strings.EqualFold(name, DefaultSinkName) {
+					continue
+				}
+				if findSinkConfig(sinks, name) == nil {
+					return fmt.Errorf("chain '%v': token sink '%v' is not configed", chainCfg.BlockChain.Chain, name)
+				}
+			}
+		}
+	}
+	return nil
+}
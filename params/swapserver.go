@@ -0,0 +1,129 @@
+package params
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 60
+	defaultPostTimeout      = 300
+)
+
+// SwapServerConfig circuit breaker and rate limit of swap server.
+// The config with empty 'URL' is the default of all swap servers.
+type SwapServerConfig struct {
+	URL              string  `toml:",omitempty" json:",omitempty"`
+	FailureThreshold int     // consecutive transient failures to open the circuit, default 5, negative to disable
+	OpenTimeout      uint64  // seconds the circuit stays open before a half-open probe, default 60
+	RateLimit        float64 // max posts per second, 0 for unlimited
+	RateBurst        int     // max burst posts, default 1
+	PostTimeout      int     // seconds of json-rpc post timeout, default 300
+}
+
+var (
+	defaultSwapServerConfig = &SwapServerConfig{}
+	swapServerConfigs       = make(map[string]*SwapServerConfig)
+)
+
+func init() {
+	defaultSwapServerConfig.setDefaults()
+}
+
+// GetSwapServerConfig get config of swap server, the default config if not configed.
+// The returned config is replaced (not modified) when reloading.
+func GetSwapServerConfig(url string) *SwapServerConfig {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	if cfg, exist := swapServerConfigs[strings.ToLower(url)]; exist {
+		return cfg
+	}
+	return defaultSwapServerConfig
+}
+
+func (c *SwapServerConfig) setDefaults() {
+	if c.FailureThreshold == 0 {
+		c.FailureThreshold = defaultFailureThreshold
+	}
+	if c.OpenTimeout == 0 {
+		c.OpenTimeout = defaultOpenTimeout
+	}
+	if c.RateBurst == 0 {
+		c.RateBurst = 1
+	}
+	if c.PostTimeout == 0 {
+		c.PostTimeout = defaultPostTimeout
+	}
+}
+
+// CheckConfig check swap server config
+func (c *SwapServerConfig) CheckConfig() error {
+	if c.RateLimit < 0 {
+		return fmt.Errorf("swap server '%v' has negative 'RateLimit'", c.URL)
+	}
+	if c.RateBurst < 0 {
+		return fmt.Errorf("swap server '%v' has negative 'RateBurst'", c.URL)
+	}
+	if c.PostTimeout < 0 {
+		return fmt.Errorf("swap server '%v' has negative 'PostTimeout'", c.URL)
+	}
+	return nil
+}
+
+func checkSwapServerConfigs(configs []*SwapServerConfig) error {
+	urls := make(map[string]struct{})
+	for _, cfg := range configs {
+		if err := cfg.CheckConfig(); err != nil {
+			return err
+		}
+		key := strings.ToLower(cfg.URL)
+		if _, exist := urls[key]; exist {
+			if key == "" {
+				return errors.New("duplicate default swap server config")
+			}
+			return fmt.Errorf("duplicate swap server config '%v'", cfg.URL)
+		}
+		urls[key] = struct{}{}
+	}
+	return nil
+}
+
+func setSwapServerConfigs(configs []*SwapServerConfig) {
+	defaultCfg := &SwapServerConfig{}
+	for _, cfg := range configs {
+		if cfg.URL == "" {
+			*defaultCfg = *cfg
+		}
+	}
+	defaultCfg.setDefaults()
+	serverCfgs := make(map[string]*SwapServerConfig)
+	for _, cfg := range configs {
+		if cfg.URL == "" {
+			continue
+		}
+		// unset fields of swap server are inherited from the default config
+		serverCfg := *cfg
+		if serverCfg.FailureThreshold == 0 {
+			serverCfg.FailureThreshold = defaultCfg.FailureThreshold
+		}
+		if serverCfg.OpenTimeout == 0 {
+			serverCfg.OpenTimeout = defaultCfg.OpenTimeout
+		}
+		if serverCfg.RateLimit == 0 {
+			serverCfg.RateLimit = defaultCfg.RateLimit
+		}
+		if serverCfg.RateBurst == 0 {
+			serverCfg.RateBurst = defaultCfg.RateBurst
+		}
+		if serverCfg.PostTimeout == 0 {
+			serverCfg.PostTimeout = defaultCfg.PostTimeout
+		}
+		serverCfgs[strings.ToLower(cfg.URL)] = &serverCfg
+	}
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	defaultSwapServerConfig = defaultCfg
+	swapServerConfigs = serverCfgs
+}
@@ -0,0 +1,493 @@
+package scanner
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/storage"
+	"github.com/weijun-sh/gethscan/tools"
+)
+
+const (
+	adminDroppedReason = "dropped by admin"
+	adminMaxListLimit  = 100
+)
+
+var (
+	errAdminMethodNotFound = errors.New("method not found")
+	errAdminStorageDisable = errors.New("storage is not enabled")
+	errAdminRescanRunning  = errors.New("another rescan is running")
+	errAdminBlockOrphaned  = errors.New("block of tx is not canonical")
+)
+
+// jobProgress progress of scan job
+type jobProgress struct {
+	Job      uint64 `json:"job"`
+	From     uint64 `json:"from"`
+	To       uint64 `json:"to"` // 0 for the scan loop
+	Current  uint64 `json:"current"`
+	Finished bool   `json:"finished"`
+}
+
+type jobProgresses struct {
+	lock sync.Mutex
+	jobs map[uint64]*jobProgress
+}
+
+func (p *jobProgresses) update(job, from, to, current uint64, finished bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.jobs == nil {
+		p.jobs = make(map[uint64]*jobProgress)
+	}
+	p.jobs[job] = &jobProgress{
+		Job:      job,
+		From:     from,
+		To:       to,
+		Current:  current,
+		Finished: finished,
+	}
+}
+
+func (p *jobProgresses) list() []*jobProgress {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := make([]*jobProgress, 0, len(p.jobs))
+	for _, job := range p.jobs {
+		cpy := *job
+		result = append(result, &cpy)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Job < result[j].Job
+	})
+	return result
+}
+
+func (scanner *ethSwapScanner) isPaused() bool {
+	return atomic.LoadInt32(&scanner.paused) == 1
+}
+
+// waitIfPaused block until scanning is resumed
+func (scanner *ethSwapScanner) waitIfPaused() {
+	for scanner.isPaused() {
+		if !scanner.sleep(time.Second) {
+			return
+		}
+	}
+}
+
+// ------------------------- admin json-rpc server -------------------------
+
+type adminRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type adminError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type adminResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *adminError     `json:"error,omitempty"`
+}
+
+type adminHandler func(args json.RawMessage) (interface{}, error)
+
+// adminStatus result of admin_status
+type adminStatus struct {
+	Chain         string         `json:"chain"`
+	ChainID       string         `json:"chainID"`
+	LatestHeight  uint64         `json:"latestHeight"`
+	SyncedHeight  uint64         `json:"syncedHeight"`
+	Paused        bool           `json:"paused"`
+	ConfigHash    string         `json:"configHash"`
+	Jobs          []*jobProgress `json:"jobs"`
+	OutboxPending int            `json:"outboxPending"`
+	OutboxDead    int            `json:"outboxDead"`
+}
+
+// adminRangeArgs args of admin_rescanRange
+type adminRangeArgs struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"` // exclusive
+}
+
+// adminTxArgs args of admin_rescanTx
+type adminTxArgs struct {
+	TxHash string `json:"txhash"`
+}
+
+// adminListArgs args of admin_listPending and admin_listSwaps
+type adminListArgs struct {
+	State  string `json:"state"` // only for admin_listSwaps
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+// adminIDArgs args of get, retry or drop methods, the id of swap is its key
+type adminIDArgs struct {
+	ID string `json:"id"`
+}
+
+// adminSwapArgs args of admin_getSwap, get by key or get all swaps of tx
+type adminSwapArgs struct {
+	ID     string `json:"id"`
+	TxHash string `json:"txhash"`
+}
+
+// startAdminServer start admin json-rpc server, the methods of each chain are served at '/<chain>',
+// and also at '/' if there is only one chain. Requests need the bearer token if it's configed.
+func startAdminServer(cfg *params.AdminConfig, scanners []*ethSwapScanner) {
+	listen := cfg.Listen
+	if listen == "" {
+		return
+	}
+	if err := cfg.CheckConfig(); err != nil {
+		log.Error("admin server is not started", "err", err)
+		return
+	}
+	mux := http.NewServeMux()
+	for _, scanner := range scanners {
+		if scanner.backend != nil {
+			continue // admin methods are not supported by non evm chains
+		}
+		handlers := scanner.adminHandlers()
+		handlers["admin_reloadConfig"] = adminReloadConfig(scanners)
+		serve := func(w http.ResponseWriter, r *http.Request) {
+			serveAdminRequest(w, r, handlers)
+		}
+		if scanner.chain != "" {
+			mux.HandleFunc("/"+scanner.chain, serve)
+		}
+		if len(scanners) == 1 {
+			mux.HandleFunc("/", serve)
+		}
+	}
+	log.Info("start admin server", "listen", listen, "auth", cfg.Token != "")
+	go func() {
+		if err := http.ListenAndServe(listen, adminAuth(cfg.Token, mux)); err != nil {
+			log.Error("admin server stopped", "listen", listen, "err", err)
+		}
+	}()
+}
+
+// adminAuth reject requests without the bearer token, no auth if token is empty
+func adminAuth(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	expect := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expect) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (scanner *ethSwapScanner) adminHandlers() map[string]adminHandler {
+	return map[string]adminHandler{
+		"admin_status":       scanner.adminStatus,
+		"admin_rescanRange":  scanner.adminRescanRange,
+		"admin_rescanTx":     scanner.adminRescanTx,
+		"admin_getSwap":      scanner.adminGetSwap,
+		"admin_listSwaps":    scanner.adminListSwaps,
+		"admin_listPending":  scanner.adminListPending,
+		"admin_retryPending": scanner.adminRetryPending,
+		"admin_dropPending":  scanner.adminDropPending,
+		"admin_listOutbox":   scanner.adminListOutbox,
+		"admin_retryOutbox":  scanner.adminRetryOutbox,
+		"admin_dropOutbox":   scanner.adminDropOutbox,
+		"admin_pause":        scanner.adminPause,
+		"admin_resume":       scanner.adminResume,
+	}
+}
+
+func serveAdminRequest(w http.ResponseWriter, r *http.Request, handlers map[string]adminHandler) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req adminRequest
+	resp := &adminResponse{JSONRPC: "2.0"}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		resp.Error = &adminError{Code: -32700, Message: err.Error()}
+	} else {
+		resp.ID = req.ID
+		handler, exist := handlers[req.Method]
+		if !exist {
+			resp.Error = &adminError{Code: -32601, Message: errAdminMethodNotFound.Error()}
+		} else {
+			log.Info("call admin method", "method", req.Method, "params", string(req.Params))
+			result, err := handler(req.Params)
+			if err != nil {
+				resp.Error = &adminError{Code: -32000, Message: err.Error()}
+			} else {
+				resp.Result = result
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func parseAdminArgs(data json.RawMessage, args interface{}) error {
+	if len(data) == 0 {
+		return errors.New("missing params")
+	}
+	return json.Unmarshal(data, args)
+}
+
+func (scanner *ethSwapScanner) adminStatus(json.RawMessage) (interface{}, error) {
+	status := &adminStatus{
+		Chain:        scanner.chain,
+		ChainID:      scanner.chainID.String(),
+		SyncedHeight: atomic.LoadUint64(&scanner.syncedNumber),
+		Paused:       scanner.isPaused(),
+		ConfigHash:   params.GetConfigHash(),
+		Jobs:         scanner.progress.list(),
+	}
+	_ = scanner.gateways.call("eth_blockNumber", func(cli Client) error {
+		header, err := cli.HeaderByNumber(scanner.ctx, nil)
+		if err == nil {
+			status.LatestHeight = header.Number.Uint64()
+		}
+		return err
+	})
+	if scanner.outbox != nil {
+		status.OutboxPending = scanner.outbox.Len()
+		status.OutboxDead = len(scanner.outbox.DeadLetters())
+	}
+	return status, nil
+}
+
+func (scanner *ethSwapScanner) adminRescanRange(data json.RawMessage) (interface{}, error) {
+	var args adminRangeArgs
+	if err := parseAdminArgs(data, &args); err != nil {
+		return nil, err
+	}
+	if args.From >= args.To {
+		return nil, fmt.Errorf("wrong scan range [%v, %v)", args.From, args.To)
+	}
+	if !atomic.CompareAndSwapInt32(&scanner.adminRescanning, 0, 1) {
+		return nil, errAdminRescanRunning
+	}
+	job := scanner.adminJobIndex()
+	go func() {
+		defer atomic.StoreInt32(&scanner.adminRescanning, 0)
+		wg := new(sync.WaitGroup)
+		wg.Add(1)
+		scanner.scanRange(job, args.From, args.To, wg)
+		if scanner.scanLogs {
+			scanner.scanLogsRange(args.From, args.To-1, nil)
+		}
+	}()
+	return fmt.Sprintf("rescan range [%v, %v) started as job %v", args.From, args.To, job), nil
+}
+
+func (scanner *ethSwapScanner) adminRescanTx(data json.RawMessage) (interface{}, error) {
+	var args adminTxArgs
+	if err := parseAdminArgs(data, &args); err != nil {
+		return nil, err
+	}
+	txHash := common.HexToHash(args.TxHash)
+	var tx *types.Transaction
+	err := scanner.gateways.call("eth_getTransactionByHash", func(cli Client) (err error) {
+		tx, _, err = cli.TransactionByHash(scanner.ctx, txHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := scanner.loopGetTxReceipt(txHash)
+	if err != nil {
+		return nil, err
+	}
+	height := receipt.BlockNumber.Uint64()
+	// the receipt may be of an orphaned block which is not yet replaced on the gateway
+	header, err := scanner.loopGetHeader(height)
+	if err != nil {
+		return nil, err
+	}
+	if header.Hash() != receipt.BlockHash {
+		return nil, fmt.Errorf("%w: block %v hash %v, canonical %v", errAdminBlockOrphaned, height, receipt.BlockHash.Hex(), header.Hash().Hex())
+	}
+	swaps := scanner.scanTransaction(height, receipt.BlockHash.Hex(), nil, uint64(receipt.TransactionIndex), tx)
+	for _, swap := range swaps {
+		scanner.enqueueSwap(swap)
+	}
+	return fmt.Sprintf("rescan tx %v in block %v finished, queued %v swaps", txHash.Hex(), height, len(swaps)), nil
+}
+
+// adminGetSwap get swap records with the history of their states
+func (scanner *ethSwapScanner) adminGetSwap(data json.RawMessage) (interface{}, error) {
+	if scanner.store == nil {
+		return nil, errAdminStorageDisable
+	}
+	var args adminSwapArgs
+	if err := parseAdminArgs(data, &args); err != nil {
+		return nil, err
+	}
+	if args.ID != "" {
+		return scanner.store.FindSwap(args.ID)
+	}
+	if args.TxHash == "" {
+		return nil, errors.New("missing id or txhash")
+	}
+	return scanner.store.FindSwapsByTxID(args.TxHash)
+}
+
+func parseAdminListArgs(data json.RawMessage) (*adminListArgs, error) {
+	args := &adminListArgs{Limit: adminMaxListLimit}
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, args); err != nil {
+			return nil, err
+		}
+	}
+	if args.Limit <= 0 || args.Limit > adminMaxListLimit {
+		args.Limit = adminMaxListLimit
+	}
+	return args, nil
+}
+
+func (scanner *ethSwapScanner) adminListSwaps(data json.RawMessage) (interface{}, error) {
+	if scanner.store == nil {
+		return nil, errAdminStorageDisable
+	}
+	args, err := parseAdminListArgs(data)
+	if err != nil {
+		return nil, err
+	}
+	if !storage.IsSwapState(args.State) {
+		return nil, fmt.Errorf("unknown swap state '%v'", args.State)
+	}
+	return scanner.store.FindSwaps(scanner.chain, args.State, args.Offset, args.Limit)
+}
+
+func (scanner *ethSwapScanner) adminListPending(data json.RawMessage) (interface{}, error) {
+	if scanner.store == nil {
+		return nil, errAdminStorageDisable
+	}
+	args, err := parseAdminListArgs(data)
+	if err != nil {
+		return nil, err
+	}
+	return scanner.store.FindSwaps(scanner.chain, storage.SwapPendingRetry, args.Offset, args.Limit)
+}
+
+func (scanner *ethSwapScanner) adminRetryPending(data json.RawMessage) (interface{}, error) {
+	if scanner.store == nil {
+		return nil, errAdminStorageDisable
+	}
+	var args adminIDArgs
+	if err := parseAdminArgs(data, &args); err != nil {
+		return nil, err
+	}
+	swap, err := scanner.store.FindSwap(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	if swap.State != storage.SwapPendingRetry {
+		return nil, fmt.Errorf("swap %v is %v, not %v", swap.Id, swap.State, storage.SwapPendingRetry)
+	}
+	ok := scanner.retrySwapPending(swap)
+	return map[string]interface{}{
+		"success":   ok,
+		"outcome":   swap.PostOutcome,
+		"postError": swap.PostError,
+	}, nil
+}
+
+func (scanner *ethSwapScanner) adminDropPending(data json.RawMessage) (interface{}, error) {
+	if scanner.store == nil {
+		return nil, errAdminStorageDisable
+	}
+	var args adminIDArgs
+	if err := parseAdminArgs(data, &args); err != nil {
+		return nil, err
+	}
+	_, err := scanner.store.UpdateSwap(args.ID, func(swap *storage.Swap) error {
+		if swap.State != storage.SwapPendingRetry {
+			return fmt.Errorf("swap %v is %v, not %v", swap.Id, swap.State, storage.SwapPendingRetry)
+		}
+		return swap.Transit(storage.SwapDropped, adminDroppedReason, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return "dropped", nil
+}
+
+func (scanner *ethSwapScanner) adminListOutbox(json.RawMessage) (interface{}, error) {
+	return scanner.outbox.Items(), nil
+}
+
+func (scanner *ethSwapScanner) adminRetryOutbox(data json.RawMessage) (interface{}, error) {
+	var args adminIDArgs
+	if err := parseAdminArgs(data, &args); err != nil {
+		return nil, err
+	}
+	if !scanner.outbox.Retry(args.ID) {
+		return nil, fmt.Errorf("outbox item %v not found", args.ID)
+	}
+	return "retry scheduled", nil
+}
+
+func (scanner *ethSwapScanner) adminDropOutbox(data json.RawMessage) (interface{}, error) {
+	var args adminIDArgs
+	if err := parseAdminArgs(data, &args); err != nil {
+		return nil, err
+	}
+	scanner.outbox.Remove(func(item *tools.OutboxItem) bool {
+		return item.ID == args.ID
+	})
+	return "dropped", nil
+}
+
+func (scanner *ethSwapScanner) adminPause(json.RawMessage) (interface{}, error) {
+	atomic.StoreInt32(&scanner.paused, 1)
+	log.Warn("scanning is paused by admin", "chain", scanner.chain)
+	return "paused", nil
+}
+
+func (scanner *ethSwapScanner) adminResume(json.RawMessage) (interface{}, error) {
+	atomic.StoreInt32(&scanner.paused, 0)
+	log.Warn("scanning is resumed by admin", "chain", scanner.chain)
+	return "resumed", nil
+}
+
+// adminReloadConfig reload config and the tokens of all chains
+func adminReloadConfig(scanners []*ethSwapScanner) adminHandler {
+	return func(json.RawMessage) (interface{}, error) {
+		if err := params.ReloadConfig(); err != nil {
+			return nil, err
+		}
+		reloadScanners(scanners)
+		return params.GetConfigHash(), nil
+	}
+}
+
+// adminJobIndex job index reserved for admin rescan
+func (scanner *ethSwapScanner) adminJobIndex() uint64 {
+	return scanner.jobCount + 1
+}
@@ -0,0 +1,29 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+)
+
+// TestAdminRescanTxOrphaned the tx of orphaned block is not rescanned
+func TestAdminRescanTxOrphaned(t *testing.T) {
+	client := newStubClient()
+	hashes := newStubChain(client, 5, 0)
+	scanner := newStubScanner(t, client)
+	tx := types.NewTransaction(0, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1), nil)
+	txHash := client.addTx(tx, 4, hashes[4])
+	args, _ := json.Marshal(&adminTxArgs{TxHash: txHash.Hex()})
+
+	if _, err := scanner.adminRescanTx(args); err != nil {
+		t.Fatalf("rescan tx of canonical block failed: %v", err)
+	}
+	reorgStubChain(client, hashes, 4, 5)
+	if _, err := scanner.adminRescanTx(args); !errors.Is(err, errAdminBlockOrphaned) {
+		t.Fatalf("rescan tx of orphaned block got error %v, want %v", err, errAdminBlockOrphaned)
+	}
+}
@@ -0,0 +1,161 @@
+package aptos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = 60 * time.Second
+
+	// max transactions returned in one request
+	maxTransactionsLimit = 100
+)
+
+// LedgerInfo aptos ledger info
+type LedgerInfo struct {
+	ChainID         int    `json:"chain_id"`
+	Epoch           string `json:"epoch"`
+	LedgerVersion   string `json:"ledger_version"`
+	LedgerTimestamp string `json:"ledger_timestamp"`
+	BlockHeight     string `json:"block_height"`
+}
+
+// Block aptos block
+type Block struct {
+	BlockHeight    string         `json:"block_height"`
+	BlockHash      string         `json:"block_hash"`
+	BlockTimestamp string         `json:"block_timestamp"`
+	FirstVersion   string         `json:"first_version"`
+	LastVersion    string         `json:"last_version"`
+	Transactions   []*Transaction `json:"transactions"`
+}
+
+// Transaction aptos transaction
+type Transaction struct {
+	Type     string   `json:"type"`
+	Version  string   `json:"version"`
+	Hash     string   `json:"hash"`
+	Sender   string   `json:"sender,omitempty"`
+	Success  bool     `json:"success"`
+	VMStatus string   `json:"vm_status"`
+	Events   []*Event `json:"events"`
+}
+
+// Event aptos event
+type Event struct {
+	GUID           EventGUID       `json:"guid"`
+	SequenceNumber string          `json:"sequence_number"`
+	Type           string          `json:"type"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// EventGUID aptos event guid
+type EventGUID struct {
+	CreationNumber string `json:"creation_number"`
+	AccountAddress string `json:"account_address"`
+}
+
+// restError error response of aptos rest api
+type restError struct {
+	Message   string `json:"message"`
+	ErrorCode string `json:"error_code"`
+}
+
+// RestClient aptos rest api client
+type RestClient struct {
+	url    string
+	client *http.Client
+}
+
+// NewRestClient new rest client, url is the node url with or without '/v1'
+func NewRestClient(url string) *RestClient {
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, "/v1") {
+		url += "/v1"
+	}
+	return &RestClient{
+		url:    url,
+		client: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// GetLedgerInfo get ledger info
+func (c *RestClient) GetLedgerInfo() (*LedgerInfo, error) {
+	var result LedgerInfo
+	err := c.get("", &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetBlockByHeight get block by height with all its transactions
+func (c *RestClient) GetBlockByHeight(height uint64) (*Block, error) {
+	var block Block
+	err := c.get(fmt.Sprintf("/blocks/by_height/%d?with_transactions=true", height), &block)
+	if err != nil {
+		return nil, err
+	}
+	first, err := strconv.ParseUint(block.FirstVersion, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("wrong first version '%v' of block %v", block.FirstVersion, height)
+	}
+	last, err := strconv.ParseUint(block.LastVersion, 10, 64)
+	if err != nil || last < first {
+		return nil, fmt.Errorf("wrong last version '%v' of block %v", block.LastVersion, height)
+	}
+	// transactions in block response are truncated if there are too many
+	for start := first + uint64(len(block.Transactions)); start <= last; {
+		limit := last - start + 1
+		if limit > maxTransactionsLimit {
+			limit = maxTransactionsLimit
+		}
+		txs, errt := c.GetTransactions(start, limit)
+		if errt != nil {
+			return nil, errt
+		}
+		if len(txs) == 0 {
+			return nil, fmt.Errorf("get transactions of block %v from version %v return empty", height, start)
+		}
+		block.Transactions = append(block.Transactions, txs...)
+		start += uint64(len(txs))
+	}
+	return &block, nil
+}
+
+// GetTransactions get transactions from version start
+func (c *RestClient) GetTransactions(start, limit uint64) ([]*Transaction, error) {
+	var result []*Transaction
+	err := c.get(fmt.Sprintf("/transactions?start=%d&limit=%d", start, limit), &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *RestClient) get(path string, result interface{}) error {
+	resp, err := c.client.Get(c.url + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var restErr restError
+		if json.Unmarshal(body, &restErr) == nil && restErr.Message != "" {
+			return fmt.Errorf("aptos rest error %v (%v): %v", resp.StatusCode, restErr.ErrorCode, restErr.Message)
+		}
+		return fmt.Errorf("aptos rest error %v: %v", resp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, result)
+}
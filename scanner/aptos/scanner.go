@@ -0,0 +1,120 @@
+// Package aptos implements the swap scanner of aptos chain.
+package aptos
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/scanner/base"
+)
+
+const (
+	userTransactionType = "user_transaction"
+
+	routerSwapRPCMethod = "swap.RegisterRouterSwap"
+)
+
+var errUnexpectedBlock = errors.New("unexpected aptos block")
+
+// Scanner aptos swap scanner
+type Scanner struct {
+	client     *RestClient
+	scanConfig func() *params.ScanConfig // tokens are replaced when reloading config
+}
+
+var _ base.Scanner = (*Scanner)(nil)
+
+// NewScanner new aptos scanner
+func NewScanner(url string, scanConfig func() *params.ScanConfig) *Scanner {
+	return &Scanner{
+		client:     NewRestClient(url),
+		scanConfig: scanConfig,
+	}
+}
+
+// ChainID get chain id
+func (s *Scanner) ChainID() (string, error) {
+	info, err := s.client.GetLedgerInfo()
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(info.ChainID), nil
+}
+
+// LatestHeight implements base.Scanner
+func (s *Scanner) LatestHeight() (uint64, error) {
+	info, err := s.client.GetLedgerInfo()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(info.BlockHeight, 10, 64)
+}
+
+// GetBlock implements base.Scanner
+func (s *Scanner) GetBlock(height uint64) (*base.Block, error) {
+	block, err := s.client.GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return &base.Block{
+		Number:  height,
+		Hash:    block.BlockHash,
+		TxCount: len(block.Transactions),
+		Raw:     block,
+	}, nil
+}
+
+// ExtractSwaps implements base.Scanner,
+// every event of successful user transaction matching the configured
+// router event types is a router swap, its log index is the event index.
+func (s *Scanner) ExtractSwaps(block *base.Block) (swaps []*base.SwapPost, err error) {
+	aptosBlock, ok := block.Raw.(*Block)
+	if !ok {
+		return nil, errUnexpectedBlock
+	}
+	tokens := s.scanConfig().Tokens
+	for _, tx := range aptosBlock.Transactions {
+		if tx.Type != userTransactionType || !tx.Success {
+			continue
+		}
+		for i, event := range tx.Events {
+			for _, tokenCfg := range tokens {
+				if !tokenCfg.IsRouterSwapAll() || !matchEventType(event.Type, tokenCfg.EventTypes) {
+					continue
+				}
+				log.Info("found aptos router swap", "swaptype", tokenCfg.TxType, "chainid", tokenCfg.ChainID, "txid", tx.Hash, "logindex", i, "event", event.Type)
+				swaps = append(swaps, &base.SwapPost{
+					TxID:        tx.Hash,
+					TxType:      tokenCfg.TxType,
+					RPCMethod:   routerSwapRPCMethod,
+					SwapServer:  tokenCfg.SwapServer,
+					ChainID:     tokenCfg.ChainID,
+					LogIndex:    fmt.Sprintf("%d", i),
+					BlockNumber: block.Number,
+					BlockHash:   block.Hash,
+					Sinks:       tokenCfg.Sinks,
+				})
+			}
+		}
+	}
+	return swaps, nil
+}
+
+// matchEventType event type matches if it equals to one of the configured types,
+// generic type arguments are ignored, eg. `0x1::coin::DepositEvent<T>` matches `0x1::coin::DepositEvent`
+func matchEventType(eventType string, eventTypes []string) bool {
+	if pos := strings.Index(eventType, "<"); pos >= 0 {
+		eventType = eventType[:pos]
+	}
+	for _, typ := range eventTypes {
+		if strings.EqualFold(eventType, typ) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package aptos
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+const testRouterEvent = "0x1234::Router::SwapOutEvent"
+
+// newMockRestServer mock aptos rest api with responses of paths
+func newMockRestServer(t *testing.T, responses map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, exist := responses[r.URL.RequestURI()]
+		if !exist {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"message":"%v not found","error_code":"block_not_found"}`, r.URL.Path)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestScanner(url string) *Scanner {
+	scanConfig := &params.ScanConfig{
+		Tokens: []*params.TokenConfig{
+			{
+				TxType:     params.TxRouterERC20Swap,
+				ChainID:    "1",
+				SwapServer: "http://127.0.0.1:1",
+				EventTypes: []string{testRouterEvent},
+			},
+			{
+				TxType:     params.TxSwapin, // not router
+				SwapServer: "http://127.0.0.1:2",
+				EventTypes: []string{testRouterEvent},
+			},
+		},
+	}
+	return NewScanner(url, func() *params.ScanConfig { return scanConfig })
+}
+
+func TestLedgerInfo(t *testing.T) {
+	server := newMockRestServer(t, map[string]string{
+		"/v1": `{"chain_id":2,"epoch":"1","ledger_version":"300","ledger_timestamp":"1","block_height":"10"}`,
+	})
+	s := newTestScanner(server.URL + "/")
+	if chainID, err := s.ChainID(); err != nil || chainID != "2" {
+		t.Fatalf("got chainID %v, err %v", chainID, err)
+	}
+	if height, err := s.LatestHeight(); err != nil || height != 10 {
+		t.Fatalf("got latest height %v, err %v", height, err)
+	}
+}
+
+// TestExtractSwaps the truncated transactions of block are got by versions,
+// and only events of successful user transactions matching router event types are swaps
+func TestExtractSwaps(t *testing.T) {
+	server := newMockRestServer(t, map[string]string{
+		"/v1/blocks/by_height/10?with_transactions=true": `{"block_height":"10","block_hash":"0xb10","first_version":"100","last_version":"103",
+			"transactions":[{"type":"block_metadata_transaction","version":"100","hash":"0x100","success":true}]}`,
+		"/v1/transactions?start=101&limit=3": `[
+			{"type":"user_transaction","version":"101","hash":"0x101","success":true,"events":[
+				{"type":"0x1::coin::WithdrawEvent","data":{}},
+				{"type":"` + testRouterEvent + `<0x1::aptos_coin::AptosCoin>","data":{}}]},
+			{"type":"user_transaction","version":"102","hash":"0x102","success":false,"events":[
+				{"type":"` + testRouterEvent + `","data":{}}]}]`,
+		"/v1/transactions?start=103&limit=1": `[
+			{"type":"user_transaction","version":"103","hash":"0x103","success":true,"events":[
+				{"type":"0x1234::router::swapoutevent","data":{}}]}]`,
+	})
+	s := newTestScanner(server.URL)
+	block, err := s.GetBlock(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Number != 10 || block.Hash != "0xb10" || block.TxCount != 4 {
+		t.Fatalf("wrong block number %v hash %v txs %v", block.Number, block.Hash, block.TxCount)
+	}
+	swaps, err := s.ExtractSwaps(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swaps) != 2 {
+		t.Fatalf("extract %v swaps, want 2", len(swaps))
+	}
+	want := []struct{ txid, logIndex string }{{"0x101", "1"}, {"0x103", "0"}}
+	for i, swap := range swaps {
+		if swap.TxID != want[i].txid || swap.LogIndex != want[i].logIndex {
+			t.Errorf("swap %v has txid %v logIndex %v, want %v", i, swap.TxID, swap.LogIndex, want[i])
+		}
+		if swap.RPCMethod != routerSwapRPCMethod || swap.ChainID != "1" || swap.BlockNumber != 10 || swap.BlockHash != "0xb10" {
+			t.Errorf("wrong swap %+v", swap)
+		}
+	}
+}
+
+func TestGetBlockError(t *testing.T) {
+	server := newMockRestServer(t, map[string]string{
+		"/v1/blocks/by_height/11?with_transactions=true": `{"block_height":"11","block_hash":"0xb11","first_version":"200","last_version":"201","transactions":[]}`,
+		"/v1/transactions?start=200&limit=2":             `[]`,
+	})
+	s := newTestScanner(server.URL)
+	if _, err := s.GetBlock(11); err == nil {
+		t.Fatal("get block with missing transactions should fail")
+	}
+	_, err := s.GetBlock(12)
+	if err == nil || err.Error() != "aptos rest error 404 (block_not_found): /v1/blocks/by_height/12 not found" {
+		t.Fatalf("got error %v of missing block", err)
+	}
+}
+
+func TestMatchEventType(t *testing.T) {
+	for eventType, want := range map[string]bool{
+		testRouterEvent:                  true,
+		testRouterEvent + "<0x1::a::B>":  true,
+		"0x1234::Router::SwapInEvent":    false,
+		"0x1234::Router::SwapOutEventV2": false,
+	} {
+		if got := matchEventType(eventType, []string{testRouterEvent}); got != want {
+			t.Errorf("match event type %v is %v, want %v", eventType, got, want)
+		}
+	}
+}
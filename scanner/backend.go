@@ -0,0 +1,135 @@
+package scanner
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/metrics"
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/scanner/aptos"
+	"github.com/weijun-sh/gethscan/scanner/base"
+)
+
+// LatestHeight get latest block height of evm chain,
+// the blocks of evm chain are scanned by processBlock, non evm chains by backend.
+func (scanner *ethSwapScanner) LatestHeight() (height uint64, err error) {
+	err = scanner.gateways.call("eth_blockNumber", func(cli Client) error {
+		header, errh := cli.HeaderByNumber(scanner.ctx, nil)
+		if errh == nil {
+			height = header.Number.Uint64()
+		}
+		return errh
+	})
+	return height, err
+}
+
+func newSwapPostFromBase(chain string, swap *base.SwapPost) *swapPost {
+	return &swapPost{
+		txid:        swap.TxID,
+		rpcMethod:   swap.RPCMethod,
+		swapServer:  swap.SwapServer,
+		chain:       chain,
+		pairID:      swap.PairID,
+		chainID:     swap.ChainID,
+		logIndex:    swap.LogIndex,
+		blockNumber: swap.BlockNumber,
+		blockHash:   swap.BlockHash,
+		sinks:       swap.Sinks,
+	}
+}
+
+// initBackend init backend of non evm chains
+func (scanner *ethSwapScanner) initBackend(bcConfig *params.BlockChainConfig) {
+	switch {
+	case bcConfig.IsAptos():
+		url := scanner.gateway
+		if url == "" && len(bcConfig.Gateways) > 0 {
+			url = bcConfig.Gateways[0]
+		}
+		backend := aptos.NewScanner(url, scanner.chainCfg.GetScanConfig)
+		chainID, err := backend.ChainID()
+		if err != nil {
+			log.Fatal("get aptos chainID failed", "chain", scanner.chain, "gateway", url, "err", err)
+		}
+		log.Info("get aptos chainID success", "chain", scanner.chain, "chainID", chainID)
+		scanner.backend = backend
+	default:
+		log.Fatal("unsupported chain type", "chainType", bcConfig.ChainType)
+	}
+}
+
+// runBackend scan swaps with the chain agnostic backend
+func (scanner *ethSwapScanner) runBackend() {
+	from := atomic.LoadUint64(&scanner.syncedNumber)
+	if scanner.startHeight > 0 {
+		from = uint64(scanner.startHeight)
+	}
+	if scanner.endHeight != 0 {
+		for h := from; h < scanner.endHeight && !scanner.isStopping(); h++ {
+			scanner.scanBackendBlock(h)
+		}
+		log.Info("scan backend range finish", "from", from, "to", scanner.endHeight)
+		return
+	}
+
+	stable := scanner.stableHeight
+	log.Info("start scan backend loop job", "from", from, "stable", stable)
+	for {
+		latest := scanner.loopGetLatestBlockNumber()
+		for h := from; h <= latest; h++ {
+			scanner.waitIfPaused()
+			if scanner.isStopping() {
+				log.Info("scan backend loop stopped", "height", h)
+				return
+			}
+			scanner.scanBackendBlock(h)
+			if scanner.isStopping() {
+				return
+			}
+			metrics.SetHeights(scanner.chain, latest, h)
+			if scanner.store != nil {
+				scanner.updateSyncdBlockNumber(h)
+			}
+		}
+		if from+stable < latest {
+			from = latest - stable
+		}
+		if !scanner.sleep(1 * time.Second) {
+			return
+		}
+	}
+}
+
+func (scanner *ethSwapScanner) scanBackendBlock(height uint64) {
+	var block *base.Block
+	var err error
+	for i := 0; i < 5; i++ { // with retry
+		block, err = scanner.backend.GetBlock(height)
+		if err == nil {
+			break
+		}
+		log.Warn("get block failed", "height", height, "err", err)
+		if !scanner.sleep(scanner.rpcInterval) {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	log.Info(fmt.Sprintf("scan block %v", height), "hash", block.Hash, "txs", block.TxCount)
+	metrics.BlocksScanned.WithLabelValues(scanner.chain, "0").Inc()
+	metrics.TxsScanned.WithLabelValues(scanner.chain, "0").Add(float64(block.TxCount))
+
+	swaps, err := scanner.backend.ExtractSwaps(block)
+	if err != nil {
+		log.Warn("extract swaps failed", "height", height, "err", err)
+		return
+	}
+	for _, swap := range swaps {
+		metrics.SwapsDetected.WithLabelValues(scanner.chain, swap.TxType).Inc()
+		scanner.enqueueSwap(newSwapPostFromBase(scanner.chain, swap))
+	}
+}
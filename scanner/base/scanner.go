@@ -0,0 +1,44 @@
+// Package base defines the chain agnostic scanner interface.
+package base
+
+// Block chain agnostic block
+type Block struct {
+	Number     uint64
+	Hash       string
+	ParentHash string // empty if the chain has no parent hash (eg. aptos)
+	TxCount    int
+
+	Raw interface{} // chain specific block
+}
+
+// SwapPost swap to be registered on swap server
+type SwapPost struct {
+	TxID       string
+	TxType     string
+	RPCMethod  string
+	SwapServer string
+
+	// bridge
+	PairID string
+
+	// router
+	ChainID  string
+	LogIndex string
+
+	BlockNumber uint64
+	BlockHash   string
+
+	Sinks []string // sink names of token config
+}
+
+// Scanner chain agnostic swap scanner
+type Scanner interface {
+	// LatestHeight get latest block height
+	LatestHeight() (uint64, error)
+
+	// GetBlock get block (with its transactions) of height
+	GetBlock(height uint64) (*Block, error)
+
+	// ExtractSwaps extract swaps of the configured tokens in block
+	ExtractSwaps(block *Block) ([]*SwapPost, error)
+}
@@ -0,0 +1,86 @@
+package scanner
+
+import (
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+
+	"github.com/weijun-sh/gethscan/metrics"
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/scanner/events"
+)
+
+// getLogAddressAndTopics get the contract which emits the swap logs and the possible log topics
+func (scanner *ethSwapScanner) getLogAddressAndTopics(tokenCfg *params.TokenConfig) (address common.Address, topics [][]byte) {
+	switch {
+	case tokenCfg.IsRouterSwapAll():
+		for _, topic := range events.TopicsOfToken(tokenCfg) {
+			topics = append(topics, topic.Bytes())
+		}
+		return common.HexToAddress(tokenCfg.RouterContract), topics
+	default:
+		topic, _ := scanner.getLogTopicByTxType(tokenCfg.TxType)
+		return common.HexToAddress(tokenCfg.TokenAddress), [][]byte{topic.Bytes()}
+	}
+}
+
+// bloomMayContainToken returns false if the bloom rules out the swap logs of token
+func (scanner *ethSwapScanner) bloomMayContainToken(bloom *types.Bloom, tokenCfg *params.TokenConfig) bool {
+	if bloom == nil || scanner.chainCfg.BlockChain.DisableBloomFilter {
+		return true
+	}
+	address, topics := scanner.getLogAddressAndTopics(tokenCfg)
+	if !bloom.Test(address.Bytes()) {
+		return false
+	}
+	for _, topic := range topics {
+		if bloom.Test(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// bloomMayMatchFilterQuery returns false if the bloom rules out the logs of filter query
+func bloomMayMatchFilterQuery(bloom *types.Bloom, fq *ethereum.FilterQuery) bool {
+	addressExist := false
+	for _, address := range fq.Addresses {
+		if bloom.Test(address.Bytes()) {
+			addressExist = true
+			break
+		}
+	}
+	if !addressExist {
+		return false
+	}
+	if len(fq.Topics) == 0 || len(fq.Topics[0]) == 0 {
+		return true
+	}
+	for _, topic := range fq.Topics[0] {
+		if bloom.Test(topic.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+// bloomMayContainRouterLogs returns false if the bloom rules out all the router logs to get
+func (scanner *ethSwapScanner) bloomMayContainRouterLogs(bloom *types.Bloom) bool {
+	if scanner.chainCfg.BlockChain.DisableBloomFilter {
+		return true
+	}
+	hasQuery := false
+	for _, fq := range []*ethereum.FilterQuery{&scanner.fqSwapRouter, &scanner.fqSwapRouterNFT, &scanner.fqSwapRouterAnycall} {
+		if len(fq.Addresses) == 0 {
+			continue
+		}
+		hasQuery = true
+		if bloomMayMatchFilterQuery(bloom, fq) {
+			return true
+		}
+	}
+	if hasQuery {
+		metrics.BloomSkips.WithLabelValues(scanner.chain, "eth_getLogs").Inc()
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package scanner
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+var jsonrpcErrCodeRegexp = regexp.MustCompile(`json-rpc error (-?\d+)`)
+
+// getJSONRPCErrorCode parse json-rpc error code from error message
+func getJSONRPCErrorCode(msg string) int {
+	matches := jsonrpcErrCodeRegexp.FindStringSubmatch(msg)
+	if len(matches) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(matches[1])
+	return code
+}
+
+// classifyPostError classify swap server error message by rules,
+// unmatched errors are transient so that swaps will not be lost.
+func classifyPostError(msg string) string {
+	code := getJSONRPCErrorCode(msg)
+	lowerMsg := strings.ToLower(msg)
+	for _, rule := range params.GetPostErrorRules() {
+		if rule.Code != 0 && rule.Code != code {
+			continue
+		}
+		if rule.Contains != "" && !strings.Contains(lowerMsg, strings.ToLower(rule.Contains)) {
+			continue
+		}
+		return rule.Outcome
+	}
+	return params.PostTransient
+}
+
+// isPostFinished swap server has accepted or definitely handled the swap
+func isPostFinished(outcome string) bool {
+	switch outcome {
+	case
+		params.PostSuccess,
+		params.PostAlreadyRegistered,
+		params.PostClosed,
+		params.PostNotSupported,
+		params.PostWrongBindAddress:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,53 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+func TestClassifyPostError(t *testing.T) {
+	tests := map[string]string{
+		"json-rpc error -32099, mgoError: Item is duplicate":               params.PostAlreadyRegistered,
+		"json-rpc error -32099, swap is already registered":                params.PostAlreadyRegistered,
+		"json-rpc error -32099, swap is closed":                            params.PostClosed,
+		"json-rpc error -32099, swap trade not support":                    params.PostNotSupported,
+		"json-rpc error -32099, wrong bind address":                        params.PostWrongBindAddress,
+		"json-rpc error -32099, deposit log not found or removed":          params.PostPermanent,
+		"Post \"http://127.0.0.1\": dial tcp: connect: connection refused": params.PostTransient,
+		"some unknown error": params.PostTransient,
+	}
+	for msg, want := range tests {
+		if got := classifyPostError(msg); got != want {
+			t.Errorf("classifyPostError(%q) is %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestGetJSONRPCErrorCode(t *testing.T) {
+	for msg, want := range map[string]int{
+		"json-rpc error -32099, swap is closed": -32099,
+		"json-rpc error 10, wrong":              10,
+		"connection refused":                    0,
+	} {
+		if got := getJSONRPCErrorCode(msg); got != want {
+			t.Errorf("getJSONRPCErrorCode(%q) is %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestIsPostFinished(t *testing.T) {
+	for outcome, want := range map[string]bool{
+		params.PostSuccess:           true,
+		params.PostAlreadyRegistered: true,
+		params.PostClosed:            true,
+		params.PostNotSupported:      true,
+		params.PostWrongBindAddress:  true,
+		params.PostTransient:         false,
+		params.PostPermanent:         false,
+	} {
+		if got := isPostFinished(outcome); got != want {
+			t.Errorf("isPostFinished(%v) is %v, want %v", outcome, got, want)
+		}
+	}
+}
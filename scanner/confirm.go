@@ -0,0 +1,230 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/jowenshaw/gethclient/types"
+
+	"github.com/weijun-sh/gethscan/metrics"
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+const (
+	confirmPolicyDepth     = "depth"     // confirmed if the block is 'StableHeight' blocks deep
+	confirmPolicyFinalized = "finalized" // confirmed if the block is not after the 'finalized' block
+	confirmPolicySafe      = "safe"      // confirmed if the block is not after the 'safe' block
+
+	confirmInterval = 5 * time.Second // interval of checking unconfirmed swaps
+)
+
+func checkConfirmPolicy(policy string) error {
+	switch policy {
+	case "", confirmPolicyDepth, confirmPolicyFinalized, confirmPolicySafe:
+		return nil
+	default:
+		return fmt.Errorf("unsupported confirm policy '%v', supported are %v", policy, []string{confirmPolicyDepth, confirmPolicyFinalized, confirmPolicySafe})
+	}
+}
+
+// unconfirmedSwaps detected swaps whose blocks do not meet the confirm policy yet
+type unconfirmedSwaps struct {
+	lock  sync.Mutex
+	swaps map[string]*swapPost // key is `swapPost.key()`
+}
+
+func newUnconfirmedSwaps() *unconfirmedSwaps {
+	return &unconfirmedSwaps{
+		swaps: make(map[string]*swapPost),
+	}
+}
+
+func (u *unconfirmedSwaps) add(swap *swapPost) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	key := swap.key()
+	if _, exist := u.swaps[key]; exist {
+		return false
+	}
+	u.swaps[key] = swap
+	return true
+}
+
+func (u *unconfirmedSwaps) remove(swap *swapPost) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	key := swap.key()
+	if _, exist := u.swaps[key]; !exist {
+		return false
+	}
+	delete(u.swaps, key)
+	return true
+}
+
+// list the swaps in blocks not after height, sorted by block number
+func (u *unconfirmedSwaps) list(height uint64) (swaps []*swapPost) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, swap := range u.swaps {
+		if swap.blockNumber <= height {
+			swaps = append(swaps, swap)
+		}
+	}
+	sort.Slice(swaps, func(i, j int) bool {
+		return swaps[i].blockNumber < swaps[j].blockNumber
+	})
+	return swaps
+}
+
+// removeBlock remove and return the swaps of block
+func (u *unconfirmedSwaps) removeBlock(blockHash string) (removed []*swapPost) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for key, swap := range u.swaps {
+		if strings.EqualFold(swap.blockHash, blockHash) {
+			removed = append(removed, swap)
+			delete(u.swaps, key)
+		}
+	}
+	return removed
+}
+
+func (u *unconfirmedSwaps) len() int {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return len(u.swaps)
+}
+
+// holdSwap keep swap until its block meets the confirm policy,
+// the synced block number is not persisted beyond it.
+func (scanner *ethSwapScanner) holdSwap(swap *swapPost) {
+	if !scanner.unconfirmed.add(swap) {
+		return // scanned again
+	}
+	scanner.posts.addHeight(swap.blockNumber)
+	metrics.UnconfirmedSwaps.WithLabelValues(scanner.chain).Set(float64(scanner.unconfirmed.len()))
+	log.Info("hold unconfirmed swap", "chain", scanner.chain, "txid", swap.txid, "logIndex", swap.logIndex, "block", swap.blockNumber, "policy", scanner.confirmPolicy)
+	scanner.transitSwap(swap, storage.SwapDetected, "")
+}
+
+// loadUnconfirmedSwaps hold the unconfirmed swaps of last running
+func (scanner *ethSwapScanner) loadUnconfirmedSwaps() {
+	var result []*storage.Swap
+	var err error
+	for i := 0; i < 5; i++ { // with retry
+		result, err = scanner.store.FindSwaps(scanner.chain, storage.SwapDetected, 0, 0)
+		if err == nil {
+			break
+		}
+		if !scanner.sleep(scanner.rpcInterval) {
+			return
+		}
+	}
+	if err != nil {
+		log.Fatal("load unconfirmed swaps failed", "chain", scanner.chain, "err", err)
+	}
+	for _, ms := range result {
+		swap := newSwapPostFromMgo(ms)
+		if scanner.unconfirmed.add(swap) {
+			scanner.posts.addHeight(swap.blockNumber)
+		}
+	}
+	metrics.UnconfirmedSwaps.WithLabelValues(scanner.chain).Set(float64(scanner.unconfirmed.len()))
+	log.Info("load unconfirmed swaps success", "chain", scanner.chain, "count", len(result))
+}
+
+// loopConfirmSwaps post the unconfirmed swaps after their blocks meet the confirm policy,
+// the swaps are re-verified against the canonical block hash before posting.
+func (scanner *ethSwapScanner) loopConfirmSwaps() {
+	log.Info("start confirm swaps loop", "chain", scanner.chain, "policy", scanner.confirmPolicy)
+	for scanner.sleep(confirmInterval) {
+		if scanner.unconfirmed.len() == 0 {
+			continue
+		}
+		confirmed, err := scanner.getConfirmedHeight()
+		if err != nil {
+			log.Warn("get confirmed height failed", "chain", scanner.chain, "policy", scanner.confirmPolicy, "err", err)
+			continue
+		}
+		canonical := make(map[uint64]string)
+		for _, swap := range scanner.unconfirmed.list(confirmed) {
+			if scanner.isStopping() {
+				return
+			}
+			blockHash, exist := canonical[swap.blockNumber]
+			if !exist {
+				header, errh := scanner.loopGetHeader(swap.blockNumber)
+				if errh != nil {
+					continue // retry next round
+				}
+				blockHash = header.Hash().Hex()
+				canonical[swap.blockNumber] = blockHash
+			}
+			if strings.EqualFold(blockHash, swap.blockHash) {
+				scanner.confirmSwap(swap)
+			} else {
+				scanner.dropOrphanedSwaps(swap.blockNumber, swap.blockHash)
+			}
+		}
+		metrics.UnconfirmedSwaps.WithLabelValues(scanner.chain).Set(float64(scanner.unconfirmed.len()))
+	}
+}
+
+// getConfirmedHeight the highest block which meets the confirm policy
+func (scanner *ethSwapScanner) getConfirmedHeight() (uint64, error) {
+	if scanner.confirmPolicy == confirmPolicyDepth {
+		latest, err := scanner.LatestHeight()
+		if err != nil {
+			return 0, err
+		}
+		if latest < scanner.stableHeight {
+			return 0, nil
+		}
+		return latest - scanner.stableHeight, nil
+	}
+	var header *types.Header
+	err := scanner.gateways.callRPC("eth_getBlockByNumber", func(cli BatchClient) error {
+		return cli.CallContext(scanner.ctx, &header, "eth_getBlockByNumber", scanner.confirmPolicy, false)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if header == nil || header.Number == nil {
+		return 0, fmt.Errorf("%v block not found", scanner.confirmPolicy)
+	}
+	return header.Number.Uint64(), nil
+}
+
+func (scanner *ethSwapScanner) confirmSwap(swap *swapPost) {
+	if !scanner.unconfirmed.remove(swap) {
+		return
+	}
+	log.Info("swap is confirmed", "chain", scanner.chain, "txid", swap.txid, "logIndex", swap.logIndex, "block", swap.blockNumber, "policy", scanner.confirmPolicy)
+	scanner.queueSwap(swap)
+	scanner.posts.removeHeight(swap.blockNumber) // after queued, keep the synced block number not beyond it
+}
+
+// dropOrphanedSwaps drop the unconfirmed swaps of orphaned block, and mark them as orphaned in storage
+func (scanner *ethSwapScanner) dropOrphanedSwaps(height uint64, blockHash string) {
+	if scanner.removeUnconfirmedBlock(height, blockHash) != 0 && scanner.store != nil {
+		reason := fmt.Sprintf("%v %v at height %v", orphanedReason, blockHash, height)
+		scanner.markSwapsOrphaned(height, blockHash, reason)
+	}
+}
+
+// removeUnconfirmedBlock remove the unconfirmed swaps of orphaned block from memory
+func (scanner *ethSwapScanner) removeUnconfirmedBlock(height uint64, blockHash string) int {
+	removed := scanner.unconfirmed.removeBlock(blockHash)
+	for _, swap := range removed {
+		log.Warn("drop unconfirmed swap of orphaned block", "chain", scanner.chain, "txid", swap.txid, "logIndex", swap.logIndex, "height", height, "hash", blockHash)
+		scanner.posts.removeHeight(swap.blockNumber)
+	}
+	if len(removed) != 0 {
+		metrics.UnconfirmedSwaps.WithLabelValues(scanner.chain).Set(float64(scanner.unconfirmed.len()))
+	}
+	return len(removed)
+}
@@ -0,0 +1,110 @@
+package scanner
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/scanner/events"
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+func newTestScanner(t *testing.T) *ethSwapScanner {
+	store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return &ethSwapScanner{
+		chain:         "eth",
+		confirmPolicy: confirmPolicyDepth,
+		unconfirmed:   newUnconfirmedSwaps(),
+		posts:         newPostQueue(&params.PostQueueConfig{}),
+		ctx:           context.Background(),
+		store:         store,
+	}
+}
+
+func newTestSwapPost(txid, logIndex string) *swapPost {
+	return &swapPost{
+		txid:        txid,
+		rpcMethod:   "swap.RegisterRouterSwap",
+		swapServer:  "server",
+		chainID:     "1",
+		logIndex:    logIndex,
+		chain:       "eth",
+		blockNumber: 100,
+		blockHash:   "0xabc",
+		detail:      &events.Detail{Event: "LogAnySwapOut", Amount: logIndex},
+	}
+}
+
+// TestHoldSwapsOfOneTx every log of one tx is held and persisted by its own key
+func TestHoldSwapsOfOneTx(t *testing.T) {
+	scanner := newTestScanner(t)
+	first, second := newTestSwapPost("0x1", "1"), newTestSwapPost("0x1", "2")
+	scanner.holdSwap(first)
+	scanner.holdSwap(second)
+	scanner.holdSwap(newTestSwapPost("0x1", "1")) // scanned again
+	if n := scanner.unconfirmed.len(); n != 2 {
+		t.Fatalf("held %v swaps, want 2", n)
+	}
+	swaps, err := scanner.store.FindSwapsByTxID("0x1")
+	if err != nil || len(swaps) != 2 {
+		t.Fatalf("persisted %v swaps of tx, err %v", len(swaps), err)
+	}
+	for _, swap := range swaps {
+		if swap.State != storage.SwapDetected {
+			t.Errorf("swap %v has state %v", swap.Id, swap.State)
+		}
+	}
+
+	// restart and load the unconfirmed swaps
+	restarted := newTestScanner(t)
+	restarted.store = scanner.store
+	restarted.loadUnconfirmedSwaps()
+	held := restarted.unconfirmed.list(100)
+	if len(held) != 2 {
+		t.Fatalf("loaded %v unconfirmed swaps, want 2", len(held))
+	}
+	for _, swap := range held {
+		if swap.txid != "0x1" || swap.key() != newTestSwapPost("0x1", swap.logIndex).key() {
+			t.Errorf("loaded swap txid %v key %v", swap.txid, swap.key())
+		}
+		if swap.detail == nil || swap.detail.Amount != swap.logIndex {
+			t.Errorf("detail of loaded swap %v is lost", swap.key())
+		}
+	}
+	if lowest, exist := restarted.posts.lowestHeight(); !exist || lowest != 100 {
+		t.Errorf("lowest height of loaded swaps is %v %v", lowest, exist)
+	}
+}
+
+func TestDropOrphanedSwaps(t *testing.T) {
+	scanner := newTestScanner(t)
+	orphaned, other := newTestSwapPost("0x1", "1"), newTestSwapPost("0x2", "1")
+	other.blockNumber, other.blockHash = 101, "0xdef"
+	scanner.holdSwap(orphaned)
+	scanner.holdSwap(other)
+
+	scanner.dropOrphanedSwaps(100, "0xabc")
+	if held := scanner.unconfirmed.list(200); len(held) != 1 || held[0].txid != "0x2" {
+		t.Fatalf("held swaps after dropping orphaned block %v", held)
+	}
+	if lowest, _ := scanner.posts.lowestHeight(); lowest != 101 {
+		t.Errorf("lowest height is %v after dropping orphaned block, want 101", lowest)
+	}
+	swap, err := scanner.store.FindSwap(orphaned.key())
+	if err != nil || swap.State != storage.SwapOrphaned {
+		t.Fatalf("swap of orphaned block %+v, err %v", swap, err)
+	}
+
+	// detected again in another block
+	orphaned.blockNumber, orphaned.blockHash = 102, "0x123"
+	scanner.holdSwap(orphaned)
+	swap, err = scanner.store.FindSwap(orphaned.key())
+	if err != nil || swap.State != storage.SwapDetected || swap.BlockHash != "0x123" {
+		t.Fatalf("swap detected in another block %+v, err %v", swap, err)
+	}
+}
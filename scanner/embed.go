@@ -0,0 +1,194 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+const (
+	defaultEmbeddedJobs         = 4
+	defaultEmbeddedBlockTimeout = 300 * time.Second
+)
+
+var (
+	errNoBlockChainConfig = errors.New("no blockchain config")
+	errNotEVMChain        = errors.New("embedded scanner only supports evm chains")
+	errNoClient           = errors.New("no rpc client")
+	errNoSink             = errors.New("no swap sink")
+	errScannerStarted     = errors.New("scanner is already started")
+	errNoStartHeight      = errors.New("start height is required to scan range with end height")
+)
+
+// Config config of the embedded scanner
+type Config struct {
+	BlockChain *params.BlockChainConfig
+	Tokens     []*params.TokenConfig
+
+	ScanReceipt  bool          // scan transaction receipt instead of transaction
+	ScanLogs     bool          // scan router swaps by getLogs in block ranges
+	StartHeight  uint64        // start height (inclusive), 0 to start from the latest block, required if EndHeight is set
+	EndHeight    uint64        // end height (exclusive), 0 to scan new blocks forever
+	Jobs         uint64        // jobs of scanning range, default 4
+	BlockTimeout time.Duration // warn if scanning one block takes longer, default 300 seconds
+	OutboxFile   string        // file to keep swaps which are failed to post, empty to disable (see OnSwap)
+
+	PostWorkers   int // concurrent swap posts, default 8
+	PostQueueSize int // max detected swaps waiting to be posted, default 1000
+
+	// OnSwap is called after the swap is posted to sink, with the post outcome.
+	// The swaps with `params.PostTransient` outcome are retried only if OutboxFile is set,
+	// otherwise they are dropped after OnSwap, and the caller should retry them.
+	OnSwap func(swap *Swap)
+}
+
+// Scanner swap scanner which can be embedded in other services.
+// The detected swaps are posted to the sink, and passed to `Config.OnSwap`.
+// Storage, metrics server and admin server are not used by the embedded scanner.
+type Scanner struct {
+	scanner    *ethSwapScanner
+	client     Client
+	outboxFile string
+
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// New new embedded scanner of evm chain with rpc client and swap sink,
+// use `SwapServerSink` to post swaps to the swap servers of tokens,
+// or `NewTokenSinks` to deliver swaps to the sinks chosen by tokens.
+// The client can optionally implement `BatchClient` and `HeadSubscriber`.
+func New(cfg *Config, client Client, sink Sink) (*Scanner, error) {
+	if cfg == nil || cfg.BlockChain == nil {
+		return nil, errNoBlockChainConfig
+	}
+	if cfg.BlockChain.IsAptos() {
+		return nil, errNotEVMChain
+	}
+	if client == nil {
+		return nil, errNoClient
+	}
+	if sink == nil {
+		return nil, errNoSink
+	}
+	if cfg.EndHeight != 0 && cfg.StartHeight == 0 {
+		return nil, errNoStartHeight
+	}
+	if cfg.EndHeight != 0 && cfg.StartHeight >= cfg.EndHeight {
+		return nil, errors.New("start height must be less than end height")
+	}
+	chainCfg, err := params.NewChainConfig(cfg.BlockChain, cfg.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	jobs := cfg.Jobs
+	if jobs == 0 {
+		jobs = defaultEmbeddedJobs
+	}
+	timeout := cfg.BlockTimeout
+	if timeout == 0 {
+		timeout = defaultEmbeddedBlockTimeout
+	}
+	scanner := &ethSwapScanner{
+		chain:               cfg.BlockChain.Chain,
+		chainCfg:            chainCfg,
+		scanReceipt:         cfg.ScanReceipt,
+		scanLogs:            cfg.ScanLogs,
+		startHeight:         int64(cfg.StartHeight),
+		endHeight:           cfg.EndHeight,
+		jobCount:            jobs,
+		processBlockTimeout: timeout,
+		rpcInterval:         1 * time.Second,
+		rpcRetryCount:       3,
+		syncdCount2Mongodb:  defaultSyncdCount2Mongodb,
+		sink:                sink,
+		onSwap:              cfg.OnSwap,
+		posts: newPostQueue(&params.PostQueueConfig{
+			Workers: cfg.PostWorkers,
+			Size:    cfg.PostQueueSize,
+		}),
+	}
+	return &Scanner{
+		scanner:    scanner,
+		client:     client,
+		outboxFile: cfg.OutboxFile,
+	}, nil
+}
+
+// Start check the chain of client and start scanning in background.
+// Scanning is stopped when ctx is done or Stop is called.
+func (s *Scanner) Start(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done != nil {
+		return errScannerStarted
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	scanner := s.scanner
+	scanner.ctx = ctx
+
+	pool, err := newClientPool(ctx, scanner.chain, s.client)
+	if err != nil {
+		cancel()
+		return err
+	}
+	scanner.gateways = pool
+	scanner.chainID = pool.chainID
+	if err = scanner.initChain(); err != nil {
+		cancel()
+		return err
+	}
+	if s.outboxFile != "" {
+		if err = scanner.openOutbox(s.outboxFile); err != nil {
+			cancel()
+			return err
+		}
+	}
+	atomic.StoreUint64(&scanner.syncedNumber, scanner.loopGetLatestBlockNumber()-10)
+	scanner.prepare()
+	log.Info("start embedded scanner", "chain", scanner.chain, "chainID", scanner.chainID, "start", scanner.startHeight, "end", scanner.endHeight)
+
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		scanner.run()
+		s.err = scanner.shutdown()
+	}()
+	return nil
+}
+
+// Stop stop scanning, wait for in-flight swap posts and close outbox
+func (s *Scanner) Stop() error {
+	s.lock.Lock()
+	cancel, done := s.cancel, s.done
+	s.lock.Unlock()
+	if done == nil {
+		return nil
+	}
+	// do not hold lock when waiting, swap callbacks may call Done
+	cancel()
+	<-done
+	return s.err
+}
+
+// Done is closed when the scanner is stopped, nil if not started
+func (s *Scanner) Done() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.done
+}
+
+// ChainID chain id of the client, nil if not started
+func (s *Scanner) ChainID() *big.Int {
+	return s.scanner.chainID
+}
@@ -0,0 +1,70 @@
+package scanner
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+func newTestEmbedConfig(start, end uint64) *Config {
+	return &Config{
+		BlockChain: &params.BlockChainConfig{Chain: "eth"},
+		Tokens: []*params.TokenConfig{{
+			TxType:         params.TxRouterERC20Swap,
+			RouterContract: testRouter.Hex(),
+			ChainID:        "1",
+			SwapServer:     "http://127.0.0.1:1",
+		}},
+		StartHeight: start,
+		EndHeight:   end,
+	}
+}
+
+func TestNewEmbedHeights(t *testing.T) {
+	tests := []struct {
+		start, end uint64
+		ok         bool
+	}{
+		{0, 0, true},
+		{10, 0, true},
+		{10, 20, true},
+		{0, 20, false},
+		{20, 20, false},
+		{30, 20, false},
+	}
+	for _, test := range tests {
+		_, err := New(newTestEmbedConfig(test.start, test.end), newStubClient(), SwapServerSink{})
+		if (err == nil) != test.ok {
+			t.Errorf("new scanner of range [%v, %v) got error %v", test.start, test.end, err)
+		}
+	}
+	_, err := New(newTestEmbedConfig(0, 20), newStubClient(), SwapServerSink{})
+	if !errors.Is(err, errNoStartHeight) {
+		t.Errorf("got error %v, want %v", err, errNoStartHeight)
+	}
+}
+
+// TestEmbedStopNotLocked the scanner is not locked when Stop waits for scanning to exit
+func TestEmbedStopNotLocked(t *testing.T) {
+	s := &Scanner{done: make(chan struct{})}
+	cancelled := make(chan struct{})
+	s.cancel = func() { close(cancelled) }
+	stopped := make(chan error)
+	go func() { stopped <- s.Stop() }()
+	<-cancelled
+
+	got := make(chan (<-chan struct{}))
+	go func() { got <- s.Done() }()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("Done is blocked by Stop")
+	}
+	s.err = errors.New("shutdown failed")
+	close(s.done)
+	if err := <-stopped; err != s.err {
+		t.Fatalf("Stop returns %v, want %v", err, s.err)
+	}
+}
@@ -0,0 +1,144 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/jowenshaw/gethclient/common"
+)
+
+var (
+	errNoTopics     = errors.New("log has no topics")
+	errUnknownEvent = errors.New("unknown swap event")
+)
+
+// Detail decoded payload of swap event
+type Detail struct {
+	Event       string   `json:"event"`
+	Token       string   `json:"token,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          string   `json:"to,omitempty"`
+	Amount      string   `json:"amount,omitempty"`
+	FromChainID string   `json:"fromChainID,omitempty"`
+	ToChainID   string   `json:"toChainID,omitempty"`
+	SwapoutID   string   `json:"swapoutID,omitempty"`
+	Path        []string `json:"path,omitempty"`     // trade path of router swap
+	TokenIDs    []string `json:"tokenIDs,omitempty"` // nft token ids
+	Amounts     []string `json:"amounts,omitempty"`  // nft amounts of 1155 batch
+	AppID       string   `json:"appID,omitempty"`
+	CallProxy   string   `json:"callProxy,omitempty"`
+	CallData    string   `json:"callData,omitempty"`
+}
+
+// Decode decode the swap event log with its abi,
+// the event arguments are mapped to detail fields by name.
+func Decode(topics []common.Hash, data []byte) (*Detail, error) {
+	if len(topics) == 0 {
+		return nil, errNoTopics
+	}
+	event := Lookup(topics[0])
+	if event == nil {
+		return nil, errUnknownEvent
+	}
+	detail := &Detail{Event: event.Name}
+	if event.ABI == nil {
+		return detail, nil
+	}
+
+	values := make(map[string]interface{})
+	if err := event.ABI.Inputs.UnpackIntoMap(values, data); err != nil {
+		return nil, fmt.Errorf("unpack %v data failed: %w", event.Name, err)
+	}
+	var indexed abi.Arguments
+	for _, arg := range event.ABI.Inputs {
+		if arg.Indexed {
+			indexed = append(indexed, arg)
+		}
+	}
+	if len(topics)-1 != len(indexed) {
+		return nil, fmt.Errorf("%v has %v topics, want %v", event.Name, len(topics), len(indexed)+1)
+	}
+	indexedTopics := make([]ethcommon.Hash, len(indexed))
+	for i, topic := range topics[1:] {
+		indexedTopics[i] = ethcommon.Hash(topic)
+	}
+	if err := abi.ParseTopicsIntoMap(values, indexed, indexedTopics); err != nil {
+		return nil, fmt.Errorf("parse %v topics failed: %w", event.Name, err)
+	}
+
+	for name, value := range values {
+		switch name {
+		case "token":
+			detail.Token = formatValue(value)
+		case "from":
+			detail.From = formatValue(value)
+		case "to", "receiver":
+			detail.To = formatValue(value)
+		case "amount", "amountIn":
+			detail.Amount = formatValue(value)
+		case "fromChainID":
+			detail.FromChainID = formatValue(value)
+		case "toChainID":
+			detail.ToChainID = formatValue(value)
+		case "swapoutID":
+			detail.SwapoutID = formatValue(value)
+		case "path":
+			detail.Path = formatValues(value)
+		case "tokenId":
+			detail.TokenIDs = []string{formatValue(value)}
+		case "tokenIds":
+			detail.TokenIDs = formatValues(value)
+		case "amounts":
+			detail.Amounts = formatValues(value)
+		case "appID":
+			detail.AppID = formatValue(value)
+		case "anycallProxy", "_fallback":
+			detail.CallProxy = formatValue(value)
+		case "data":
+			detail.CallData = formatValue(value)
+		}
+	}
+	// the swapped token of trade is the first of path
+	if detail.Token == "" && len(detail.Path) > 0 {
+		detail.Token = detail.Path[0]
+	}
+	return detail, nil
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case ethcommon.Address:
+		return v.Hex()
+	case ethcommon.Hash:
+		return v.Hex()
+	case *big.Int:
+		return v.String()
+	case string:
+		return v
+	case []byte:
+		return fmt.Sprintf("0x%x", v)
+	case [32]byte:
+		return fmt.Sprintf("0x%x", v[:])
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func formatValues(value interface{}) (result []string) {
+	switch v := value.(type) {
+	case []ethcommon.Address:
+		for _, item := range v {
+			result = append(result, item.Hex())
+		}
+	case []*big.Int:
+		for _, item := range v {
+			result = append(result, item.String())
+		}
+	default:
+		result = []string{formatValue(value)}
+	}
+	return result
+}
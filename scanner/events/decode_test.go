@@ -0,0 +1,159 @@
+package events
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/jowenshaw/gethclient/common"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+var (
+	testToken   = ethcommon.HexToAddress("0x1111111111111111111111111111111111111111")
+	testFrom    = ethcommon.HexToAddress("0x2222222222222222222222222222222222222222")
+	testTo      = ethcommon.HexToAddress("0x3333333333333333333333333333333333333333")
+	testOutID   = ethcommon.HexToHash("0x4444444444444444444444444444444444444444444444444444444444444444")
+	testAmount  = big.NewInt(1000)
+	testChainID = big.NewInt(56)
+)
+
+// findEvent find registered event of tx type by signature
+func findEvent(t *testing.T, txType, sig string) *Event {
+	t.Helper()
+	for _, topic := range Topics(txType) {
+		if event := Lookup(topic); event.Sig == sig {
+			return event
+		}
+	}
+	t.Fatalf("event %v of %v is not registered", sig, txType)
+	return nil
+}
+
+// packLog pack the arguments of event in order into topics and data
+func packLog(t *testing.T, event *Event, args ...interface{}) ([]common.Hash, []byte) {
+	t.Helper()
+	topics := []common.Hash{event.Topic}
+	var values []interface{}
+	for i, arg := range event.ABI.Inputs {
+		if !arg.Indexed {
+			values = append(values, args[i])
+			continue
+		}
+		var topic common.Hash
+		switch v := args[i].(type) {
+		case ethcommon.Address:
+			topic = common.BytesToHash(v.Bytes())
+		case ethcommon.Hash:
+			topic = common.Hash(v)
+		case *big.Int:
+			topic = common.BigToHash(v)
+		default:
+			t.Fatalf("unsupported indexed argument %v", arg.Name)
+		}
+		topics = append(topics, topic)
+	}
+	data, err := event.ABI.Inputs.NonIndexed().Pack(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return topics, data
+}
+
+func TestDecodeRouterV4(t *testing.T) {
+	event := findEvent(t, params.TxRouterERC20Swap, "LogAnySwapOut(address,address,address,uint256,uint256,uint256)")
+	topics, data := packLog(t, event, testToken, testFrom, testTo, testAmount, big.NewInt(1), testChainID)
+	detail, err := Decode(topics, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Detail{
+		Event:       "LogAnySwapOut",
+		Token:       testToken.Hex(),
+		From:        testFrom.Hex(),
+		To:          testTo.Hex(),
+		Amount:      "1000",
+		FromChainID: "1",
+		ToChainID:   "56",
+	}
+	if !reflect.DeepEqual(*detail, want) {
+		t.Fatalf("got detail %+v, want %+v", detail, want)
+	}
+}
+
+// TestDecodeRouterTrade the token of trade is the first of path
+func TestDecodeRouterTrade(t *testing.T) {
+	event := findEvent(t, params.TxRouterERC20Swap, "LogAnySwapTradeTokensForTokens(address[],address,address,uint256,uint256,uint256,uint256)")
+	path := []ethcommon.Address{testToken, testTo}
+	topics, data := packLog(t, event, path, testFrom, testTo, testAmount, big.NewInt(1), big.NewInt(1), testChainID)
+	detail, err := Decode(topics, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Token != testToken.Hex() || len(detail.Path) != 2 || detail.Path[1] != testTo.Hex() || detail.Amount != "1000" {
+		t.Fatalf("wrong trade detail %+v", detail)
+	}
+}
+
+// TestDecodeRouterV7 router v7 has swapoutID and string receiver, but no fromChainID
+func TestDecodeRouterV7(t *testing.T) {
+	event := findEvent(t, params.TxRouterERC20Swap, "LogAnySwapOut(bytes32,address,address,string,uint256,uint256)")
+	topics, data := packLog(t, event, testOutID, testToken, testFrom, "receiver", testAmount, testChainID)
+	detail, err := Decode(topics, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Detail{
+		Event:     "LogAnySwapOut",
+		Token:     testToken.Hex(),
+		From:      testFrom.Hex(),
+		To:        "receiver",
+		Amount:    "1000",
+		ToChainID: "56",
+		SwapoutID: testOutID.Hex(),
+	}
+	if !reflect.DeepEqual(*detail, want) {
+		t.Fatalf("got detail %+v, want %+v", detail, want)
+	}
+}
+
+// TestDecodeAnycallV6 anycall v6 has indexed toChainID
+func TestDecodeAnycallV6(t *testing.T) {
+	event := findEvent(t, params.TxRouterAnycallSwap, "LogAnyCall(address,address,bytes,address,uint256,uint256,string,uint256)")
+	topics, data := packLog(t, event, testFrom, testTo, []byte{1, 2}, testToken, testChainID, big.NewInt(0), "app", big.NewInt(7))
+	detail, err := Decode(topics, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.From != testFrom.Hex() || detail.To != testTo.Hex() || detail.ToChainID != "56" ||
+		detail.AppID != "app" || detail.CallProxy != testToken.Hex() || detail.CallData != "0x0102" || detail.FromChainID != "" {
+		t.Fatalf("wrong anycall detail %+v", detail)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := Decode(nil, nil); !errors.Is(err, errNoTopics) {
+		t.Errorf("decode without topics got error %v, want %v", err, errNoTopics)
+	}
+	if _, err := Decode([]common.Hash{{1}}, nil); !errors.Is(err, errUnknownEvent) {
+		t.Errorf("decode unknown event got error %v, want %v", err, errUnknownEvent)
+	}
+	event := findEvent(t, params.TxRouterERC20Swap, "LogAnySwapOut(address,address,address,uint256,uint256,uint256)")
+	topics, data := packLog(t, event, testToken, testFrom, testTo, testAmount, big.NewInt(1), testChainID)
+	if _, err := Decode(topics[:2], data); err == nil {
+		t.Error("decode log with missing topics should fail")
+	}
+	if _, err := Decode(topics, data[:32]); err == nil {
+		t.Error("decode log with short data should fail")
+	}
+
+	// legacy event has no abi
+	legacy := common.HexToHash("0xcaac11c45e5fdb5c513e20ac229a3f9f99143580b5eb08d0fecbdd5ae8c81ef5")
+	detail, err := Decode([]common.Hash{legacy}, nil)
+	if err != nil || detail.Event != "LogAnyCallTransferSwapOut" {
+		t.Fatalf("decode legacy event got detail %+v, err %v", detail, err)
+	}
+}
@@ -0,0 +1,226 @@
+// Package events registry of the swap events which are loaded from contract abi files.
+//
+// Abi files are placed as '<dir>/<txType>/<name>.json', every non anonymous event
+// in the file is registered with the tx type of its directory.
+// The builtin abi files (router V4-V7, router NFT, anycall V5-V7) are embedded,
+// a new contract version can be supported by adding its abi file to the extra dir.
+package events
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/jowenshaw/gethclient/common"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+//go:embed abi
+var builtinABIs embed.FS
+
+// legacyTopics topics of events which have no published abi
+var legacyTopics = map[common.Hash]*Event{
+	// anycall transfer swapout
+	common.HexToHash("0xcaac11c45e5fdb5c513e20ac229a3f9f99143580b5eb08d0fecbdd5ae8c81ef5"): {
+		Name:   "LogAnyCallTransferSwapOut",
+		TxType: params.TxRouterAnycallSwap,
+		Source: "legacy",
+	},
+}
+
+// Event swap event
+type Event struct {
+	Name   string
+	Sig    string
+	Topic  common.Hash
+	TxType string
+	Source string     // abi file which the event is loaded from
+	ABI    *abi.Event // nil for legacy topics
+}
+
+// Registry swap events indexed by topic
+type Registry struct {
+	lock   sync.RWMutex
+	events map[common.Hash]*Event
+}
+
+var registry = mustLoadBuiltin()
+
+func mustLoadBuiltin() *Registry {
+	r := NewRegistry()
+	if err := r.LoadFS(builtinABIs, "abi"); err != nil {
+		panic(fmt.Sprintf("load builtin event abis failed: %v", err))
+	}
+	for topic, event := range legacyTopics {
+		event.Topic = topic
+		if err := r.register(event); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// NewRegistry new empty registry
+func NewRegistry() *Registry {
+	return &Registry{events: make(map[common.Hash]*Event)}
+}
+
+// LoadDir load extra abi files in dir to the default registry
+func LoadDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if err := registry.LoadFS(os.DirFS(dir), "."); err != nil {
+		return err
+	}
+	log.Info("load event abis success", "dir", dir)
+	return nil
+}
+
+// LoadFS load abi files of '<root>/<txType>/*.json' in fsys
+func (r *Registry) LoadFS(fsys fs.FS, root string) error {
+	dirs, err := fs.ReadDir(fsys, root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		txType := dir.Name()
+		if !isRouterTxType(txType) {
+			return fmt.Errorf("abi dir '%v' is not a router tx type", txType)
+		}
+		files, err := fs.Glob(fsys, path.Join(root, txType, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := r.loadFile(fsys, file, txType); err != nil {
+				return fmt.Errorf("load abi file '%v' failed: %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Registry) loadFile(fsys fs.FS, file, txType string) error {
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return err
+	}
+	contract, err := abi.JSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for _, ev := range contract.Events {
+		if ev.Anonymous {
+			continue
+		}
+		ev := ev
+		err = r.register(&Event{
+			Name:   ev.RawName,
+			Sig:    ev.Sig,
+			Topic:  common.Hash(ev.ID),
+			TxType: txType,
+			Source: file,
+			ABI:    &ev,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) register(event *Event) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if exist, ok := r.events[event.Topic]; ok {
+		if exist.TxType != event.TxType {
+			return fmt.Errorf("event %v of %v conflicts with %v of %v", event.Sig, event.TxType, exist.Sig, exist.TxType)
+		}
+		return nil // same event in abi files of different versions
+	}
+	r.events[event.Topic] = event
+	log.Debug("register swap event", "event", event.Sig, "topic", event.Topic.Hex(), "txType", event.TxType, "source", event.Source)
+	return nil
+}
+
+// Lookup get event by topic
+func (r *Registry) Lookup(topic common.Hash) *Event {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.events[topic]
+}
+
+// Topics get the topics of tx type, sorted for stable filter queries
+func (r *Registry) Topics(txType string) (topics []common.Hash) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for topic, event := range r.events {
+		if event.TxType == txType {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Hex() < topics[j].Hex()
+	})
+	return topics
+}
+
+// Lookup get event by topic from the default registry
+func Lookup(topic common.Hash) *Event {
+	return registry.Lookup(topic)
+}
+
+// Topics get the topics of tx type from the default registry
+func Topics(txType string) []common.Hash {
+	return registry.Topics(txType)
+}
+
+// TopicsOfToken get the topics of the router swap token,
+// gas swap tokens share the events of erc20 router swap.
+func TopicsOfToken(tokenCfg *params.TokenConfig) []common.Hash {
+	switch {
+	case tokenCfg.IsRouterERC20Swap():
+		return Topics(params.TxRouterERC20Swap)
+	case tokenCfg.IsRouterNFTSwap():
+		return Topics(params.TxRouterNFTSwap)
+	case tokenCfg.IsRouterAnycallSwap():
+		return Topics(params.TxRouterAnycallSwap)
+	default:
+		return nil
+	}
+}
+
+// IsTokenEvent whether the log topic is a swap event of the router swap token
+func IsTokenEvent(topic common.Hash, tokenCfg *params.TokenConfig) bool {
+	event := Lookup(topic)
+	if event == nil {
+		return false
+	}
+	switch {
+	case tokenCfg.IsRouterERC20Swap():
+		return event.TxType == params.TxRouterERC20Swap
+	default:
+		return event.TxType == tokenCfg.TxType
+	}
+}
+
+func isRouterTxType(txType string) bool {
+	switch txType {
+	case params.TxRouterERC20Swap, params.TxRouterNFTSwap, params.TxRouterAnycallSwap:
+		return true
+	default:
+		return false
+	}
+}
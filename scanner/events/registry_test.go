@@ -0,0 +1,105 @@
+package events
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/jowenshaw/gethclient/common"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+const testEventABI = `[{"anonymous":false,"type":"event","name":"LogAnySwapOutV8","inputs":[
+	{"indexed":true,"name":"token","type":"address"},
+	{"indexed":false,"name":"amount","type":"uint256"}]}]`
+
+// TestBuiltinTopics the topics which were hardcoded before are computed from the builtin abis
+func TestBuiltinTopics(t *testing.T) {
+	tests := map[string]string{
+		"0x97116cf6cd4f6412bb47914d6db18da9e16ab2142f543b86e207c24fbd16b23a": params.TxRouterERC20Swap,   // LogAnySwapOut v4
+		"0x409e0ad946b19f77602d6cf11d59e1796ddaa4828159a0b4fb7fa2ff6b161b79": params.TxRouterERC20Swap,   // LogAnySwapOut v6
+		"0x0d969ae475ff6fcaf0dcfa760d4d8607244e8d95e9bf426f8d5d69f9a3e525af": params.TxRouterERC20Swap,   // LogAnySwapOut v7
+		"0xfea6abdf4fd32f20966dec7d2bc6ab0e2a9b4e3a8d4d4d4d1dd7a3c4be2b6d5e": "",                         // unknown
+		"0xcaac11c45e5fdb5c513e20ac229a3f9f99143580b5eb08d0fecbdd5ae8c81ef5": params.TxRouterAnycallSwap, // legacy
+	}
+	for topic, txType := range tests {
+		event := Lookup(common.HexToHash(topic))
+		if txType == "" {
+			if event != nil {
+				t.Errorf("unknown topic %v is registered as %v", topic, event.Sig)
+			}
+			continue
+		}
+		if event == nil || event.TxType != txType {
+			t.Errorf("topic %v is registered as %+v, want %v", topic, event, txType)
+		}
+	}
+	for _, txType := range []string{params.TxRouterERC20Swap, params.TxRouterNFTSwap, params.TxRouterAnycallSwap} {
+		if len(Topics(txType)) == 0 {
+			t.Errorf("no builtin topics of %v", txType)
+		}
+	}
+}
+
+func TestRegistryLoadFS(t *testing.T) {
+	r := NewRegistry()
+	err := r.LoadFS(fstest.MapFS{
+		"abi/routerswap/router_v8.json": {Data: []byte(testEventABI)},
+		"abi/routerswap/readme.txt":     {Data: []byte("ignored")},
+	}, "abi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := r.Topics(params.TxRouterERC20Swap)
+	if len(topics) != 1 {
+		t.Fatalf("registered %v topics, want 1", len(topics))
+	}
+	event := r.Lookup(topics[0])
+	if event.Sig != "LogAnySwapOutV8(address,uint256)" || event.Source != "abi/routerswap/router_v8.json" {
+		t.Fatalf("wrong registered event %+v", event)
+	}
+
+	// the same event of another version is registered once
+	err = r.LoadFS(fstest.MapFS{"abi/routerswap/router_v9.json": {Data: []byte(testEventABI)}}, "abi")
+	if err != nil || len(r.Topics(params.TxRouterERC20Swap)) != 1 {
+		t.Fatalf("load the same event again got error %v", err)
+	}
+	// the same event of another tx type conflicts
+	if err = r.LoadFS(fstest.MapFS{"abi/nftswap/nft.json": {Data: []byte(testEventABI)}}, "abi"); err == nil {
+		t.Fatal("load event of another tx type should fail")
+	}
+	if err = r.LoadFS(fstest.MapFS{"abi/swapin/bridge.json": {Data: []byte(testEventABI)}}, "abi"); err == nil {
+		t.Fatal("load abi of non router tx type should fail")
+	}
+	if err = r.LoadFS(fstest.MapFS{"abi/routerswap/wrong.json": {Data: []byte("{")}}, "abi"); err == nil {
+		t.Fatal("load wrong abi file should fail")
+	}
+}
+
+// TestTopicsOfToken gas swap tokens share the events of erc20 router swap
+func TestTopicsOfToken(t *testing.T) {
+	v4 := common.HexToHash("0x97116cf6cd4f6412bb47914d6db18da9e16ab2142f543b86e207c24fbd16b23a")
+	tests := []struct {
+		txType string
+		match  bool
+	}{
+		{params.TxRouterERC20Swap, true},
+		{params.TxRouterGas, true},
+		{params.TxRouterNFTSwap, false},
+		{params.TxRouterAnycallSwap, false},
+		{params.TxSwapin, false},
+	}
+	for _, test := range tests {
+		tokenCfg := &params.TokenConfig{TxType: test.txType}
+		if got := IsTokenEvent(v4, tokenCfg); got != test.match {
+			t.Errorf("router event is token event of %v: %v, want %v", test.txType, got, test.match)
+		}
+		found := false
+		for _, topic := range TopicsOfToken(tokenCfg) {
+			found = found || topic == v4
+		}
+		if found != test.match {
+			t.Errorf("router event is in topics of %v: %v, want %v", test.txType, found, test.match)
+		}
+	}
+}
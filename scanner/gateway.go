@@ -0,0 +1,392 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	ethclient "github.com/jowenshaw/gethclient"
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+	rpc "github.com/jowenshaw/gethrpc"
+
+	"github.com/weijun-sh/gethscan/metrics"
+)
+
+const (
+	defaultGatewayMaxLag        = 10
+	defaultGatewayCheckInterval = 60 // seconds
+
+	gatewayMaxErrors      = 3                // consecutive errors before cooling down
+	gatewayCooldown       = 30 * time.Second // time to wait before reusing an unhealthy gateway
+	gatewayLimitCooldown  = 5 * time.Minute  // cooldown when reached maximum request limit
+	gatewayInitialLatency = 100 * time.Millisecond
+)
+
+var (
+	errNoBatchClient = errors.New("gateway does not support raw rpc calls")
+	errNoGateway     = errors.New("no connected gateway on the chain")
+)
+
+// Client rpc client of evm chain, it's implemented by *ethclient.Client
+type Client interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+	SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error)
+}
+
+// BatchClient raw json-rpc client, it's implemented by *rpc.Client.
+// It's optional, and is used to prefetch receipts and trace blocks.
+type BatchClient interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+}
+
+// gateway rpc endpoint with health scoring
+type gateway struct {
+	url       string
+	client    Client      // nil if not connected, re-dialed when checking health
+	rpcClient BatchClient // raw rpc client for batch calls, nil if not supported
+
+	lock          sync.Mutex
+	latency       time.Duration // moving average of call latency
+	errCount      uint64        // consecutive errors
+	totalCalls    uint64
+	totalErrors   uint64
+	height        uint64
+	disabled      bool // wrong chainID or lagging too far behind
+	wrongChain    bool // wrong chainID, never used even if all gateways are unavailable
+	cooldownUntil time.Time
+}
+
+func (gw *gateway) clients() (Client, BatchClient) {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	return gw.client, gw.rpcClient
+}
+
+func (gw *gateway) setClients(client Client, rpcClient BatchClient) {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	gw.client = client
+	gw.rpcClient = rpcClient
+}
+
+// score lower is better
+func (gw *gateway) score() int64 {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	return int64(gw.latency/time.Millisecond+1) * int64(gw.errCount+1)
+}
+
+func (gw *gateway) isAvailable(now time.Time) bool {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	return gw.client != nil && !gw.disabled && !now.Before(gw.cooldownUntil)
+}
+
+// isUsable connected to the chain, it's used if all gateways are unavailable
+func (gw *gateway) isUsable() bool {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	return gw.client != nil && !gw.wrongChain
+}
+
+func (gw *gateway) record(latency time.Duration, err error) {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+
+	gw.totalCalls++
+	gw.latency = (gw.latency*4 + latency) / 5
+	if err == nil {
+		gw.errCount = 0
+		return
+	}
+	gw.totalErrors++
+	gw.errCount++
+	switch {
+	case strings.Contains(err.Error(), errMaximumRequestLimit):
+		gw.cooldownUntil = time.Now().Add(gatewayLimitCooldown)
+		log.Warn("gateway reached maximum request limit", "gateway", gw.url, "cooldown", gatewayLimitCooldown)
+	case gw.errCount >= gatewayMaxErrors:
+		gw.cooldownUntil = time.Now().Add(gatewayCooldown)
+		log.Warn("gateway has too many errors", "gateway", gw.url, "errors", gw.errCount, "cooldown", gatewayCooldown)
+	}
+}
+
+// gatewayPool gateways of the same chain, choose the best one to call
+type gatewayPool struct {
+	chain    string // label of rpc metrics
+	gateways []*gateway
+	chainID  *big.Int
+	maxLag   uint64
+	noBatch  bool // raw rpc calls are not supported
+}
+
+func newGatewayPool(chain string, urls []string, maxLag uint64) *gatewayPool {
+	if maxLag == 0 {
+		maxLag = defaultGatewayMaxLag
+	}
+	pool := &gatewayPool{chain: chain, maxLag: maxLag}
+	exist := make(map[string]struct{})
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+		if _, ok := exist[url]; ok {
+			continue
+		}
+		exist[url] = struct{}{}
+		pool.gateways = append(pool.gateways, &gateway{
+			url:     url,
+			latency: gatewayInitialLatency,
+		})
+	}
+	return pool
+}
+
+// newClientPool pool of the given client, eg. the client of embedded scanner.
+// Raw rpc calls are supported if the client implements BatchClient.
+func newClientPool(ctx context.Context, chain string, client Client) (*gatewayPool, error) {
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gw := &gateway{
+		url:     chain,
+		client:  client,
+		latency: gatewayInitialLatency,
+	}
+	if batchClient, ok := client.(BatchClient); ok {
+		gw.rpcClient = batchClient
+	}
+	return &gatewayPool{
+		chain:    chain,
+		gateways: []*gateway{gw},
+		chainID:  chainID,
+		maxLag:   defaultGatewayMaxLag,
+		noBatch:  gw.rpcClient == nil,
+	}, nil
+}
+
+// dialGateway connect gateway and get its chainID
+func dialGateway(ctx context.Context, url string) (*ethclient.Client, *rpc.Client, *big.Int, error) {
+	rpccli, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ethcli := ethclient.NewClient(rpccli)
+	chainID, err := ethcli.ChainID(ctx)
+	if err != nil {
+		rpccli.Close()
+		return nil, nil, nil, err
+	}
+	return ethcli, rpccli, chainID, nil
+}
+
+// dial connect all gateways and check they are on the same chain,
+// the failed gateways are kept and re-dialed when checking health.
+// The chainID of the first connected gateway is expected, the gateways of other chains are disabled.
+func (pool *gatewayPool) dial(ctx context.Context) {
+	if len(pool.gateways) == 0 {
+		log.Fatal("no gateway specified")
+	}
+	connected := 0
+	for _, gw := range pool.gateways {
+		ethcli, rpccli, chainID, err := dialGateway(ctx, gw.url)
+		if err != nil {
+			log.Warn("ethclient.Dail failed, retry later", "gateway", gw.url, "err", err)
+			continue
+		}
+		if pool.chainID == nil {
+			pool.chainID = chainID
+		} else if pool.chainID.Cmp(chainID) != 0 {
+			log.Error("gateway chainID mismatch, disable it", "gateway", gw.url, "chainID", chainID, "expect", pool.chainID)
+			rpccli.Close()
+			gw.lock.Lock()
+			gw.disabled = true
+			gw.wrongChain = true
+			gw.lock.Unlock()
+			continue
+		}
+		log.Info("ethclient.Dail gateway success", "gateway", gw.url, "chainID", chainID)
+		gw.setClients(ethcli, rpccli)
+		connected++
+	}
+	if connected == 0 {
+		log.Fatal("dial all gateways failed")
+	}
+}
+
+// redial connect the gateway which failed to connect before
+func (pool *gatewayPool) redial(ctx context.Context, gw *gateway) bool {
+	ethcli, rpccli, chainID, err := dialGateway(ctx, gw.url)
+	if err != nil {
+		log.Debug("redial gateway failed", "gateway", gw.url, "err", err)
+		return false
+	}
+	if chainID.Cmp(pool.chainID) != 0 {
+		log.Error("gateway chainID mismatch", "gateway", gw.url, "chainID", chainID, "expect", pool.chainID)
+		rpccli.Close()
+		return false
+	}
+	log.Info("redial gateway success", "gateway", gw.url, "chainID", chainID)
+	gw.setClients(ethcli, rpccli)
+	return true
+}
+
+// best choose the available gateway with the lowest score,
+// or the usable one with the lowest score if all are unavailable
+func (pool *gatewayPool) best() *gateway {
+	now := time.Now()
+	var best, fallback *gateway
+	var bestScore, fallbackScore int64
+	for _, gw := range pool.gateways {
+		score := gw.score()
+		if gw.isAvailable(now) && (best == nil || score < bestScore) {
+			best, bestScore = gw, score
+		}
+		if gw.isUsable() && (fallback == nil || score < fallbackScore) {
+			fallback, fallbackScore = gw, score
+		}
+	}
+	if best == nil {
+		return fallback
+	}
+	return best
+}
+
+// headSubscriber choose the available gateway with the lowest score which can subscribe new heads,
+// http gateways are skipped as they do not support subscriptions.
+func (pool *gatewayPool) headSubscriber() (*gateway, HeadSubscriber) {
+	now := time.Now()
+	var best *gateway
+	var bestSubscriber HeadSubscriber
+	var bestScore int64
+	for _, gw := range pool.gateways {
+		if isHTTPURL(gw.url) || !gw.isAvailable(now) {
+			continue
+		}
+		client, _ := gw.clients()
+		subscriber, ok := client.(HeadSubscriber)
+		if !ok {
+			continue
+		}
+		if score := gw.score(); best == nil || score < bestScore {
+			best, bestSubscriber, bestScore = gw, subscriber, score
+		}
+	}
+	return best, bestSubscriber
+}
+
+func isHTTPURL(url string) bool {
+	url = strings.ToLower(url)
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// call call rpc method with the best gateway and record the result
+func (pool *gatewayPool) call(method string, f func(Client) error) error {
+	return pool.do(method, func(gw *gateway) error {
+		client, _ := gw.clients()
+		return f(client)
+	})
+}
+
+// callRPC call with the raw rpc client of the best gateway, eg. batch calls
+func (pool *gatewayPool) callRPC(method string, f func(BatchClient) error) error {
+	if pool.noBatch {
+		return errNoBatchClient
+	}
+	return pool.do(method, func(gw *gateway) error {
+		_, rpcClient := gw.clients()
+		return f(rpcClient)
+	})
+}
+
+func (pool *gatewayPool) do(method string, f func(*gateway) error) error {
+	gw := pool.best()
+	if gw == nil {
+		return errNoGateway
+	}
+	start := time.Now()
+	err := f(gw)
+	latency := time.Since(start)
+	gw.record(latency, err)
+	metrics.ObserveRPC(pool.chain, method, latency, err)
+	if err != nil {
+		log.Debug("call gateway failed", "gateway", gw.url, "method", method, "err", err)
+	}
+	return err
+}
+
+// checkHealth verify chainID and latest height of every gateway,
+// and redial the gateways which failed to connect before
+func (pool *gatewayPool) checkHealth(ctx context.Context) {
+	var maxHeight uint64
+	for _, gw := range pool.gateways {
+		client, _ := gw.clients()
+		if client == nil {
+			if !pool.redial(ctx, gw) {
+				continue
+			}
+			client, _ = gw.clients()
+		}
+		var wrongChain bool
+		chainID, err := client.ChainID(ctx)
+		if err == nil && chainID.Cmp(pool.chainID) != 0 {
+			log.Error("gateway chainID mismatch", "gateway", gw.url, "chainID", chainID, "expect", pool.chainID)
+			wrongChain = true
+		}
+		var height uint64
+		if err == nil {
+			start := time.Now()
+			header, errh := client.HeaderByNumber(ctx, nil)
+			gw.record(time.Since(start), errh)
+			if errh == nil {
+				height = header.Number.Uint64()
+			}
+		}
+		gw.lock.Lock()
+		gw.disabled = wrongChain
+		gw.wrongChain = wrongChain
+		gw.height = height
+		gw.lock.Unlock()
+		if height > maxHeight {
+			maxHeight = height
+		}
+	}
+	for _, gw := range pool.gateways {
+		gw.lock.Lock()
+		if gw.client != nil && gw.height+pool.maxLag < maxHeight {
+			log.Warn("gateway is lagging behind", "gateway", gw.url, "height", gw.height, "maxHeight", maxHeight, "maxLag", pool.maxLag)
+			gw.disabled = true
+		}
+		log.Debug("gateway health", "gateway", gw.url, "height", gw.height, "latency", gw.latency, "calls", gw.totalCalls, "errors", gw.totalErrors, "disabled", gw.disabled)
+		gw.lock.Unlock()
+	}
+}
+
+func (pool *gatewayPool) loopCheckHealth(ctx context.Context, interval time.Duration) {
+	if len(pool.gateways) < 2 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pool.checkHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
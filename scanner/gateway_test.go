@@ -0,0 +1,192 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+)
+
+// newRPCServer json-rpc server of chain 1 at height 100, it replies 503 if it's down
+func newRPCServer(t *testing.T, down *int32) *httptest.Server {
+	return newChainRPCServer(t, down, "0x1")
+}
+
+// newChainRPCServer json-rpc server of chainID at height 100
+func newChainRPCServer(t *testing.T, down *int32, chainID string) *httptest.Server {
+	header, _ := json.Marshal(&types.Header{Number: big.NewInt(100), Difficulty: big.NewInt(1)})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down != nil && atomic.LoadInt32(down) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		var result json.RawMessage
+		switch req.Method {
+		case "eth_chainId":
+			result, _ = json.Marshal(chainID)
+		case "eth_getBlockByNumber":
+			result = header
+		default:
+			result = json.RawMessage(`null`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestGatewayRedial the gateways failed to connect are kept and re-dialed
+func TestGatewayRedial(t *testing.T) {
+	var down int32 = 1
+	up, failed := newRPCServer(t, nil), newRPCServer(t, &down)
+	pool := newGatewayPool("eth", []string{up.URL, failed.URL}, 0)
+	pool.dial(context.Background())
+	if len(pool.gateways) != 2 {
+		t.Fatalf("pool has %v gateways after dial, want 2", len(pool.gateways))
+	}
+	if client, _ := pool.gateways[1].clients(); client != nil {
+		t.Fatal("failed gateway is connected")
+	}
+	for i := 0; i < 10; i++ {
+		if gw := pool.best(); gw != pool.gateways[0] {
+			t.Fatalf("not connected gateway %v is chosen", gw.url)
+		}
+	}
+
+	atomic.StoreInt32(&down, 0)
+	pool.checkHealth(context.Background())
+	gw := pool.gateways[1]
+	if client, _ := gw.clients(); client == nil {
+		t.Fatal("failed gateway is not re-dialed")
+	}
+	if gw.height != 100 || gw.disabled {
+		t.Fatalf("re-dialed gateway has height %v disabled %v", gw.height, gw.disabled)
+	}
+}
+
+// TestGatewayDialWrongChain the gateway of other chain is disabled when dialing
+func TestGatewayDialWrongChain(t *testing.T) {
+	good, wrong := newRPCServer(t, nil), newChainRPCServer(t, nil, "0x2")
+	pool := newGatewayPool("eth", []string{good.URL, wrong.URL}, 0)
+	pool.dial(context.Background())
+	if pool.chainID.Int64() != 1 {
+		t.Fatalf("pool has chainID %v, want 1", pool.chainID)
+	}
+	gw := pool.gateways[1]
+	if client, _ := gw.clients(); client != nil || !gw.wrongChain || !gw.disabled {
+		t.Fatal("gateway of wrong chain is not disabled")
+	}
+	pool.checkHealth(context.Background())
+	if client, _ := gw.clients(); client != nil || !gw.wrongChain {
+		t.Fatal("gateway of wrong chain is re-dialed")
+	}
+	if gw := pool.best(); gw != pool.gateways[0] {
+		t.Fatalf("gateway %v is chosen, want the good one", gw.url)
+	}
+}
+
+// TestGatewayBestSkipsWrongChain the gateway of wrong chain is never chosen
+func TestGatewayBestSkipsWrongChain(t *testing.T) {
+	good, wrong := newStubClient(), newStubClient()
+	wrong.chainID = big.NewInt(2)
+	good.addHeader(100, [32]byte{}, 0)
+	wrong.addHeader(100, [32]byte{}, 0)
+	pool := &gatewayPool{
+		chain:   "eth",
+		chainID: big.NewInt(1),
+		maxLag:  defaultGatewayMaxLag,
+		gateways: []*gateway{
+			{url: "good", client: good, latency: time.Second},
+			{url: "wrong", client: wrong, latency: time.Millisecond},
+			{url: "disconnected", latency: time.Nanosecond},
+		},
+	}
+	pool.gateways[2].url = "http://127.0.0.1:1" // refused when re-dialed
+	pool.checkHealth(context.Background())
+	if !pool.gateways[1].wrongChain || !pool.gateways[1].disabled {
+		t.Fatal("gateway of wrong chain is not disabled")
+	}
+	if gw := pool.best(); gw.url != "good" {
+		t.Fatalf("gateway %v is chosen, want good", gw.url)
+	}
+
+	// the good one is cooling down, but the wrong one is not a fallback
+	for i := 0; i < gatewayMaxErrors; i++ {
+		pool.gateways[0].record(time.Second, errors.New("timeout"))
+	}
+	if pool.gateways[0].isAvailable(time.Now()) {
+		t.Fatal("gateway with errors is available")
+	}
+	if gw := pool.best(); gw.url != "good" {
+		t.Fatalf("fallback gateway %v is chosen, want good", gw.url)
+	}
+
+	pool.gateways = pool.gateways[1:]
+	if err := pool.call("eth_blockNumber", func(Client) error { return nil }); !errors.Is(err, errNoGateway) {
+		t.Fatalf("call without usable gateway got error %v, want %v", err, errNoGateway)
+	}
+}
+
+// stubHeadClient stub client which can subscribe new heads
+type stubHeadClient struct {
+	*stubClient
+	subscribed int
+}
+
+type stubSubscription struct{ err chan error }
+
+func (s *stubSubscription) Unsubscribe()      {}
+func (s *stubSubscription) Err() <-chan error { return s.err }
+
+func (c *stubHeadClient) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	c.subscribed++
+	return &stubSubscription{err: make(chan error)}, nil
+}
+
+// TestSubscribeNewHeadsWithWebsocket newHeads are subscribed with websocket gateway without health scoring
+func TestSubscribeNewHeadsWithWebsocket(t *testing.T) {
+	httpClient, wsClient := &stubHeadClient{stubClient: newStubClient()}, &stubHeadClient{stubClient: newStubClient()}
+	pool := &gatewayPool{
+		chain:   "eth",
+		chainID: big.NewInt(1),
+		gateways: []*gateway{
+			{url: "https://rpc", client: httpClient, latency: time.Millisecond},
+			{url: "ws://nosub", client: newStubClient(), latency: time.Millisecond},
+			{url: "wss://rpc", client: wsClient, latency: time.Second},
+		},
+	}
+	w := &headWatcher{scanner: &ethSwapScanner{chain: "eth", ctx: context.Background(), gateways: pool}}
+	if _, err := w.subscribe(make(chan *types.Header)); err != nil {
+		t.Fatal(err)
+	}
+	if httpClient.subscribed != 0 || wsClient.subscribed != 1 {
+		t.Fatalf("subscribed %v times with http gateway, %v times with ws gateway", httpClient.subscribed, wsClient.subscribed)
+	}
+	for _, gw := range pool.gateways {
+		if gw.totalCalls != 0 {
+			t.Errorf("subscription is recorded in health of gateway %v", gw.url)
+		}
+	}
+	if gw := pool.best(); gw.url != "https://rpc" {
+		t.Fatalf("gateway %v is chosen to call, want https://rpc", gw.url)
+	}
+
+	pool.gateways = pool.gateways[:2]
+	if _, err := w.subscribe(make(chan *types.Header)); !errors.Is(err, errNoHeadSubscriber) {
+		t.Fatalf("subscribe without ws gateway got error %v, want %v", err, errNoHeadSubscriber)
+	}
+}
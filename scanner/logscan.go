@@ -0,0 +1,209 @@
+package scanner
+
+import (
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+const (
+	defaultGetLogsMaxBlocks = 100
+	getLogsGrowAfter        = 3 // grow chunk size after continuous successes
+)
+
+// logsChunker adaptive block range of getLogs
+type logsChunker struct {
+	size      uint64
+	maxSize   uint64
+	successes int
+}
+
+func newLogsChunker(maxSize uint64) *logsChunker {
+	if maxSize == 0 {
+		maxSize = defaultGetLogsMaxBlocks
+	}
+	return &logsChunker{
+		size:    maxSize,
+		maxSize: maxSize,
+	}
+}
+
+func (c *logsChunker) shrink() {
+	c.successes = 0
+	if c.size > 1 {
+		c.size /= 2
+	}
+}
+
+func (c *logsChunker) success() {
+	c.successes++
+	if c.successes >= getLogsGrowAfter && c.size < c.maxSize {
+		c.successes = 0
+		c.size *= 2
+		if c.size > c.maxSize {
+			c.size = c.maxSize
+		}
+	}
+}
+
+// isLogsRangeError provider rejects getLogs as the range is too large or has too many results
+func isLogsRangeError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{
+		"query returned more than",
+		"block range",
+		"response size exceeded",
+		"limit exceeded",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLogsCovered whether the swaps of token are detected by scanning logs in log scan mode
+func (scanner *ethSwapScanner) isLogsCovered(tokenCfg *params.TokenConfig) bool {
+	return scanner.scanLogs && tokenCfg.IsRouterSwapAll() && !scanner.ignoreType(tokenCfg.TxType)
+}
+
+// needScanBlocks whether some tokens can only be detected by scanning transactions
+func (scanner *ethSwapScanner) needScanBlocks() bool {
+	for _, tokenCfg := range scanner.tokens() {
+		if !scanner.isLogsCovered(tokenCfg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (scanner *ethSwapScanner) getLogsInRange(from, to uint64, fq ethereum.FilterQuery) (logs []types.Log, err error) {
+	fq.FromBlock = new(big.Int).SetUint64(from)
+	fq.ToBlock = new(big.Int).SetUint64(to)
+	err = scanner.gateways.call("eth_getLogs", func(cli Client) (err error) {
+		logs, err = cli.FilterLogs(scanner.ctx, fq)
+		return err
+	})
+	return logs, err
+}
+
+// scanLogsRange scan router logs in range [from, to] with adaptive chunks,
+// the swaps of logs are enqueued before return. Returns false if it's stopped.
+// done is called with the end of every handled chunk if it's not nil.
+func (scanner *ethSwapScanner) scanLogsRange(from, to uint64, done func(end uint64)) bool {
+	bcConfig := scanner.chainCfg.BlockChain
+	interval := time.Duration(bcConfig.GetLogsInterval) * time.Second
+	chunker := newLogsChunker(bcConfig.GetLogsMaxBlocks)
+	log.Info("start scan logs range", "from", from, "to", to, "maxBlocks", chunker.maxSize, "interval", interval)
+
+	queries := []struct {
+		fq     ethereum.FilterQuery
+		prefix string
+	}{
+		{scanner.fqSwapRouter, prefixSwapRouter},
+		{scanner.fqSwapRouterNFT, prefixSwapRouterNFT},
+		{scanner.fqSwapRouterAnycall, prefixSwapRouterAnycall},
+	}
+
+	for start := from; start <= to; {
+		if scanner.isStopping() {
+			log.Info("scan logs range stopped", "from", from, "to", to, "height", start)
+			return false
+		}
+		end := start + chunker.size - 1
+		if end > to || end < start {
+			end = to
+		}
+		// get logs of all queries before handling to avoid duplicate posts when retrying
+		var err error
+		results := make([][]types.Log, len(queries))
+		for i, query := range queries {
+			if len(query.fq.Addresses) == 0 {
+				continue
+			}
+			results[i], err = scanner.getLogsInRange(start, end, query.fq)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			if isLogsRangeError(err) && chunker.size > 1 {
+				chunker.shrink()
+				log.Info("shrink getLogs range", "from", start, "to", end, "size", chunker.size, "err", err)
+			} else {
+				log.Warn("scan logs range failed, retry later", "from", start, "to", end, "err", err)
+				scanner.sleep(scanner.rpcInterval)
+			}
+			continue
+		}
+		// get the swaps of all logs before enqueuing, the chunk is retried if any fails
+		var swaps []*swapPost
+		count := 0
+		for i, logs := range results {
+			for j := range logs {
+				var swap *swapPost
+				if swap, err = scanner.routerSwapOfLog(queries[i].prefix, &logs[j]); err != nil {
+					break
+				}
+				if swap != nil {
+					swaps = append(swaps, swap)
+				}
+				count++
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Warn("handle logs range failed, retry later", "from", start, "to", end, "err", err)
+			scanner.sleep(scanner.rpcInterval)
+			continue
+		}
+		for _, swap := range swaps {
+			if scanner.isStopping() {
+				return false
+			}
+			scanner.enqueueSwap(swap)
+		}
+		log.Info("scan logs range success", "from", start, "to", end, "logs", count)
+		if done != nil {
+			done(end)
+		}
+		chunker.success()
+		if end == to {
+			break
+		}
+		start = end + 1
+		if interval > 0 {
+			scanner.sleep(interval)
+		}
+	}
+	log.Info("scan logs range finish", "from", from, "to", to)
+	return true
+}
+
+// doScanLogsRangeJob scan logs of range [start, end) concurrently with the block scanning jobs,
+// the cursor is checkpointed like the range job and resumed from when run again.
+func (scanner *ethSwapScanner) doScanLogsRangeJob(start, end uint64, wg *sync.WaitGroup) {
+	defer wg.Done()
+	jobs := newRangeJobs(logsRangeJobChain(scanner.chain), start, end, 1, scanner.store, scanner.posts.lowestHeightFrom)
+	s := jobs.take()
+	if s == nil {
+		return
+	}
+	if s.cursor > s.from {
+		log.Info("resume scan logs range from checkpoint", "start", start, "end", end, "cursor", s.cursor)
+	}
+	if !scanner.scanLogsRange(s.cursor, s.to-1, func(end uint64) { jobs.done(s, end) }) {
+		jobs.stop(s)
+		return
+	}
+	go scanner.loopFlushRangeJobs(jobs)
+}
@@ -0,0 +1,243 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+var testRouter = common.HexToAddress("0x6b7a87899490EcE95443e979cA9485CBE7E71522")
+
+func TestLogsChunker(t *testing.T) {
+	c := newLogsChunker(0)
+	if c.size != defaultGetLogsMaxBlocks {
+		t.Fatalf("default size %v, want %v", c.size, defaultGetLogsMaxBlocks)
+	}
+	c = newLogsChunker(8)
+	for _, want := range []uint64{4, 2, 1, 1} {
+		c.shrink()
+		if c.size != want {
+			t.Fatalf("shrink to %v, want %v", c.size, want)
+		}
+	}
+	for _, want := range []uint64{1, 1, 2, 2, 2, 4, 4, 4, 8, 8, 8, 8} {
+		c.success()
+		if c.size != want {
+			t.Fatalf("grow to %v, want %v", c.size, want)
+		}
+	}
+}
+
+func TestIsLogsRangeError(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"query returned more than 10000 results": true,
+		"block range is too wide":                true,
+		"Log response size exceeded":             true,
+		"connection refused":                     false,
+		"block number out of range":              false,
+		"header not found":                       false,
+	} {
+		if got := isLogsRangeError(errors.New(msg)); got != want {
+			t.Errorf("isLogsRangeError(%q) is %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func newLogScanner(t *testing.T, client *stubClient) *ethSwapScanner {
+	scanner := newStubScanner(t, client)
+	scanner.scanLogs = true
+	scanner.chainCfg.BlockChain.GetLogsMaxBlocks = 4
+	scanner.fqSwapRouter.Addresses = []common.Address{testRouter}
+	scanner.tokenSwap = map[string]*params.TokenConfig{
+		strings.ToLower(fmt.Sprintf("%v-%v", prefixSwapRouter, testRouter)): {
+			TxType:     params.TxRouterERC20Swap,
+			ChainID:    "1",
+			SwapServer: "http://127.0.0.1:1",
+		},
+	}
+	return scanner
+}
+
+func testRouterLog(height uint64, blockHash common.Hash, tx byte, index uint) types.Log {
+	return types.Log{
+		Address:     testRouter,
+		BlockNumber: height,
+		BlockHash:   blockHash,
+		TxHash:      common.BytesToHash([]byte{tx}),
+		Index:       index,
+	}
+}
+
+// TestScanLogsRange the swaps of logs are queued before return,
+// so the synced block number is not persisted beyond them
+func TestScanLogsRange(t *testing.T) {
+	client := newStubClient()
+	client.addLog(testRouterLog(5, common.Hash{5}, 1, 0))
+	client.addLog(testRouterLog(5, common.Hash{5}, 1, 3))
+	client.addLog(testRouterLog(12, common.Hash{12}, 2, 0))
+	client.addLog(types.Log{Address: common.HexToAddress("0x1"), BlockNumber: 6, TxHash: common.Hash{3}})
+	// provider rejects range of more than 2 blocks
+	client.filterLogs = func(from, to uint64) error {
+		if to-from+1 > 2 {
+			return errors.New("query returned more than 10000 results")
+		}
+		return nil
+	}
+	scanner := newLogScanner(t, client)
+	if !scanner.scanLogsRange(1, 14, nil) {
+		t.Fatal("scan logs range is stopped")
+	}
+	if n := len(scanner.posts.swaps); n != 3 {
+		t.Fatalf("queued %v swaps, want 3", n)
+	}
+	first := <-scanner.posts.swaps
+	if first.blockNumber != 5 || first.logIndex != "0" || first.txid != common.BytesToHash([]byte{1}).Hex() {
+		t.Fatalf("wrong swap of log %+v", first)
+	}
+	second := <-scanner.posts.swaps
+	if second.logIndex != "1" { // position in receipt logs
+		t.Fatalf("wrong log index %v of second log", second.logIndex)
+	}
+	atomic.StoreUint64(&scanner.syncedNumber, 14)
+	if saved := scanner.syncedNumberToSave(); saved != 4 {
+		t.Fatalf("synced number to save is %v, want 4", saved)
+	}
+}
+
+// TestHandleRouterLogOrphaned logs of blocks which differ from the scanned canonical ones are ignored
+func TestHandleRouterLogOrphaned(t *testing.T) {
+	client := newStubClient()
+	parent := client.addHeader(4, common.Hash{}, 0)
+	canonical := client.addHeader(5, parent, 0)
+	scanner := newLogScanner(t, client)
+	header, _ := client.HeaderByNumber(scanner.ctx, nil)
+	scanner.headers.add(header)
+
+	orphaned := testRouterLog(5, common.Hash{1}, 1, 0)
+	removed := testRouterLog(5, canonical, 2, 0)
+	removed.Removed = true
+	good := testRouterLog(5, canonical, 3, 0)
+	unknown := testRouterLog(6, common.Hash{6}, 4, 0) // not scanned yet
+	for _, l := range []types.Log{orphaned, removed, good, unknown} {
+		client.addLog(l)
+		scanner.handleRouterLog(prefixSwapRouter, &l)
+	}
+	if n := len(scanner.posts.swaps); n != 2 {
+		t.Fatalf("queued %v swaps, want 2", n)
+	}
+	if swap := <-scanner.posts.swaps; swap.txid != good.TxHash.Hex() || swap.blockHash != canonical.Hex() {
+		t.Fatalf("wrong queued swap %+v", swap)
+	}
+}
+
+// TestHandleRouterLogReceiptError the swap is not queued with a wrong log index if receipt is not got
+func TestHandleRouterLogReceiptError(t *testing.T) {
+	client := newStubClient()
+	first, second := testRouterLog(5, common.Hash{5}, 1, 7), testRouterLog(5, common.Hash{5}, 1, 8)
+	client.addLog(first)
+	client.addLog(second)
+	scanner := newLogScanner(t, client)
+
+	errReceipt := errors.New("receipt is not available")
+	client.setError("eth_getTransactionReceipt", errReceipt)
+	if err := scanner.handleRouterLog(prefixSwapRouter, &second); !errors.Is(err, errReceipt) {
+		t.Fatalf("handle router log got error %v, want %v", err, errReceipt)
+	}
+	if n := len(scanner.posts.swaps); n != 0 {
+		t.Fatalf("queued %v swaps without receipt", n)
+	}
+
+	client.setError("eth_getTransactionReceipt", nil)
+	if err := scanner.handleRouterLog(prefixSwapRouter, &second); err != nil {
+		t.Fatal(err)
+	}
+	if swap := <-scanner.posts.swaps; swap.logIndex != "1" {
+		t.Fatalf("queued swap of log index %v, want 1", swap.logIndex)
+	}
+}
+
+// TestScanLogsRangeJobCheckpoint the logs range job is checkpointed,
+// and the checkpoint is not beyond the swaps which are not posted yet
+func TestScanLogsRangeJobCheckpoint(t *testing.T) {
+	client := newStubClient()
+	client.addLog(testRouterLog(5, common.Hash{5}, 1, 0))
+	scanner := newLogScanner(t, client)
+	scanner.store = newTestRangeStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel) // stop flushing checkpoints
+	scanner.ctx = ctx
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	scanner.doScanLogsRangeJob(1, 15, wg)
+	saved, err := scanner.store.FindRangeJobs(logsRangeJobChain("eth"))
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("find logs range jobs got %v jobs, err %v", len(saved), err)
+	}
+	if job := saved[0]; job.Cursor != 5 || job.Finished {
+		t.Fatalf("checkpoint cursor %v finished %v, want 5 false", job.Cursor, job.Finished)
+	}
+	if saved, _ = scanner.store.FindRangeJobs("eth"); len(saved) != 0 {
+		t.Fatalf("logs range job is saved as block range job %v", saved)
+	}
+
+	jobs := newRangeJobs(logsRangeJobChain("eth"), 1, 15, 1, scanner.store, scanner.posts.lowestHeightFrom)
+	if h, _, ok := jobs.next(jobs.take()); !ok || h != 5 {
+		t.Fatalf("resumed at height %v %v, want 5", h, ok)
+	}
+}
+
+// TestScanLoopLogsOnce the logs of every block are scanned once in scan loop,
+// though the recent blocks below the stable height are scanned again
+func TestScanLoopLogsOnce(t *testing.T) {
+	client := newStubClient()
+	hashes := newStubChain(client, 5, 0)
+	client.addLog(testRouterLog(3, hashes[3], 1, 0))
+	scanner := newLogScanner(t, client)
+	scanner.stableHeight = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scanner.ctx = ctx
+
+	stopped := make(chan struct{})
+	go func() {
+		scanner.scanLoop(1)
+		close(stopped)
+	}()
+	waitHeader := func(height uint64) {
+		for i := 0; scanner.headers.get(height) == nil; i++ {
+			if i == 500 {
+				t.Fatalf("block %v is not scanned", height)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitHeader(5)
+	hashes = append(hashes, client.addHeader(6, hashes[5], 0))
+	client.addLog(testRouterLog(6, hashes[6], 2, 0))
+	waitHeader(6)
+	client.addHeader(7, hashes[6], 0)
+	waitHeader(7) // the logs of the second pass are handled
+	cancel()
+	<-stopped
+
+	posts := make(map[string]int)
+	for len(scanner.posts.swaps) > 0 {
+		posts[(<-scanner.posts.swaps).txid]++
+	}
+	for _, tx := range []byte{1, 2} {
+		if n := posts[common.BytesToHash([]byte{tx}).Hex()]; n != 1 {
+			t.Errorf("swap of tx %v is queued %v times, want 1", tx, n)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+var errNATSClosed = errors.New("nats sink is closed")
+
+// natsSink publish swap json to nats subject with the nats text protocol.
+// Every publish is followed by a PING, and succeeds only when the server replies PONG,
+// so the published message has been processed by the server (not a persistent ack).
+// The connection is redialed on the next publish after errors.
+// TLS is used with 'tls://' url, or if the server requires it.
+type natsSink struct {
+	addr      string
+	subject   string
+	timeout   time.Duration
+	user      string
+	pass      string
+	token     string
+	useTLS    bool
+	tlsConfig *tls.Config
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	closed bool
+}
+
+type natsConnectOptions struct {
+	Verbose     bool   `json:"verbose"`
+	Pedantic    bool   `json:"pedantic"`
+	TLSRequired bool   `json:"tls_required"`
+	Name        string `json:"name"`
+	Lang        string `json:"lang"`
+	Version     string `json:"version"`
+	User        string `json:"user,omitempty"`
+	Pass        string `json:"pass,omitempty"`
+	Token       string `json:"auth_token,omitempty"`
+}
+
+type natsServerInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+func newNATSSink(rawURL, subject string, timeout time.Duration) (*natsSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	s := &natsSink{
+		addr:      addr,
+		subject:   subject,
+		timeout:   timeout,
+		useTLS:    u.Scheme == "tls",
+		tlsConfig: &tls.Config{ServerName: u.Hostname()},
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			s.user, s.pass = u.User.Username(), pass
+		} else {
+			s.token = u.User.Username()
+		}
+	}
+	// connect at startup to find config errors early,
+	// swaps are kept in outbox and retried if the server is not available
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err = s.connect(); err != nil {
+		log.Warn("connect nats server failed", "addr", s.addr, "err", err)
+	}
+	return s, nil
+}
+
+// Post implements Sink
+func (s *natsSink) Post(swap *Swap) (string, error) {
+	payload, err := marshalSinkSwap(swap)
+	if err != nil {
+		return params.PostPermanent, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return params.PostTransient, errNATSClosed
+	}
+	if s.conn == nil {
+		if err = s.connect(); err != nil {
+			log.Warn("connect nats server failed", "addr", s.addr, "err", err)
+			return params.PostTransient, err
+		}
+	}
+	if err = s.publish(payload); err != nil {
+		log.Warn("publish swap to nats failed", "addr", s.addr, "subject", s.subject, "txid", swap.TxID, "err", err)
+		s.disconnect()
+		return params.PostTransient, err
+	}
+	log.Info("publish swap to nats success", "subject", s.subject, "txid", swap.TxID, "logIndex", swap.LogIndex)
+	return params.PostSuccess, nil
+}
+
+// Close implements io.Closer
+func (s *natsSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	s.disconnect()
+	return nil
+}
+
+func (s *natsSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(s.timeout))
+
+	var info natsServerInfo
+	line, err := s.readLine()
+	if err == nil {
+		if !strings.HasPrefix(line, "INFO ") {
+			err = fmt.Errorf("unexpected nats greeting '%v'", line)
+		} else if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+			err = fmt.Errorf("wrong nats server info: %w", err)
+		}
+	}
+	useTLS := s.useTLS || info.TLSRequired
+	if err == nil && useTLS {
+		err = s.upgradeTLS()
+	}
+	if err == nil {
+		opts, _ := json.Marshal(&natsConnectOptions{
+			TLSRequired: useTLS,
+			Name:        "gethscan",
+			Lang:        "go",
+			Version:     params.Version,
+			User:        s.user,
+			Pass:        s.pass,
+			Token:       s.token,
+		})
+		_, err = fmt.Fprintf(s.conn, "CONNECT %s\r\nPING\r\n", opts)
+	}
+	if err == nil {
+		err = s.waitPong()
+	}
+	if err != nil {
+		s.disconnect()
+		return err
+	}
+	log.Info("connect nats server success", "addr", s.addr, "tls", useTLS)
+	return nil
+}
+
+// upgradeTLS start tls handshake after the server info is received
+func (s *natsSink) upgradeTLS() error {
+	conn := tls.Client(s.conn, s.tlsConfig)
+	_ = conn.SetDeadline(time.Now().Add(s.timeout))
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("nats tls handshake failed: %w", err)
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+	return nil
+}
+
+func (s *natsSink) publish(payload []byte) error {
+	_ = s.conn.SetDeadline(time.Now().Add(s.timeout))
+	msg := make([]byte, 0, len(payload)+len(s.subject)+32)
+	msg = append(msg, fmt.Sprintf("PUB %s %d\r\n", s.subject, len(payload))...)
+	msg = append(msg, payload...)
+	msg = append(msg, "\r\nPING\r\n"...)
+	if _, err := s.conn.Write(msg); err != nil {
+		return err
+	}
+	return s.waitPong()
+}
+
+// waitPong wait for PONG, answer server PINGs and skip INFO and +OK
+func (s *natsSink) waitPong() error {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err = s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats server error: %v", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (s *natsSink) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (s *natsSink) disconnect() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		s.reader = nil
+	}
+}
@@ -0,0 +1,213 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+type natsTestMsg struct {
+	subject string
+	payload []byte
+}
+
+// natsTestServer stand-in nats server speaking the text protocol
+type natsTestServer struct {
+	ln        net.Listener
+	tlsConfig *tls.Config // tls is required if not nil
+	connects  chan *natsConnectOptions
+	msgs      chan *natsTestMsg
+
+	dropPubs int32 // close connection without PONG when receiving PUB
+	authErr  bool  // reply -ERR to CONNECT
+}
+
+func newNATSTestServer(t *testing.T, tlsConfig *tls.Config) *natsTestServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsTestServer{
+		ln:        ln,
+		tlsConfig: tlsConfig,
+		connects:  make(chan *natsConnectOptions, 10),
+		msgs:      make(chan *natsTestMsg, 10),
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsTestServer) url(scheme string) string {
+	return fmt.Sprintf("%v://user:pass@%v", scheme, s.ln.Addr())
+}
+
+func (s *natsTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"tls_required\":%v}\r\n", s.tlsConfig != nil)
+	if s.tlsConfig != nil {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			opts := &natsConnectOptions{}
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), opts)
+			s.connects <- opts
+			if s.authErr {
+				fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case line == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "PUB "):
+			fields := strings.Fields(line)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			if atomic.AddInt32(&s.dropPubs, -1) >= 0 {
+				return
+			}
+			s.msgs <- &natsTestMsg{subject: fields[1], payload: payload[:size]}
+		}
+	}
+}
+
+func newTestSinkSwap() *Swap {
+	return &Swap{
+		TxID:       "0x1",
+		RPCMethod:  "swap.RegisterRouterSwap",
+		SwapServer: "http://127.0.0.1:1",
+		ChainID:    "1",
+		LogIndex:   "2",
+		Outcome:    params.PostTransient,
+		PostError:  "timeout",
+		Sinks:      []string{"nats", "webhook"},
+		Delivered:  []string{"webhook"},
+	}
+}
+
+// checkSinkPayload the internal posting states are not delivered to sinks
+func checkSinkPayload(t *testing.T, payload []byte) {
+	t.Helper()
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		t.Fatalf("wrong swap json %s: %v", payload, err)
+	}
+	for _, field := range []string{"outcome", "postError", "sinks", "delivered"} {
+		if _, exist := fields[field]; exist {
+			t.Errorf("internal field %v is delivered in %s", field, payload)
+		}
+	}
+	if fields["txid"] != "0x1" || fields["logIndex"] != "2" {
+		t.Errorf("wrong swap json %s", payload)
+	}
+}
+
+func TestNATSSinkPublish(t *testing.T) {
+	server := newNATSTestServer(t, nil)
+	sink, err := newNATSSink(server.url("nats"), "swaps", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if opts := <-server.connects; opts.User != "user" || opts.Pass != "pass" || opts.TLSRequired {
+		t.Fatalf("wrong connect options %+v", opts)
+	}
+	if outcome, err := sink.Post(newTestSinkSwap()); outcome != params.PostSuccess || err != nil {
+		t.Fatalf("publish got outcome %v, err %v", outcome, err)
+	}
+	msg := <-server.msgs
+	if msg.subject != "swaps" {
+		t.Fatalf("published to subject %v", msg.subject)
+	}
+	checkSinkPayload(t, msg.payload)
+
+	_ = sink.Close()
+	if outcome, err := sink.Post(newTestSinkSwap()); outcome != params.PostTransient || err != errNATSClosed {
+		t.Fatalf("publish to closed sink got outcome %v, err %v", outcome, err)
+	}
+}
+
+// TestNATSSinkRedial the message not acked by PONG is transient, and the sink redials on next publish
+func TestNATSSinkRedial(t *testing.T) {
+	server := newNATSTestServer(t, nil)
+	server.dropPubs = 1
+	sink, _ := newNATSSink(server.url("nats"), "swaps", time.Second)
+	defer sink.Close()
+	if outcome, err := sink.Post(newTestSinkSwap()); outcome != params.PostTransient || err == nil {
+		t.Fatalf("publish without PONG got outcome %v, err %v", outcome, err)
+	}
+	if outcome, err := sink.Post(newTestSinkSwap()); outcome != params.PostSuccess || err != nil {
+		t.Fatalf("publish after redial got outcome %v, err %v", outcome, err)
+	}
+	if n := len(server.connects); n != 2 {
+		t.Fatalf("connected %v times, want 2", n)
+	}
+}
+
+func TestNATSSinkAuthError(t *testing.T) {
+	server := newNATSTestServer(t, nil)
+	server.authErr = true
+	sink, _ := newNATSSink(server.url("nats"), "swaps", time.Second)
+	defer sink.Close()
+	outcome, err := sink.Post(newTestSinkSwap())
+	if outcome != params.PostTransient || err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Fatalf("publish with wrong auth got outcome %v, err %v", outcome, err)
+	}
+}
+
+func TestNATSSinkTLS(t *testing.T) {
+	// borrow the self signed certificate of httptest
+	https := httptest.NewTLSServer(http.NotFoundHandler())
+	cert := https.TLS.Certificates[0]
+	pool := x509.NewCertPool()
+	pool.AddCert(https.Certificate())
+	https.Close()
+
+	server := newNATSTestServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	for _, scheme := range []string{"nats", "tls"} { // tls is required by server info
+		sink, err := newNATSSink(server.url(scheme), "swaps", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.tlsConfig.RootCAs = pool
+		if outcome, err := sink.Post(newTestSinkSwap()); outcome != params.PostSuccess || err != nil {
+			t.Fatalf("publish with %v url got outcome %v, err %v", scheme, outcome, err)
+		}
+		if _, ok := sink.conn.(*tls.Conn); !ok {
+			t.Fatalf("connection with %v url is not tls", scheme)
+		}
+		checkSinkPayload(t, (<-server.msgs).payload)
+		_ = sink.Close()
+	}
+}
@@ -0,0 +1,150 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+
+	"github.com/weijun-sh/gethscan/metrics"
+)
+
+const (
+	newHeadsPollInterval        = 1 * time.Second  // polling interval if not subscribed
+	newHeadsResubscribeInterval = 30 * time.Second // retry interval after the subscription drops
+	newHeadsStallTimeout        = 60 * time.Second // poll latest height if no new head for a long time
+)
+
+var errNoHeadSubscriber = errors.New("no gateway supports subscribing new heads")
+
+// HeadSubscriber subscribe new heads, it's implemented by *ethclient.Client of websocket gateways.
+// It's optional, and is used to scan new blocks without polling.
+type HeadSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// headWatcher keep the latest height by newHeads subscription
+type headWatcher struct {
+	scanner    *ethSwapScanner
+	latest     uint64
+	subscribed int32
+	notify     chan struct{} // new head arrived or subscription dropped
+}
+
+// startHeadWatcher subscribe newHeads if enabled, returns nil if not enabled
+func (scanner *ethSwapScanner) startHeadWatcher() *headWatcher {
+	if !scanner.chainCfg.BlockChain.SubscribeNewHeads {
+		return nil
+	}
+	w := &headWatcher{
+		scanner: scanner,
+		notify:  make(chan struct{}, 1),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *headWatcher) loop() {
+	scanner := w.scanner
+	for {
+		ch := make(chan *types.Header, 16)
+		sub, err := w.subscribe(ch)
+		if err != nil {
+			log.Warn("subscribe newHeads failed, scan by polling", "chain", scanner.chain, "retry", newHeadsResubscribeInterval, "err", err)
+		} else {
+			atomic.StoreInt32(&w.subscribed, 1)
+			err = w.consume(sub, ch)
+			atomic.StoreInt32(&w.subscribed, 0)
+			w.wakeup()
+			sub.Unsubscribe()
+			if scanner.isStopping() {
+				return
+			}
+			log.Warn("newHeads subscription dropped, scan by polling", "chain", scanner.chain, "retry", newHeadsResubscribeInterval, "err", err)
+		}
+		if !scanner.sleep(newHeadsResubscribeInterval) {
+			return
+		}
+	}
+}
+
+// subscribe newHeads with a websocket gateway, the long-lived subscription
+// is not recorded in the health scores which are used to choose gateways of calls
+func (w *headWatcher) subscribe(ch chan *types.Header) (ethereum.Subscription, error) {
+	gw, subscriber := w.scanner.gateways.headSubscriber()
+	if subscriber == nil {
+		return nil, errNoHeadSubscriber
+	}
+	sub, err := subscriber.SubscribeNewHead(w.scanner.ctx, ch)
+	if err != nil {
+		return nil, fmt.Errorf("gateway %v: %w", gw.url, err)
+	}
+	log.Info("subscribe newHeads with gateway", "chain", w.scanner.chain, "gateway", gw.url)
+	return sub, nil
+}
+
+// consume receive new heads until the subscription drops or the scanner is stopping
+func (w *headWatcher) consume(sub ethereum.Subscription, ch chan *types.Header) error {
+	scanner := w.scanner
+	for {
+		select {
+		case header := <-ch:
+			if header == nil || header.Number == nil {
+				continue
+			}
+			height := header.Number.Uint64()
+			log.Debug("receive new head", "chain", scanner.chain, "height", height, "hash", header.Hash().Hex())
+			metrics.LatestHeight.WithLabelValues(scanner.chain).Set(float64(height))
+			atomic.StoreUint64(&w.latest, height)
+			w.wakeup()
+		case err := <-sub.Err():
+			return err
+		case <-scanner.ctx.Done():
+			return scanner.ctx.Err()
+		}
+	}
+}
+
+func (w *headWatcher) wakeup() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// waitLatestBlockNumber wait for the next new head and return its height,
+// or poll the latest height if newHeads is not subscribed. Returns 0 if stopping.
+func (scanner *ethSwapScanner) waitLatestBlockNumber(w *headWatcher) uint64 {
+	if w == nil {
+		if !scanner.sleep(newHeadsPollInterval) {
+			return 0
+		}
+		return scanner.loopGetLatestBlockNumber()
+	}
+	timer := time.NewTimer(newHeadsStallTimeout)
+	defer timer.Stop()
+	for {
+		if atomic.LoadInt32(&w.subscribed) == 0 {
+			if !scanner.sleep(newHeadsPollInterval) {
+				return 0
+			}
+			return scanner.loopGetLatestBlockNumber()
+		}
+		select {
+		case <-w.notify:
+			if latest := atomic.LoadUint64(&w.latest); latest != 0 && atomic.LoadInt32(&w.subscribed) == 1 {
+				return latest
+			}
+		case <-timer.C:
+			log.Warn("no new head received, poll latest height", "chain", scanner.chain, "timeout", newHeadsStallTimeout)
+			return scanner.loopGetLatestBlockNumber()
+		case <-scanner.ctx.Done():
+			return 0
+		}
+	}
+}
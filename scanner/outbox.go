@@ -0,0 +1,149 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/storage"
+	"github.com/weijun-sh/gethscan/tools"
+)
+
+const (
+	defaultOutboxMaxAttempts      = 100
+	defaultOutboxRetryInterval    = 10   // seconds
+	defaultOutboxMaxRetryInterval = 3600 // seconds
+)
+
+var errRepostSwapFailed = errors.New("repost swap failed")
+
+// MarshalJSON json marshal, swap post is stored in outbox with the json format of Swap
+func (swap *swapPost) MarshalJSON() ([]byte, error) {
+	return json.Marshal(swap.toSwap())
+}
+
+// UnmarshalJSON json unmarshal
+func (swap *swapPost) UnmarshalJSON(data []byte) error {
+	var sp Swap
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return err
+	}
+	*swap = *newSwapPostFromSwap(&sp)
+	return nil
+}
+
+// key unique key of swap post
+func (swap *swapPost) key() string {
+	return storage.SwapKey(swap.swapServer, swap.txid, swap.pairID, swap.logIndex)
+}
+
+func (scanner *ethSwapScanner) initOutbox() {
+	cfg := params.GetOutboxConfig()
+	file := cfg.File
+	if file == "" {
+		file = fmt.Sprintf("outbox-%v.log", scanner.chain)
+	} else if params.IsMultiChain() {
+		// chains can not share the outbox file
+		ext := filepath.Ext(file)
+		file = fmt.Sprintf("%v-%v%v", strings.TrimSuffix(file, ext), scanner.chain, ext)
+	}
+	if err := scanner.openOutbox(file); err != nil {
+		log.Fatal("open outbox failed", "file", file, "err", err)
+	}
+}
+
+// openOutbox open outbox file with the retry settings of config
+func (scanner *ethSwapScanner) openOutbox(file string) error {
+	cfg := params.GetOutboxConfig()
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultOutboxMaxAttempts
+	}
+	retryInterval := cfg.RetryInterval
+	if retryInterval == 0 {
+		retryInterval = defaultOutboxRetryInterval
+	}
+	maxRetryInterval := cfg.MaxRetryInterval
+	if maxRetryInterval == 0 {
+		maxRetryInterval = defaultOutboxMaxRetryInterval
+	}
+	outbox, err := tools.OpenOutbox(file, maxAttempts,
+		time.Duration(retryInterval)*time.Second,
+		time.Duration(maxRetryInterval)*time.Second)
+	if err != nil {
+		return err
+	}
+	log.Info("open outbox success", "file", file, "pending", outbox.Len(), "dead", len(outbox.DeadLetters()))
+	scanner.outbox = outbox
+	return nil
+}
+
+func (scanner *ethSwapScanner) addOutboxSwap(swap *swapPost) {
+	if scanner.outbox == nil {
+		log.Warn("drop swap as outbox is disabled", "swap", swap)
+		return
+	}
+	err := scanner.outbox.Add(swap.key(), swap)
+	if err != nil {
+		log.Error("add swap to outbox failed", "swap", swap, "err", err)
+	}
+}
+
+// removeOutboxSwaps remove swaps in outbox which match the filter
+func (scanner *ethSwapScanner) removeOutboxSwaps(filter func(*swapPost) bool) {
+	if scanner.outbox == nil {
+		return
+	}
+	scanner.outbox.Remove(func(item *tools.OutboxItem) bool {
+		swap := &swapPost{}
+		if err := json.Unmarshal(item.Payload, swap); err != nil {
+			return false
+		}
+		return filter(swap)
+	})
+}
+
+func (scanner *ethSwapScanner) repostOutboxSwap(item *tools.OutboxItem) error {
+	swap := &swapPost{}
+	if err := json.Unmarshal(item.Payload, swap); err != nil {
+		log.Error("unmarshal outbox swap failed", "id", item.ID, "err", err)
+		return nil // drop it
+	}
+	ok, err := scanner.repostSwap(swap)
+	if ok {
+		log.Info("repost outbox swap success", "swap", swap, "outcome", swap.outcome, "attempts", item.Attempts+1)
+		// failed attempts are not recorded, the swap record is pending to be retried until success
+		scanner.transitSwap(swap, storage.SwapPosting, "")
+		scanner.transitSwap(swap, swapState(swap.outcome), "")
+		return nil
+	}
+	if isPostShortCircuited(err) {
+		return fmt.Errorf("%w: %v", tools.ErrOutboxSkip, err) // not an attempt
+	}
+	if swap.outcome == params.PostPermanent {
+		log.Warn("repost outbox swap failed permanently", "swap", swap, "err", swap.postErr)
+		scanner.transitSwap(swap, storage.SwapPosting, "")
+		scanner.transitSwap(swap, storage.SwapRejected, "")
+		return fmt.Errorf("%w: %v %v", tools.ErrOutboxDead, swap.outcome, swap.postErr)
+	}
+	if scanner.outbox.IsLastAttempt(item) {
+		log.Warn("repost outbox swap reached max attempts", "swap", swap, "attempts", item.Attempts+1, "err", swap.postErr)
+		scanner.transitSwap(swap, storage.SwapDropped, outboxDeadReason)
+	}
+	if swap.postErr != "" {
+		return fmt.Errorf("%w: %v %v", errRepostSwapFailed, swap.outcome, swap.postErr)
+	}
+	return errRepostSwapFailed
+}
+
+// removeOutboxSwap remove swap from outbox after it's reposted with the pending swap record
+func (scanner *ethSwapScanner) removeOutboxSwap(swap *swapPost) {
+	key := swap.key()
+	scanner.removeOutboxSwaps(func(sp *swapPost) bool { return sp.key() == key })
+}
@@ -0,0 +1,113 @@
+package scanner
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/storage"
+	"github.com/weijun-sh/gethscan/tools"
+)
+
+// outcomeSink reply the outcomes of swaps by txid
+type outcomeSink map[string]string
+
+func (s outcomeSink) Post(swap *Swap) (string, error) {
+	outcome := s[swap.TxID]
+	if outcome == params.PostSuccess {
+		return outcome, nil
+	}
+	return outcome, errors.New(outcome + " error")
+}
+
+// TestRepostOutboxSwap permanent failures are moved to dead letters at once
+func TestRepostOutboxSwap(t *testing.T) {
+	scanner := newTestScanner(t)
+	scanner.rpcRetryCount = 1
+	scanner.sink = outcomeSink{
+		"0x1": params.PostSuccess,
+		"0x2": params.PostPermanent,
+		"0x3": params.PostTransient,
+	}
+	if err := scanner.openOutbox(filepath.Join(t.TempDir(), "outbox.log")); err != nil {
+		t.Fatal(err)
+	}
+	defer scanner.outbox.Close()
+	for _, txid := range []string{"0x1", "0x2", "0x3"} {
+		scanner.cacheSwap(newTestSwapPost(txid, "1"))
+	}
+	scanner.outbox.Do(scanner.repostOutboxSwap)
+
+	dead := scanner.outbox.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != newTestSwapPost("0x2", "1").key() || dead[0].Attempts != 1 {
+		t.Fatalf("wrong dead letters %+v", dead)
+	}
+	items := scanner.outbox.Items()
+	if len(items) != 2 {
+		t.Fatalf("%v items left in outbox, want 2", len(items))
+	}
+	for _, item := range items {
+		if item.ID == newTestSwapPost("0x3", "1").key() && item.State != tools.OutboxPending {
+			t.Errorf("transient swap has state %v", item.State)
+		}
+	}
+	for txid, state := range map[string]string{
+		"0x1": storage.SwapRegistered,
+		"0x2": storage.SwapRejected,
+		"0x3": storage.SwapPendingRetry,
+	} {
+		swap, err := scanner.store.FindSwap(newTestSwapPost(txid, "1").key())
+		if err != nil || swap.State != state {
+			t.Errorf("swap %v has state %+v, want %v, err %v", txid, swap, state, err)
+		}
+	}
+}
+
+// TestOutboxDeadDropsSwap the swap record is dropped when the outbox gives up reposting it
+func TestOutboxDeadDropsSwap(t *testing.T) {
+	scanner := newTestScanner(t)
+	scanner.rpcRetryCount = 1
+	scanner.sink = outcomeSink{"0x3": params.PostTransient}
+	outbox, err := tools.OpenOutbox(filepath.Join(t.TempDir(), "outbox.log"), 1, time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+	scanner.outbox = outbox
+	swap := newTestSwapPost("0x3", "1")
+	scanner.cacheSwap(swap)
+	scanner.outbox.Do(scanner.repostOutboxSwap)
+
+	if dead := scanner.outbox.DeadLetters(); len(dead) != 1 {
+		t.Fatalf("%v dead letters, want 1", len(dead))
+	}
+	record, err := scanner.store.FindSwap(swap.key())
+	if err != nil || record.State != storage.SwapDropped || record.Reason != outboxDeadReason {
+		t.Fatalf("dead swap has record %+v, err %v", record, err)
+	}
+}
+
+// TestRetrySwapPendingRemovesOutbox the swap reposted with pending record is removed from outbox
+func TestRetrySwapPendingRemovesOutbox(t *testing.T) {
+	scanner := newTestScanner(t)
+	scanner.rpcRetryCount = 1
+	scanner.sink = outcomeSink{"0x1": params.PostSuccess}
+	if err := scanner.openOutbox(filepath.Join(t.TempDir(), "outbox.log")); err != nil {
+		t.Fatal(err)
+	}
+	defer scanner.outbox.Close()
+	swap := newTestSwapPost("0x1", "1")
+	scanner.cacheSwap(swap)
+	record, err := scanner.store.FindSwap(swap.key())
+	if err != nil || record.State != storage.SwapPendingRetry {
+		t.Fatalf("cached swap has record %+v, err %v", record, err)
+	}
+	if !scanner.retrySwapPending(record) {
+		t.Fatal("retry pending swap failed")
+	}
+	if n := scanner.outbox.Len(); n != 0 {
+		t.Fatalf("%v swaps are left in outbox after reposted", n)
+	}
+}
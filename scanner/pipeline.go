@@ -0,0 +1,172 @@
+package scanner
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/metrics"
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+const (
+	defaultPostWorkers   = 8
+	defaultPostQueueSize = 1000
+)
+
+// postQueue bounded queue of detected swaps which are posted by a worker pool,
+// so that slow swap servers do not stall block scanning.
+type postQueue struct {
+	swaps   chan *swapPost
+	workers int
+	stop    chan struct{}
+	wg      sync.WaitGroup // running workers
+
+	// queued and posting swaps of every block height,
+	// the synced block number is not persisted beyond them
+	lock    sync.Mutex
+	heights map[uint64]int
+}
+
+func newPostQueue(cfg *params.PostQueueConfig) *postQueue {
+	workers, size := defaultPostWorkers, defaultPostQueueSize
+	if cfg != nil && cfg.Workers > 0 {
+		workers = cfg.Workers
+	}
+	if cfg != nil && cfg.Size > 0 {
+		size = cfg.Size
+	}
+	return &postQueue{
+		swaps:   make(chan *swapPost, size),
+		workers: workers,
+		stop:    make(chan struct{}),
+		heights: make(map[uint64]int),
+	}
+}
+
+func (q *postQueue) addHeight(height uint64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.heights[height]++
+}
+
+func (q *postQueue) removeHeight(height uint64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.heights[height] <= 1 {
+		delete(q.heights, height)
+	} else {
+		q.heights[height]--
+	}
+}
+
+// lowestHeight lowest block height of the queued and posting swaps
+func (q *postQueue) lowestHeight() (lowest uint64, exist bool) {
+	return q.lowestHeightFrom(0)
+}
+
+// lowestHeightFrom lowest block height of the queued and posting swaps not before from
+func (q *postQueue) lowestHeightFrom(from uint64) (lowest uint64, exist bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for height := range q.heights {
+		if height >= from && (!exist || height < lowest) {
+			lowest, exist = height, true
+		}
+	}
+	return lowest, exist
+}
+
+// startPostWorkers start the workers of post queue
+func (scanner *ethSwapScanner) startPostWorkers() {
+	log.Info("start post workers", "chain", scanner.chain, "workers", scanner.posts.workers, "queue", cap(scanner.posts.swaps))
+	for i := 0; i < scanner.posts.workers; i++ {
+		scanner.posts.wg.Add(1)
+		go scanner.postWorker()
+	}
+}
+
+func (scanner *ethSwapScanner) postWorker() {
+	q := scanner.posts
+	defer q.wg.Done()
+	for {
+		select {
+		case swap := <-q.swaps:
+			select {
+			case <-q.stop: // select may pick the swap after stopped, leave it to be cached
+				scanner.cacheSwap(swap)
+				scanner.finishQueuedSwap(swap)
+				return
+			default:
+			}
+			metrics.PostQueueDepth.WithLabelValues(scanner.chain).Set(float64(len(q.swaps)))
+			scanner.postSwapPost(swap)
+			scanner.finishQueuedSwap(swap)
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// enqueueSwap queue swap to be posted, or hold it until its block is confirmed if confirm policy is set.
+// The swap is recorded as detected before queued, so swaps of orphaned blocks can be posted again.
+func (scanner *ethSwapScanner) enqueueSwap(swap *swapPost) {
+	if scanner.confirmPolicy != "" {
+		scanner.holdSwap(swap)
+		return
+	}
+	scanner.transitSwap(swap, storage.SwapDetected, "")
+	scanner.queueSwap(swap)
+}
+
+// queueSwap queue swap to be posted, wait if the queue is full.
+// The swap is cached to be reposted later if the scanner is stopping.
+func (scanner *ethSwapScanner) queueSwap(swap *swapPost) {
+	q := scanner.posts
+	scanner.beginPost() // queued swaps are waited when shutdown
+	q.addHeight(swap.blockNumber)
+	if !scanner.isStopping() {
+		select {
+		case q.swaps <- swap:
+			metrics.PostQueueDepth.WithLabelValues(scanner.chain).Set(float64(len(q.swaps)))
+			return
+		case <-scanner.ctx.Done():
+		}
+	}
+	scanner.cacheSwap(swap)
+	scanner.finishQueuedSwap(swap)
+}
+
+func (scanner *ethSwapScanner) finishQueuedSwap(swap *swapPost) {
+	scanner.posts.removeHeight(swap.blockNumber)
+	scanner.endPost()
+}
+
+// stopPostWorkers stop the workers and wait for them to exit, then cache the swaps left in queue
+func (scanner *ethSwapScanner) stopPostWorkers() {
+	q := scanner.posts
+	close(q.stop)
+	q.wg.Wait()
+	for {
+		select {
+		case swap := <-q.swaps:
+			scanner.cacheSwap(swap)
+			scanner.finishQueuedSwap(swap)
+		default:
+			metrics.PostQueueDepth.WithLabelValues(scanner.chain).Set(0)
+			return
+		}
+	}
+}
+
+// syncedNumberToSave the synced block number which can be persisted,
+// it's before the lowest block of the swaps which are not posted yet.
+func (scanner *ethSwapScanner) syncedNumberToSave() uint64 {
+	number := atomic.LoadUint64(&scanner.syncedNumber)
+	if lowest, exist := scanner.posts.lowestHeight(); exist && lowest <= number && lowest > 0 {
+		number = lowest - 1
+	}
+	return number
+}
@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+// blockingSink blocks posting until released
+type blockingSink struct {
+	posting  chan string
+	release  chan struct{}
+	posted   int32
+	finished int32
+}
+
+func (s *blockingSink) Post(swap *Swap) (string, error) {
+	s.posting <- swap.TxID
+	<-s.release
+	atomic.AddInt32(&s.posted, 1)
+	atomic.StoreInt32(&s.finished, 1)
+	return params.PostSuccess, nil
+}
+
+// TestStopPostWorkers no swap is posted after stopped, the in-flight post is waited,
+// and the queued swaps are cached
+func TestStopPostWorkers(t *testing.T) {
+	scanner := newTestScanner(t)
+	scanner.posts = newPostQueue(&params.PostQueueConfig{Workers: 1})
+	scanner.rpcRetryCount = 1
+	sink := &blockingSink{posting: make(chan string, 2), release: make(chan struct{})}
+	scanner.sink = sink
+	scanner.startPostWorkers()
+
+	first, second := newTestSwapPost("0x1", "1"), newTestSwapPost("0x2", "1")
+	scanner.queueSwap(first)
+	if txid := <-sink.posting; txid != "0x1" {
+		t.Fatalf("posting %v, want 0x1", txid)
+	}
+	scanner.queueSwap(second)
+
+	stopped := make(chan struct{})
+	go func() {
+		scanner.stopPostWorkers()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stopped before the in-flight post is finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(sink.release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop post workers timeout")
+	}
+	if atomic.LoadInt32(&sink.finished) != 1 || atomic.LoadInt32(&sink.posted) != 1 {
+		t.Fatalf("posted %v swaps, want 1", atomic.LoadInt32(&sink.posted))
+	}
+	if _, exist := scanner.posts.lowestHeight(); exist {
+		t.Error("queued swaps are not finished")
+	}
+	swap, err := scanner.store.FindSwap(second.key())
+	if err != nil || swap.State != storage.SwapPendingRetry {
+		t.Fatalf("queued swap %+v is not cached, err %v", swap, err)
+	}
+	if swap, _ = scanner.store.FindSwap(first.key()); swap.State != storage.SwapRegistered {
+		t.Fatalf("posted swap has state %v", swap.State)
+	}
+}
@@ -0,0 +1,362 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+const (
+	rangeJobCheckpointBlocks = 100 // persist cursor every checkpoint blocks
+	rangeJobMinSteal         = 2   // min remaining blocks of slice to be stolen
+
+	rangeJobFlushInterval = 5 * time.Second // interval of persisting checkpoints bounded by not posted swaps
+)
+
+// rangeSlice slice [from, to) of range job, blocks before cursor are scanned
+type rangeSlice struct {
+	from     uint64
+	to       uint64 // shrinks when the tail is stolen
+	cursor   uint64
+	saved    uint64 // persisted cursor, not beyond the swaps which are not posted yet
+	checked  uint64 // cursor when saved last time
+	assigned bool
+}
+
+func (s *rangeSlice) remaining() uint64 {
+	if s.cursor >= s.to {
+		return 0
+	}
+	return s.to - s.cursor
+}
+
+// rangeJobs slices of range job [start, end) shared by workers
+type rangeJobs struct {
+	lock   sync.Mutex
+	chain  string
+	start  uint64
+	end    uint64
+	slices []*rangeSlice
+	store  storage.Store // persist checkpoints, nil if disabled
+
+	// lowest block height of the swaps which are not posted yet, not before from
+	pending func(from uint64) (uint64, bool)
+}
+
+func (jobs *rangeJobs) sliceID(s *rangeSlice) string {
+	return fmt.Sprintf("%v:%v-%v:%v", jobs.chain, jobs.start, jobs.end, s.from)
+}
+
+// checkpoint the cursor which can be persisted,
+// it's not beyond the lowest block of the swaps which are not posted yet.
+func (jobs *rangeJobs) checkpoint(s *rangeSlice) uint64 {
+	cursor := s.cursor
+	if jobs.pending != nil {
+		if lowest, exist := jobs.pending(s.from); exist && lowest < cursor {
+			cursor = lowest
+		}
+	}
+	return cursor
+}
+
+// save persist slice checkpoint, should be called with lock held
+func (jobs *rangeJobs) save(s *rangeSlice) {
+	if jobs.store == nil {
+		return
+	}
+	cursor := jobs.checkpoint(s)
+	err := jobs.store.UpsertRangeJob(&storage.RangeJob{
+		Id:       jobs.sliceID(s),
+		Chain:    jobs.chain,
+		Start:    jobs.start,
+		End:      jobs.end,
+		From:     s.from,
+		To:       s.to,
+		Cursor:   cursor,
+		Finished: cursor >= s.to,
+	})
+	if err != nil {
+		log.Warn("save range job checkpoint failed", "id", jobs.sliceID(s), "cursor", cursor, "err", err)
+		return
+	}
+	s.saved = cursor
+	s.checked = s.cursor
+}
+
+// logsRangeJobChain chain of the range job scanning logs, checkpointed apart from the block scanning one
+func logsRangeJobChain(chain string) string {
+	return chain + ":logs"
+}
+
+// newRangeJobs load slices of range job from storage, or split range into count slices,
+// the checkpoints are not beyond the pending block heights of the not posted swaps.
+// The unfinished range jobs of chain are resumed, and a range job which is finished before
+// is scanned again from the beginning.
+func newRangeJobs(chain string, start, end, count uint64, store storage.Store, pending func(uint64) (uint64, bool)) *rangeJobs {
+	jobs := &rangeJobs{
+		chain:   chain,
+		start:   start,
+		end:     end,
+		store:   store,
+		pending: pending,
+	}
+	if store != nil && jobs.resume() {
+		log.Info("resume range job from checkpoints", "chain", chain, "start", start, "end", end, "slices", len(jobs.slices), "remaining", jobs.remaining())
+		return jobs
+	}
+	step := (end - start) / count
+	if step == 0 {
+		count = 1
+		step = end - start
+	}
+	for i := uint64(0); i < count; i++ {
+		s := &rangeSlice{
+			from: start + i*step,
+			to:   start + (i+1)*step,
+		}
+		if i+1 == count {
+			s.to = end
+		}
+		s.cursor = s.from
+		jobs.slices = append(jobs.slices, s)
+		jobs.save(s)
+	}
+	return jobs
+}
+
+// resume load the slices of the unfinished range jobs of chain which overlap [start, end),
+// their ranges may differ as the end is the latest block when started.
+// The slices are clipped into [start, end), the blocks not covered by them are added as new slices,
+// and all are saved as slices of [start, end) in place of the loaded range jobs.
+func (jobs *rangeJobs) resume() bool {
+	saved, err := jobs.store.FindRangeJobs(jobs.chain)
+	if err != nil {
+		log.Warn("find range job checkpoints failed, start from beginning", "chain", jobs.chain, "err", err)
+		return false
+	}
+	type span struct{ start, end uint64 }
+	finished := make(map[span]bool)
+	for _, job := range saved {
+		sp := span{job.Start, job.End}
+		if done, exist := finished[sp]; exist {
+			finished[sp] = done && job.Finished
+		} else {
+			finished[sp] = job.Finished
+		}
+	}
+	merged := make(map[span]bool)
+	var unfinished []*storage.RangeJob
+	for _, job := range saved {
+		sp := span{job.Start, job.End}
+		if finished[sp] {
+			merged[sp] = true
+			log.Info("range job is finished before, scan again", "chain", jobs.chain, "start", sp.start, "end", sp.end)
+		} else if job.From < jobs.end && job.To > jobs.start {
+			merged[sp] = true
+			unfinished = append(unfinished, job)
+		}
+	}
+	sort.Slice(unfinished, func(i, j int) bool { return unfinished[i].From < unfinished[j].From })
+
+	next := jobs.start
+	for _, job := range unfinished {
+		from, to := job.From, job.To
+		if from < next {
+			from = next
+		}
+		if to > jobs.end {
+			to = jobs.end
+		}
+		if from >= to {
+			continue
+		}
+		if from > next {
+			jobs.slices = append(jobs.slices, &rangeSlice{from: next, to: from, cursor: next})
+		}
+		cursor := job.Cursor
+		if cursor < from {
+			cursor = from
+		}
+		jobs.slices = append(jobs.slices, &rangeSlice{from: from, to: to, cursor: cursor})
+		next = to
+	}
+	if len(jobs.slices) != 0 && next < jobs.end {
+		jobs.slices = append(jobs.slices, &rangeSlice{from: next, to: jobs.end, cursor: next})
+	}
+
+	current := span{jobs.start, jobs.end}
+	if finished[current] {
+		// delete before saving the slices of new run with the same ids
+		jobs.deleteSaved(current.start, current.end)
+		delete(merged, current)
+	}
+	for _, s := range jobs.slices {
+		jobs.save(s)
+	}
+	for sp := range merged {
+		if sp != current {
+			jobs.deleteSaved(sp.start, sp.end)
+		}
+	}
+	return len(jobs.slices) != 0
+}
+
+func (jobs *rangeJobs) deleteSaved(start, end uint64) {
+	if err := jobs.store.DeleteRangeJobs(jobs.chain, start, end); err != nil {
+		log.Warn("delete range job failed", "chain", jobs.chain, "start", start, "end", end, "err", err)
+	}
+}
+
+// finish delete the checkpoints of range job if all slices are scanned and persisted
+func (jobs *rangeJobs) finish() {
+	jobs.lock.Lock()
+	defer jobs.lock.Unlock()
+	if jobs.store == nil || jobs.remaining() != 0 {
+		return
+	}
+	for _, s := range jobs.slices {
+		if s.saved != s.cursor {
+			return
+		}
+	}
+	jobs.deleteSaved(jobs.start, jobs.end)
+	log.Info("range job is finished", "chain", jobs.chain, "start", jobs.start, "end", jobs.end)
+}
+
+func (jobs *rangeJobs) remaining() (total uint64) {
+	for _, s := range jobs.slices {
+		total += s.remaining()
+	}
+	return total
+}
+
+// take get an unassigned slice, or steal the tail half of the busiest slice
+func (jobs *rangeJobs) take() *rangeSlice {
+	jobs.lock.Lock()
+	defer jobs.lock.Unlock()
+
+	var busiest *rangeSlice
+	for _, s := range jobs.slices {
+		if s.remaining() == 0 {
+			continue
+		}
+		if !s.assigned {
+			s.assigned = true
+			return s
+		}
+		if busiest == nil || s.remaining() > busiest.remaining() {
+			busiest = s
+		}
+	}
+	if busiest == nil || busiest.remaining() < rangeJobMinSteal {
+		return nil
+	}
+	// the worker of busiest slice may be scanning the cursor block, so mid > cursor
+	mid := busiest.cursor + (busiest.remaining()+1)/2
+	stolen := &rangeSlice{
+		from:     mid,
+		to:       busiest.to,
+		cursor:   mid,
+		assigned: true,
+	}
+	busiest.to = mid
+	jobs.slices = append(jobs.slices, stolen)
+	jobs.save(stolen)
+	jobs.save(busiest)
+	log.Info("steal range job slice", "from", stolen.from, "to", stolen.to, "victim", busiest.from)
+	return stolen
+}
+
+// next get the next block to scan and the current end of slice
+func (jobs *rangeJobs) next(s *rangeSlice) (height, to uint64, ok bool) {
+	jobs.lock.Lock()
+	defer jobs.lock.Unlock()
+	if s.cursor >= s.to {
+		return 0, s.to, false
+	}
+	return s.cursor, s.to, true
+}
+
+// done mark block of slice scanned, and persist the cursor every checkpoint blocks
+func (jobs *rangeJobs) done(s *rangeSlice, height uint64) {
+	jobs.lock.Lock()
+	defer jobs.lock.Unlock()
+	s.cursor = height + 1
+	if s.cursor >= s.to || s.cursor-s.checked >= rangeJobCheckpointBlocks {
+		jobs.save(s)
+	}
+}
+
+// stop persist cursor of slice when worker stops
+func (jobs *rangeJobs) stop(s *rangeSlice) {
+	jobs.lock.Lock()
+	defer jobs.lock.Unlock()
+	if s.cursor != s.saved {
+		jobs.save(s)
+	}
+}
+
+// flush persist the cursors of slices which were bounded by the not posted swaps,
+// returns true if all the cursors are persisted
+func (jobs *rangeJobs) flush() bool {
+	jobs.lock.Lock()
+	defer jobs.lock.Unlock()
+	if jobs.store == nil {
+		return true
+	}
+	flushed := true
+	for _, s := range jobs.slices {
+		if s.saved != s.cursor {
+			jobs.save(s)
+		}
+		if s.saved != s.cursor {
+			flushed = false
+		}
+	}
+	return flushed
+}
+
+// loopFlushRangeJobs persist the checkpoints of range job after the swaps in range are posted,
+// the checkpoints are deleted at last if the range job is finished
+func (scanner *ethSwapScanner) loopFlushRangeJobs(jobs *rangeJobs) {
+	for !jobs.flush() {
+		if !scanner.sleep(rangeJobFlushInterval) {
+			return
+		}
+	}
+	jobs.finish()
+}
+
+// rangeWorker scan slices of range job until all are finished
+func (scanner *ethSwapScanner) rangeWorker(job uint64, jobs *rangeJobs, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for s := jobs.take(); s != nil; s = jobs.take() {
+		log.Info(fmt.Sprintf("[%v] scan range slice", job), "from", s.from, "cursor", s.cursor, "to", s.to)
+		for {
+			h, to, ok := jobs.next(s)
+			if !ok {
+				break
+			}
+			scanner.waitIfPaused()
+			if scanner.isStopping() {
+				jobs.stop(s)
+				log.Info(fmt.Sprintf("[%v] scan range stopped", job), "from", s.from, "height", h)
+				return
+			}
+			if !scanner.loopScanBlock(job, h) {
+				// block may be partially scanned, do not mark it as done
+				jobs.stop(s)
+				return
+			}
+			jobs.done(s, h)
+			scanner.progress.update(job, s.from, to, h, false)
+		}
+		log.Info(fmt.Sprintf("[%v] scan range slice finish", job), "from", s.from)
+	}
+	scanner.progress.update(job, jobs.start, jobs.end, jobs.end, true)
+}
@@ -0,0 +1,157 @@
+package scanner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+func newTestRangeStore(t *testing.T) storage.Store {
+	store, err := storage.NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func findRangeJob(t *testing.T, store storage.Store, start, end uint64) *storage.RangeJob {
+	saved, err := store.FindRangeJobs("eth")
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("find range jobs got %v jobs, err %v", len(saved), err)
+	}
+	if job := saved[0]; job.Start != start || job.End != end {
+		t.Fatalf("range job is of [%v, %v), want [%v, %v)", job.Start, job.End, start, end)
+	}
+	return saved[0]
+}
+
+// TestRangeJobCheckpointBounded the checkpoint is not beyond the swaps which are not posted yet
+func TestRangeJobCheckpointBounded(t *testing.T) {
+	store := newTestRangeStore(t)
+	posts := newPostQueue(&params.PostQueueConfig{})
+	jobs := newRangeJobs("eth", 0, 10, 1, store, posts.lowestHeightFrom)
+	s := jobs.take()
+	for h := uint64(0); h < 10; h++ {
+		if h == 3 {
+			posts.addHeight(h) // swap queued at height 3
+		}
+		jobs.done(s, h)
+	}
+	if job := findRangeJob(t, store, 0, 10); job.Cursor != 3 || job.Finished {
+		t.Fatalf("checkpoint cursor %v finished %v, want 3 false", job.Cursor, job.Finished)
+	}
+	if jobs.flush() {
+		t.Fatal("flushed before the swap is posted")
+	}
+
+	// resumed from the bounded checkpoint
+	resumed := newRangeJobs("eth", 0, 10, 1, store, posts.lowestHeightFrom)
+	if h, _, ok := resumed.next(resumed.take()); !ok || h != 3 {
+		t.Fatalf("resumed at height %v %v, want 3", h, ok)
+	}
+
+	posts.removeHeight(3)
+	if !jobs.flush() {
+		t.Fatal("not flushed after the swap is posted")
+	}
+	if job := findRangeJob(t, store, 0, 10); job.Cursor != 10 || !job.Finished {
+		t.Fatalf("checkpoint cursor %v finished %v, want 10 true", job.Cursor, job.Finished)
+	}
+}
+
+// TestRangeJobCheckpointOtherSlices swaps of other slices do not bound the checkpoint
+func TestRangeJobCheckpointOtherSlices(t *testing.T) {
+	store := newTestRangeStore(t)
+	posts := newPostQueue(&params.PostQueueConfig{})
+	jobs := newRangeJobs("eth", 10, 20, 1, store, posts.lowestHeightFrom)
+	posts.addHeight(5)
+	posts.addHeight(30)
+	s := jobs.take()
+	for h := uint64(10); h < 20; h++ {
+		jobs.done(s, h)
+	}
+	if job := findRangeJob(t, store, 10, 20); job.Cursor != 20 || !job.Finished {
+		t.Fatalf("checkpoint cursor %v finished %v, want 20 true", job.Cursor, job.Finished)
+	}
+}
+
+// TestRangeJobRerunFinished a finished range job is scanned again from the beginning
+func TestRangeJobRerunFinished(t *testing.T) {
+	store := newTestRangeStore(t)
+	jobs := newRangeJobs("eth", 0, 10, 1, store, nil)
+	s := jobs.take()
+	jobs.done(s, 0)
+	stolen := jobs.take() // [6, 10) is stolen
+	for h := uint64(1); h < 10; h++ {
+		if h < 6 {
+			jobs.done(s, h)
+		} else {
+			jobs.done(stolen, h)
+		}
+	}
+	saved, _ := store.FindRangeJobs("eth")
+	if len(saved) != 2 || !saved[0].Finished || !saved[1].Finished {
+		t.Fatalf("wrong finished range job %v", saved)
+	}
+
+	rerun := newRangeJobs("eth", 0, 10, 1, store, nil)
+	if h, to, ok := rerun.next(rerun.take()); !ok || h != 0 || to != 10 {
+		t.Fatalf("rerun at height %v to %v %v, want 0 10", h, to, ok)
+	}
+	if job := findRangeJob(t, store, 0, 10); job.Cursor != 0 || job.Finished {
+		t.Fatalf("checkpoint cursor %v finished %v of rerun, want 0 false", job.Cursor, job.Finished)
+	}
+}
+
+// TestRangeJobResumeOtherRange the unfinished range job is resumed though the end of range differs
+func TestRangeJobResumeOtherRange(t *testing.T) {
+	store := newTestRangeStore(t)
+	jobs := newRangeJobs("eth", 0, 10, 1, store, nil)
+	s := jobs.take()
+	for h := uint64(0); h < 4; h++ {
+		jobs.done(s, h)
+	}
+	jobs.stop(s)
+
+	resumed := newRangeJobs("eth", 0, 15, 1, store, nil)
+	if len(resumed.slices) != 2 {
+		t.Fatalf("resumed %v slices, want 2", len(resumed.slices))
+	}
+	if h, to, ok := resumed.next(resumed.take()); !ok || h != 4 || to != 10 {
+		t.Fatalf("resumed at height %v to %v %v, want 4 10", h, to, ok)
+	}
+	if h, to, ok := resumed.next(resumed.take()); !ok || h != 10 || to != 15 {
+		t.Fatalf("new slice at height %v to %v %v, want 10 15", h, to, ok)
+	}
+	saved, _ := store.FindRangeJobs("eth")
+	if len(saved) != 2 || saved[0].End != 15 || saved[0].Cursor != 4 || saved[1].End != 15 || saved[1].Cursor != 10 {
+		t.Fatalf("wrong saved slices of resumed range job %+v", saved)
+	}
+}
+
+// TestRangeJobDeletedWhenFinished the checkpoints are deleted after the range job is finished and flushed
+func TestRangeJobDeletedWhenFinished(t *testing.T) {
+	store := newTestRangeStore(t)
+	scanner := newStubScanner(t, newStubClient())
+	jobs := newRangeJobs("eth", 0, 10, 1, store, nil)
+	s := jobs.take()
+	for h := uint64(0); h < 5; h++ {
+		jobs.done(s, h)
+	}
+	jobs.stop(s)
+	scanner.loopFlushRangeJobs(jobs)
+	if saved, _ := store.FindRangeJobs("eth"); len(saved) != 1 {
+		t.Fatalf("unfinished range job is deleted, %v jobs are left", len(saved))
+	}
+
+	for h := uint64(5); h < 10; h++ {
+		jobs.done(s, h)
+	}
+	scanner.loopFlushRangeJobs(jobs)
+	if saved, _ := store.FindRangeJobs("eth"); len(saved) != 0 {
+		t.Fatalf("finished range job is not deleted, %v jobs are left", len(saved))
+	}
+}
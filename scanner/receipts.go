@@ -0,0 +1,186 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+	rpc "github.com/jowenshaw/gethrpc"
+)
+
+const (
+	defaultReceiptCacheBlocks = 128 // blocks of receipts to cache
+	maxReceiptBatchSize       = 100 // max receipts in one batch call
+)
+
+// receiptCache receipts of the recent blocks
+type receiptCache struct {
+	lock     sync.Mutex
+	capacity int
+	receipts map[common.Hash]*types.Receipt // tx hash -> receipt
+	blockTxs map[common.Hash][]common.Hash  // block hash -> tx hashes
+	blocks   []common.Hash                  // block hashes in adding order
+}
+
+func newReceiptCache(capacity int) *receiptCache {
+	if capacity <= 0 {
+		capacity = defaultReceiptCacheBlocks
+	}
+	return &receiptCache{
+		capacity: capacity,
+		receipts: make(map[common.Hash]*types.Receipt),
+		blockTxs: make(map[common.Hash][]common.Hash),
+	}
+}
+
+func (c *receiptCache) get(txHash common.Hash) *types.Receipt {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.receipts[txHash]
+}
+
+func (c *receiptCache) add(receipts ...*types.Receipt) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, receipt := range receipts {
+		if receipt == nil {
+			continue
+		}
+		if _, exist := c.receipts[receipt.TxHash]; exist {
+			continue
+		}
+		blockHash := receipt.BlockHash
+		if _, exist := c.blockTxs[blockHash]; !exist {
+			c.blocks = append(c.blocks, blockHash)
+		}
+		c.blockTxs[blockHash] = append(c.blockTxs[blockHash], receipt.TxHash)
+		c.receipts[receipt.TxHash] = receipt
+	}
+	for len(c.blocks) > c.capacity {
+		c.removeBlockLocked(c.blocks[0])
+	}
+}
+
+// removeBlock remove receipts of block, eg. the block is orphaned
+func (c *receiptCache) removeBlock(blockHash common.Hash) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removeBlockLocked(blockHash)
+}
+
+func (c *receiptCache) removeBlockLocked(blockHash common.Hash) {
+	txs, exist := c.blockTxs[blockHash]
+	if !exist {
+		return
+	}
+	for _, txHash := range txs {
+		delete(c.receipts, txHash)
+	}
+	delete(c.blockTxs, blockHash)
+	for i, hash := range c.blocks {
+		if hash == blockHash {
+			c.blocks = append(c.blocks[:i], c.blocks[i+1:]...)
+			break
+		}
+	}
+}
+
+// prefetchReceipts fetch the receipts needed by scanning block into cache,
+// with `eth_getBlockReceipts` if the node supports it, or batch `eth_getTransactionReceipt`.
+func (scanner *ethSwapScanner) prefetchReceipts(block *types.Block, bloom *types.Bloom) {
+	if scanner.gateways.noBatch {
+		return // receipts are got one by one when verifying txs
+	}
+	var txHashes []common.Hash
+	for _, tx := range block.Transactions() {
+		if tx.To() == nil || scanner.receipts.get(tx.Hash()) != nil {
+			continue
+		}
+		for _, tokenCfg := range scanner.tokens() {
+			isAcceptToAddr, needReceipt := scanner.isAcceptTx(tx, tokenCfg)
+			if isAcceptToAddr && needReceipt && !scanner.isReceiptRuledOut(bloom, tokenCfg) {
+				txHashes = append(txHashes, tx.Hash())
+				break
+			}
+		}
+	}
+	if len(txHashes) == 0 {
+		return
+	}
+	if atomic.LoadInt32(&scanner.noBlockReceipts) == 0 {
+		err := scanner.getBlockReceipts(block)
+		if err == nil {
+			return
+		}
+		if isMethodNotSupported(err) {
+			log.Warn("eth_getBlockReceipts is not supported, use batch eth_getTransactionReceipt instead", "err", err)
+			atomic.StoreInt32(&scanner.noBlockReceipts, 1)
+		} else {
+			log.Warn("get block receipts failed", "height", block.NumberU64(), "err", err)
+		}
+	}
+	scanner.batchGetReceipts(txHashes)
+}
+
+func (scanner *ethSwapScanner) getBlockReceipts(block *types.Block) error {
+	var receipts []*types.Receipt
+	err := scanner.gateways.callRPC("eth_getBlockReceipts", func(cli BatchClient) error {
+		return cli.CallContext(scanner.ctx, &receipts, "eth_getBlockReceipts", fmt.Sprintf("0x%x", block.NumberU64()))
+	})
+	if err != nil {
+		return err
+	}
+	if len(receipts) != len(block.Transactions()) {
+		return fmt.Errorf("get %v receipts of block %v with %v txs", len(receipts), block.NumberU64(), len(block.Transactions()))
+	}
+	for _, receipt := range receipts {
+		if receipt == nil || receipt.BlockHash != block.Hash() {
+			return fmt.Errorf("get block receipts of block %v mismatch", block.NumberU64())
+		}
+	}
+	scanner.receipts.add(receipts...)
+	return nil
+}
+
+func (scanner *ethSwapScanner) batchGetReceipts(txHashes []common.Hash) {
+	for start := 0; start < len(txHashes); start += maxReceiptBatchSize {
+		end := start + maxReceiptBatchSize
+		if end > len(txHashes) {
+			end = len(txHashes)
+		}
+		receipts := make([]*types.Receipt, end-start)
+		batch := make([]rpc.BatchElem, end-start)
+		for i, txHash := range txHashes[start:end] {
+			batch[i] = rpc.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{txHash},
+				Result: &receipts[i],
+			}
+		}
+		err := scanner.gateways.callRPC("eth_getTransactionReceipt", func(cli BatchClient) error {
+			return cli.BatchCallContext(scanner.ctx, batch)
+		})
+		if err != nil {
+			log.Warn("batch get tx receipts failed", "count", len(batch), "err", err)
+			continue
+		}
+		for i, elem := range batch {
+			if elem.Error != nil {
+				log.Debug("batch get tx receipt failed", "txHash", txHashes[start+i].Hex(), "err", elem.Error)
+			}
+		}
+		scanner.receipts.add(receipts...)
+	}
+}
+
+func isMethodNotSupported(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "method not found") ||
+		strings.Contains(msg, "not supported") ||
+		strings.Contains(msg, "does not exist") ||
+		strings.Contains(msg, "not available")
+}
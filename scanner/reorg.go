@@ -0,0 +1,218 @@
+package scanner
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+)
+
+const (
+	defaultReorgDepth = 100
+
+	orphanedReason = "orphaned block"
+)
+
+// blockHeader canonical block header info
+type blockHeader struct {
+	number     uint64
+	hash       common.Hash
+	parentHash common.Hash
+}
+
+// headerChain keeps the recent canonical headers (continuous in number)
+type headerChain struct {
+	lock     sync.RWMutex
+	capacity int
+	headers  []*blockHeader
+}
+
+func newHeaderChain(capacity int) *headerChain {
+	if capacity <= 0 {
+		capacity = defaultReorgDepth
+	}
+	return &headerChain{
+		capacity: capacity,
+		headers:  make([]*blockHeader, 0, capacity),
+	}
+}
+
+func (hc *headerChain) first() *blockHeader {
+	if len(hc.headers) == 0 {
+		return nil
+	}
+	return hc.headers[0]
+}
+
+func (hc *headerChain) last() *blockHeader {
+	if len(hc.headers) == 0 {
+		return nil
+	}
+	return hc.headers[len(hc.headers)-1]
+}
+
+func (hc *headerChain) get(number uint64) *blockHeader {
+	hc.lock.RLock()
+	defer hc.lock.RUnlock()
+
+	first := hc.first()
+	if first == nil || number < first.number || number > hc.last().number {
+		return nil
+	}
+	return hc.headers[number-first.number]
+}
+
+// add append header to the tail, reset the chain if not continuous
+func (hc *headerChain) add(header *types.Header) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	item := &blockHeader{
+		number:     header.Number.Uint64(),
+		hash:       header.Hash(),
+		parentHash: header.ParentHash,
+	}
+	last := hc.last()
+	switch {
+	case last == nil:
+	case item.number == last.number+1:
+	case item.number <= last.number && item.number >= hc.first().number:
+		// replaced by a new block at the same height
+		hc.headers = hc.headers[:item.number-hc.first().number]
+	case item.number < hc.first().number:
+		// older than what we remembered, ignore
+		return
+	default:
+		hc.headers = hc.headers[:0]
+	}
+	hc.headers = append(hc.headers, item)
+	if len(hc.headers) > hc.capacity {
+		hc.headers = hc.headers[len(hc.headers)-hc.capacity:]
+	}
+}
+
+// truncate remove headers after number, and return the removed ones
+func (hc *headerChain) truncate(number uint64) (removed []*blockHeader) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	first := hc.first()
+	if first == nil || number >= hc.last().number {
+		return nil
+	}
+	keep := 0
+	if number >= first.number {
+		keep = int(number - first.number + 1)
+	}
+	removed = append(removed, hc.headers[keep:]...)
+	hc.headers = hc.headers[:keep]
+	return removed
+}
+
+func (scanner *ethSwapScanner) loopGetHeader(height uint64) (header *types.Header, err error) {
+	blockNumber := new(big.Int).SetUint64(height)
+	for i := 0; i < 5; i++ { // with retry
+		err = scanner.gateways.call("eth_getBlockByNumber", func(cli Client) (err error) {
+			header, err = cli.HeaderByNumber(scanner.ctx, blockNumber)
+			return err
+		})
+		if err == nil {
+			return header, nil
+		}
+		log.Warn("get header failed", "height", height, "err", err)
+		if !scanner.sleep(scanner.rpcInterval) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// scanCanonicalBlock scan block at height in the live scan loop,
+// detect chain reorganization and rollback the orphaned blocks.
+// The block should be scanned again if error is returned.
+func (scanner *ethSwapScanner) scanCanonicalBlock(height uint64) error {
+	block, err := scanner.loopGetBlock(height)
+	if err != nil {
+		return err
+	}
+	blockHash := block.Hash()
+
+	existing := scanner.headers.get(height)
+	if existing != nil && existing.hash == blockHash {
+		return nil // already scanned
+	}
+
+	parent := scanner.headers.get(height - 1)
+	isReorg := (existing != nil && existing.hash != blockHash) ||
+		(parent != nil && parent.hash != block.ParentHash())
+	if !isReorg {
+		if err = scanner.processBlock(0, block); err != nil {
+			return err
+		}
+		scanner.headers.add(block.Header())
+		return nil
+	}
+
+	forkPoint, err := scanner.findForkPoint(height - 1)
+	if err != nil {
+		log.Error("find fork point failed, abort rollback", "height", height, "hash", blockHash.Hex(), "err", err)
+		return err
+	}
+	log.Warn("chain reorganization detected", "height", height, "hash", blockHash.Hex(), "parentHash", block.ParentHash().Hex(), "forkPoint", forkPoint)
+	scanner.rollbackTo(forkPoint)
+
+	for h := forkPoint + 1; h <= height; h++ {
+		if err = scanner.scanCanonicalBlock(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findForkPoint find the highest remembered block which is still canonical,
+// it only descends if the remembered block is confirmed to be replaced.
+func (scanner *ethSwapScanner) findForkPoint(from uint64) (uint64, error) {
+	for h := from; ; h-- {
+		stored := scanner.headers.get(h)
+		if stored == nil {
+			// exceeds max reorg depth, the oldest remembered block is fork point
+			log.Warn("reorg exceeds max depth", "from", from, "height", h)
+			return h, nil
+		}
+		header, err := scanner.loopGetHeader(h)
+		if err != nil {
+			return 0, err
+		}
+		if header.Hash() == stored.hash {
+			return h, nil
+		}
+		if h == 0 {
+			return 0, nil
+		}
+	}
+}
+
+// rollbackTo remove blocks after forkPoint and mark their swaps as orphaned,
+// the logs of the blocks after forkPoint are scanned again in log scan mode.
+func (scanner *ethSwapScanner) rollbackTo(forkPoint uint64) {
+	if scanner.logsScannedTo > forkPoint+1 {
+		scanner.logsScannedTo = forkPoint + 1
+	}
+	orphaned := scanner.headers.truncate(forkPoint)
+	for _, header := range orphaned {
+		blockHash := header.hash.Hex()
+		log.Warn("rollback orphaned block", "height", header.number, "hash", blockHash)
+		scanner.receipts.removeBlock(header.hash)
+		scanner.removeOutboxSwaps(func(swap *swapPost) bool {
+			return swap.blockHash == blockHash
+		})
+		scanner.removeUnconfirmedBlock(header.number, blockHash)
+		if scanner.store != nil {
+			reason := fmt.Sprintf("%v %v at height %v", orphanedReason, blockHash, header.number)
+			scanner.markSwapsOrphaned(header.number, blockHash, reason)
+		}
+	}
+}
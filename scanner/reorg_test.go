@@ -0,0 +1,135 @@
+package scanner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jowenshaw/gethclient/common"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+// newStubChain add blocks [1, n] to stub client, returns their hashes
+func newStubChain(client *stubClient, n uint64, extra byte) []common.Hash {
+	hashes := make([]common.Hash, n+1)
+	for h := uint64(1); h <= n; h++ {
+		hashes[h] = client.addHeader(h, hashes[h-1], extra)
+	}
+	return hashes
+}
+
+// reorgStubChain replace blocks [from, to] of stub client, returns their new hashes
+func reorgStubChain(client *stubClient, hashes []common.Hash, from, to uint64) []common.Hash {
+	reorged := append([]common.Hash{}, hashes[:from]...)
+	for h := from; h <= to; h++ {
+		reorged = append(reorged, client.addHeader(h, reorged[h-1], 1))
+	}
+	return reorged
+}
+
+func scanStubChain(t *testing.T, scanner *ethSwapScanner, from, to uint64) {
+	for h := from; h <= to; h++ {
+		if err := scanner.scanCanonicalBlock(h); err != nil {
+			t.Fatalf("scan block %v failed: %v", h, err)
+		}
+	}
+}
+
+func TestScanCanonicalBlockReorg(t *testing.T) {
+	client := newStubClient()
+	hashes := newStubChain(client, 5, 0)
+	scanner := newStubScanner(t, client)
+	scanner.confirmPolicy = confirmPolicyDepth
+	scanStubChain(t, scanner, 1, 5)
+
+	swap := newTestSwapPost("0x1", "1")
+	swap.blockNumber, swap.blockHash = 4, hashes[4].Hex()
+	scanner.holdSwap(swap)
+
+	reorged := reorgStubChain(client, hashes, 4, 6)
+	scanStubChain(t, scanner, 6, 6)
+	for h := uint64(1); h <= 6; h++ {
+		header := scanner.headers.get(h)
+		if header == nil || header.hash != reorged[h] {
+			t.Fatalf("header at %v is not canonical after reorg", h)
+		}
+	}
+	if scanner.unconfirmed.len() != 0 {
+		t.Fatal("swap of orphaned block is not dropped")
+	}
+}
+
+// TestFindForkPointError the rollback is aborted if the canonical header can not be got
+func TestFindForkPointError(t *testing.T) {
+	client := newStubClient()
+	hashes := newStubChain(client, 5, 0)
+	scanner := newStubScanner(t, client)
+	scanStubChain(t, scanner, 1, 5)
+	reorged := reorgStubChain(client, hashes, 3, 6)
+
+	errHeader := errors.New("header is not available")
+	client.setError("eth_getBlockByNumber", errHeader)
+	if _, err := scanner.findForkPoint(5); !errors.Is(err, errHeader) {
+		t.Fatalf("find fork point got error %v, want %v", err, errHeader)
+	}
+	if err := scanner.scanCanonicalBlock(6); !errors.Is(err, errHeader) {
+		t.Fatalf("scan block got error %v, want %v", err, errHeader)
+	}
+	if last := scanner.headers.last(); last.number != 5 || last.hash != hashes[5] {
+		t.Fatalf("headers are rolled back to %v on error", last.number)
+	}
+
+	client.setError("eth_getBlockByNumber", nil)
+	forkPoint, err := scanner.findForkPoint(5)
+	if err != nil || forkPoint != 2 {
+		t.Fatalf("fork point is %v, err %v, want 2", forkPoint, err)
+	}
+	scanStubChain(t, scanner, 6, 6)
+	if header := scanner.headers.get(3); header == nil || header.hash != reorged[3] {
+		t.Fatal("reorged block is not scanned")
+	}
+}
+
+// TestReorgWithoutConfirmPolicy the swap of orphaned block is posted again
+// after it's detected in the replacing block
+func TestReorgWithoutConfirmPolicy(t *testing.T) {
+	client := newStubClient()
+	hashes := newStubChain(client, 5, 0)
+	scanner := newStubScanner(t, client)
+	scanner.store = newTestRangeStore(t)
+	scanner.sink = outcomeSink{"0x1": params.PostSuccess}
+	scanStubChain(t, scanner, 1, 5)
+
+	post := func(blockHash common.Hash) {
+		swap := newTestSwapPost("0x1", "1")
+		swap.blockNumber, swap.blockHash = 4, blockHash.Hex()
+		scanner.enqueueSwap(swap)
+		scanner.postSwapPost(<-scanner.posts.swaps)
+		scanner.finishQueuedSwap(swap)
+	}
+	post(hashes[4])
+	reorged := reorgStubChain(client, hashes, 4, 6)
+	scanStubChain(t, scanner, 6, 6)
+	post(reorged[4])
+
+	swap, err := scanner.store.FindSwap(newTestSwapPost("0x1", "1").key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swap.State != storage.SwapRegistered || swap.BlockHash != reorged[4].Hex() {
+		t.Fatalf("swap of replacing block has state %v block %v", swap.State, swap.BlockHash)
+	}
+	want := []string{
+		storage.SwapDetected, storage.SwapPosting, storage.SwapRegistered, storage.SwapOrphaned,
+		storage.SwapDetected, storage.SwapPosting, storage.SwapRegistered,
+	}
+	if len(swap.History) != len(want) {
+		t.Fatalf("history has %v transitions, want %v", len(swap.History), len(want))
+	}
+	for i, state := range want {
+		if swap.History[i].To != state {
+			t.Errorf("transition %v is to %v, want %v", i, swap.History[i].To, state)
+		}
+	}
+}
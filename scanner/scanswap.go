@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/anyswap/CrossChain-Bridge/cmd/utils"
@@ -19,13 +20,17 @@ import (
 	"github.com/anyswap/CrossChain-Bridge/tokens"
 	"github.com/urfave/cli/v2"
 
-	ethclient "github.com/jowenshaw/gethclient"
 	"github.com/jowenshaw/gethclient/common"
 	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
 
 	"github.com/weijun-sh/gethscan/params"
 	"github.com/weijun-sh/gethscan/tools"
+	"github.com/weijun-sh/gethscan/metrics"
 	"github.com/weijun-sh/gethscan/mongodb"
+	"github.com/weijun-sh/gethscan/storage"
+	"github.com/weijun-sh/gethscan/scanner/base"
+	"github.com/weijun-sh/gethscan/scanner/events"
 )
 
 var (
@@ -34,9 +39,14 @@ var (
 		Usage: "scan transaction receipt instead of transaction",
 	}
 
+	scanLogsFlag = &cli.BoolFlag{
+		Name:  "scanLogs",
+		Usage: "scan router swaps by getLogs in block ranges instead of transactions",
+	}
+
 	InitSyncdBlockNumberFlag = &cli.BoolFlag{
 		Name:  "initsync",
-		Usage: "init synced block number to mongodb",
+		Usage: "init synced block number to storage",
 	}
 
 	startHeightFlag = &cli.Int64Flag{
@@ -47,7 +57,7 @@ var (
 
 	timeoutFlag = &cli.Uint64Flag{
 		Name:  "timeout",
-		Usage: "timeout of scanning one block in seconds",
+		Usage: "warn if scanning one block takes longer than timeout in seconds",
 		Value: 300,
 	}
 
@@ -64,6 +74,7 @@ scan cross chain swaps
 			utils.ConfigFileFlag,
 			utils.GatewayFlag,
 			scanReceiptFlag,
+			scanLogsFlag,
 			InitSyncdBlockNumberFlag,
 			startHeightFlag,
 			utils.EndHeightFlag,
@@ -81,64 +92,26 @@ scan cross chain swaps
 	transferLogTopic       = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
 	addressSwapoutLogTopic = common.HexToHash("0x6b616089d04950dc06c45c6dd787d657980543f89651aec47924752c7d16c888")
 	stringSwapoutLogTopic  = common.HexToHash("0x9c92ad817e5474d30a4378deface765150479363a897b0590fbb12ae9d89396b")
-
-	// router
-	routerAnySwapOutTopic                  = common.FromHex("0x97116cf6cd4f6412bb47914d6db18da9e16ab2142f543b86e207c24fbd16b23a")
-	routerAnySwapOutTopic2                 = common.FromHex("0x409e0ad946b19f77602d6cf11d59e1796ddaa4828159a0b4fb7fa2ff6b161b79")
-	routerAnySwapTradeTokensForTokensTopic = common.FromHex("0xfea6abdf4fd32f20966dff7619354cd82cd43dc78a3bee479f04c74dbfc585b3")
-	routerAnySwapTradeTokensForNativeTopic = common.FromHex("0x278277e0209c347189add7bd92411973b5f6b8644f7ac62ea1be984ce993f8f4")
-        routerCrossDexTopic                    = common.FromHex("0x8e7e5695fff09074d4c7d6c71615fd382427677f75f460c522357233f3bd3ec3")
-
-	routerAnySwapOutV7Topic = common.FromHex("0x0d969ae475ff6fcaf0dcfa760d4d8607244e8d95e9bf426f8d5d69f9a3e525af")
-	routerAnySwapOutAndCallV7Topic = common.FromHex("0x968608314ec29f6fd1a9f6ef9e96247a4da1a683917569706e2d2b60ca7c0a6d")
-
-	// router nft
-	routerNFT721SwapOutTopic       = common.FromHex("0x0d45b0b9f5add3e1bb841982f1fa9303628b0b619b000cb1f9f1c3903329a4c7")
-	routerNFT1155SwapOutTopic      = common.FromHex("0x5058b8684cf36ffd9f66bc623fbc617a44dd65cf2273306d03d3104af0995cb0")
-	routerNFT1155SwapOutBatchTopic = common.FromHex("0xaa428a5ab688b49b415401782c170d216b33b15711d30cf69482f570eca8db38")
-
-	// anycall
-	routerAnycallTopic          = common.FromHex("0x9ca1de98ebed0a9c38ace93d3ca529edacbbe199cf1b6f0f416ae9b724d4a81c")
-	routerAnycallTransferSwapOutTopic = common.FromHex("0xcaac11c45e5fdb5c513e20ac229a3f9f99143580b5eb08d0fecbdd5ae8c81ef5")
-
-	routerAnycallV6Topic        = common.FromHex("0xa17aef042e1a5dd2b8e68f0d0d92f9a6a0b35dc25be1d12c0cb3135bfd8951c9")
-
-	routerAnycallV7Topic = common.FromHex("0x17dac14bf31c4070ebb2dc182fc25ae5df58f14162a7f24a65b103e22385af0d")
-	routerAnycallV7Topic2 = common.FromHex("0x36850177870d3e3dca07a29dcdc3994356392b81c60f537c1696468b1a01e61d")
 )
 
 const (
 	postSwapSuccessResult   = "success"
-	bridgeSwapExistKeywords = "mgoError: Item is duplicate"
-	routerSwapExistResult   = "already registered"
-	routerSwapExistResultTmp   = "alreday registered"
-	httpTimeoutKeywords     = "Client.Timeout exceeded while awaiting headers"
-	errConnectionRefused    = "connect: connection refused"
 	errMaximumRequestLimit  = "You have reached maximum request limit"
-	rpcQueryErrKeywords     = "rpc query error"
-	errDepositLogNotFountorRemoved = "return error: json-rpc error -32099, verify swap failed! deposit log not found or removed"
-	swapIsClosedResult             = "swap is closed"
-	swapTradeNotSupport            = "swap trade not support"
-	txWithWrongContract            = "tx with wrong contract"
-	wrongBindAddress               = "wrong bind address"
 )
 
-var startHeightArgument int64
-
-var (
-       chain         string
-       mongodbEnable bool = true
-	syncedNumber uint64
-	syncedCount uint64
-	syncdCount2Mongodb uint64 = 100
-	synced bool = false
-
-	configFile chan bool = make(chan bool)
-)
+const defaultSyncdCount2Mongodb = 100
 
 type ethSwapScanner struct {
+	chain    string
+	chainCfg *params.ChainConfig
+
 	gateway     string
 	scanReceipt bool
+	scanLogs    bool
+	traceMethod string // trace internal txs of blocks to detect native swapins
+
+	confirmPolicy string            // post swaps after their blocks meet the policy, empty to post on detection
+	unconfirmed   *unconfirmedSwaps // detected swaps waiting for block confirmation
 
 	chainID *big.Int
 
@@ -147,16 +120,51 @@ type ethSwapScanner struct {
 	scanBackHeight uint64
 	jobCount     uint64
 
-	processBlockTimeout time.Duration
-	processBlockTimers  []*time.Timer
+	processBlockTimeout time.Duration // warn if scanning one block takes longer
+
+	posts *postQueue // detected swaps waiting to be posted
+
+	gateways *gatewayPool
+	ctx      context.Context // root context, cancelled on SIGINT/SIGTERM
 
-	client *ethclient.Client
-	ctx    context.Context
+	inflightPosts int64
+
+	backend base.Scanner // scan non evm chains, nil for evm chains
 
 	rpcInterval   time.Duration
 	rpcRetryCount int
 
-	cachedSwapPosts *tools.Ring
+	sink   Sink        // deliver swaps, the sinks of token configs by default
+	onSwap func(*Swap) // called after swap is posted, eg. callback of embedded scanner
+
+	outbox *tools.Outbox
+
+	headers *headerChain
+
+	receipts        *receiptCache
+	noBlockReceipts int32 // gateway does not support eth_getBlockReceipts
+
+	progress        jobProgresses
+	paused          int32
+	adminRescanning int32
+
+	store              storage.Store // persist swaps and synced block number, nil if disabled
+	startHeight        int64 // '--start' argument, only used in single chain mode
+	syncedNumber       uint64 // accessed atomically, it's read by admin status
+	syncedCount        uint64
+	syncdCount2Mongodb uint64
+	synced             bool
+	configReloaded     int32 // scan back in scan loop after config is reloaded
+	logsScannedTo      uint64 // logs of blocks before it are scanned in scan loop, rewound by rollback
+
+	// router log filters, rebuilt when config is reloaded
+	tokenSwap                   map[string]*params.TokenConfig
+	fqSwapRouter                ethereum.FilterQuery
+	fqSwapRouterNFT             ethereum.FilterQuery
+	fqSwapRouterAnycall         ethereum.FilterQuery
+	filterLogsRouterChan        chan types.Log
+	filterLogsRouterNFTChan     chan types.Log
+	filterLogsRouterAnycallChan chan types.Log
 }
 
 type swapPost struct {
@@ -172,143 +180,299 @@ type swapPost struct {
 	// router
 	chainID  string
 	logIndex string
+
+	// block of the swap tx
+	blockNumber uint64
+	blockHash   string
+
+	// decoded router swap event
+	detail *events.Detail
+
+	// classified result of the last post
+	outcome string
+	postErr string
+
+	// sinks of token config, and the sinks which have finished the swap
+	sinks     []string
+	delivered []string
 }
 
-func WatchAndReloadScanConfig(cf chan bool) {
-        go params.WatchAndReloadScanConfig(cf)
-        for {
-                select {
-                case <-cf:
-                        initFilerLogs()
-                }
-        }
+// watchAndReloadScanConfig reload the tokens of all chains when config file changes
+func watchAndReloadScanConfig(ctx context.Context, scanners []*ethSwapScanner) {
+	cf := make(chan bool)
+	go params.WatchAndReloadScanConfig(ctx, cf)
+	for {
+		select {
+		case <-cf:
+			reloadScanners(scanners)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reloadScanners rebuild log filters with the reloaded tokens, and scan back in scan loops
+func reloadScanners(scanners []*ethSwapScanner) {
+	for _, scanner := range scanners {
+		if scanner.backend == nil {
+			scanner.initFilerLogs()
+		}
+		atomic.StoreInt32(&scanner.configReloaded, 1)
+	}
 }
 
 func scanSwap(ctx *cli.Context) error {
 	utils.SetLogger(ctx)
 	params.LoadConfig(utils.GetConfigFilePath(ctx))
-	go WatchAndReloadScanConfig(configFile)
 
+	rootCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+
+	metrics.StartServer(params.GetMetricsConfig().Listen)
+	// the storage is shared by the scanners of all chains
+	store := InitStorage()
+	if store != nil {
+		defer store.Close()
+	}
+
+	if params.IsMultiChain() {
+		for _, flag := range []string{utils.GatewayFlag.Name, startHeightFlag.Name, utils.EndHeightFlag.Name} {
+			if ctx.IsSet(flag) {
+				log.Warn("ignore argument in multi-chain mode", "flag", flag)
+			}
+		}
+	}
+	// the sinks are shared by the scanners of all chains
+	sinks, err := NewTokenSinks(params.GetSinkConfigs())
+	if err != nil {
+		log.Fatal("new swap sinks failed", "err", err)
+	}
+	defer sinks.Close()
+
+	var scanners []*ethSwapScanner
+	for _, chainCfg := range params.GetChainConfigs() {
+		scanner := newSwapScanner(rootCtx, ctx, chainCfg, sinks, store)
+		scanner.initOutbox()
+		scanner.prepare()
+		scanners = append(scanners, scanner)
+	}
+	go watchAndReloadScanConfig(rootCtx, scanners)
+	startAdminServer(params.GetAdminConfig(), scanners)
+
+	wg := new(sync.WaitGroup)
+	errs := make([]error, len(scanners))
+	for i, scanner := range scanners {
+		wg.Add(1)
+		go func(i int, scanner *ethSwapScanner) {
+			defer wg.Done()
+			scanner.run()
+			errs[i] = scanner.shutdown()
+		}(i, scanner)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newSwapScanner new scanner of chain,
+// the gateway and scan range arguments are only used in single chain mode.
+func newSwapScanner(rootCtx context.Context, ctx *cli.Context, chainCfg *params.ChainConfig, sink Sink, store storage.Store) *ethSwapScanner {
+	bcConfig := chainCfg.BlockChain
 	scanner := &ethSwapScanner{
-		ctx:           context.Background(),
-		rpcInterval:   1 * time.Second,
-		rpcRetryCount: 3,
+		ctx:                rootCtx,
+		chain:              bcConfig.Chain,
+		chainCfg:           chainCfg,
+		gateway:            chainCfg.Gateway,
+		rpcInterval:        1 * time.Second,
+		rpcRetryCount:      3,
+		startHeight:        -1,
+		syncdCount2Mongodb: defaultSyncdCount2Mongodb,
+		store:              store,
+		sink:               sink,
+		posts:              newPostQueue(params.GetPostQueueConfig()),
+	}
+	if !params.IsMultiChain() {
+		scanner.gateway = ctx.String(utils.GatewayFlag.Name)
+		scanner.startHeight = ctx.Int64(startHeightFlag.Name)
+		scanner.endHeight = ctx.Uint64(utils.EndHeightFlag.Name)
 	}
-	scanner.gateway = ctx.String(utils.GatewayFlag.Name)
 	scanner.scanReceipt = ctx.Bool(scanReceiptFlag.Name)
-	startHeightArgument = ctx.Int64(startHeightFlag.Name)
-	scanner.endHeight = ctx.Uint64(utils.EndHeightFlag.Name)
+	scanner.scanLogs = ctx.Bool(scanLogsFlag.Name)
 	scanner.stableHeight = ctx.Uint64(utils.StableHeightFlag.Name)
 	scanner.jobCount = ctx.Uint64(utils.JobsFlag.Name)
 	scanner.processBlockTimeout = time.Duration(ctx.Uint64(timeoutFlag.Name)) * time.Second
 
 	log.Info("get argument success",
+		"chain", scanner.chain,
 		"gateway", scanner.gateway,
 		"scanReceipt", scanner.scanReceipt,
-		"start", startHeightArgument,
+		"scanLogs", scanner.scanLogs,
+		"start", scanner.startHeight,
 		"end", scanner.endHeight,
 		"stable", scanner.stableHeight,
 		"jobs", scanner.jobCount,
 		"timeout", scanner.processBlockTimeout,
 	)
 
-	scanner.initClient()
-
-	bcConfig := params.GetBlockChainConfig()
-       chain = bcConfig.Chain
-	if bcConfig.SyncNumber > 0 {
-		syncdCount2Mongodb = bcConfig.SyncNumber
+	if bcConfig.IsAptos() {
+		scanner.initBackend(bcConfig)
+	} else {
+		scanner.initClient(bcConfig)
+	}
+	if err := scanner.initChain(); err != nil {
+		log.Fatal("init chain failed", "chain", scanner.chain, "err", err)
 	}
-	scanner.stableHeight = bcConfig.StableHeight
-	scanner.scanBackHeight = bcConfig.ScanBackHeight
 
-       //mongo
-	mgoConfig := params.GetMongodbConfig()
-	mongodbEnable = mgoConfig.Enable
-	if mongodbEnable {
-		InitMongodb()
+	if scanner.store != nil {
 		if ctx.Bool(InitSyncdBlockNumberFlag.Name) {
 			lb := scanner.loopGetLatestBlockNumber() - 10
-			err := mongodb.InitSyncedBlockNumber(chain, lb)
-			fmt.Printf("InitSyncedBlockNumber, err: %v, number: %v\n", err, lb)
+			err := scanner.store.InitSyncedBlockNumber(scanner.chain, lb)
+			fmt.Printf("InitSyncedBlockNumber, chain: %v, err: %v, number: %v\n", scanner.chain, err, lb)
 		}
-		go scanner.loopSwapPending()
-		syncedCount = 0
-		syncedNumber = getSyncdBlockNumber() - 10
+		atomic.StoreUint64(&scanner.syncedNumber, scanner.getSyncdBlockNumber()-10)
 	} else {
-		syncedNumber = scanner.loopGetLatestBlockNumber() - 10
+		atomic.StoreUint64(&scanner.syncedNumber, scanner.loopGetLatestBlockNumber()-10)
+	}
+	return scanner
+}
+
+// initChain init the chain settings of config after the client or backend is initialized
+func (scanner *ethSwapScanner) initChain() error {
+	bcConfig := scanner.chainCfg.BlockChain
+	if scanner.backend == nil {
+		if err := checkTraceMethod(bcConfig.TraceMethod); err != nil {
+			return err
+		}
+		if bcConfig.TraceMethod != "" && scanner.gateways.noBatch {
+			return fmt.Errorf("trace method %v: %w", bcConfig.TraceMethod, errNoBatchClient)
+		}
+		scanner.traceMethod = bcConfig.TraceMethod
+		if err := checkConfirmPolicy(bcConfig.ConfirmPolicy); err != nil {
+			return err
+		}
+		switch bcConfig.ConfirmPolicy {
+		case confirmPolicyFinalized, confirmPolicySafe:
+			if scanner.gateways.noBatch {
+				return fmt.Errorf("confirm policy %v: %w", bcConfig.ConfirmPolicy, errNoBatchClient)
+			}
+		}
+		scanner.confirmPolicy = bcConfig.ConfirmPolicy
+		if err := events.LoadDir(bcConfig.EventABIDir); err != nil {
+			return fmt.Errorf("load event abis in '%v' failed: %w", bcConfig.EventABIDir, err)
+		}
+	} else if bcConfig.ConfirmPolicy != "" {
+		return fmt.Errorf("confirm policy %v is not supported by chain type %v", bcConfig.ConfirmPolicy, bcConfig.ChainType)
 	}
 
-	scanner.run()
+	if bcConfig.SyncNumber > 0 {
+		scanner.syncdCount2Mongodb = bcConfig.SyncNumber
+	}
+	scanner.stableHeight = bcConfig.StableHeight
+	scanner.scanBackHeight = bcConfig.ScanBackHeight
+	scanner.headers = newHeaderChain(int(bcConfig.ReorgDepth))
+	scanner.receipts = newReceiptCache(0)
+	scanner.unconfirmed = newUnconfirmedSwaps()
 	return nil
 }
 
-func getSyncdBlockNumber() uint64 {
+// tokens get the tokens of chain, which are replaced when config is reloaded
+func (scanner *ethSwapScanner) tokens() []*params.TokenConfig {
+	return scanner.chainCfg.GetScanConfig().Tokens
+}
+
+func (scanner *ethSwapScanner) getSyncdBlockNumber() uint64 {
 	var blockNumber uint64
 	var err error
 	for i := 0; i < 5; i++ { // with retry
-		blockNumber, err = mongodb.FindSyncedBlockNumber(chain)
+		blockNumber, err = scanner.store.FindSyncedBlockNumber(scanner.chain)
 		if err == nil {
-			log.Info("getSyncdBlockNumber", "syncedNumber", blockNumber)
+			log.Info("getSyncdBlockNumber", "chain", scanner.chain, "syncedNumber", blockNumber)
 			return blockNumber
 		}
 	}
-	log.Fatal("getSyncdBlockNumber failed", "err", err)
+	log.Fatal("getSyncdBlockNumber failed", "chain", scanner.chain, "err", err)
 	return 0
 }
 
-func (scanner *ethSwapScanner) initClient() {
-	ethcli, err := ethclient.Dial(scanner.gateway)
-	if err != nil {
-		log.Fatal("ethclient.Dail failed", "gateway", scanner.gateway, "err", err)
+func (scanner *ethSwapScanner) initClient(bcConfig *params.BlockChainConfig) {
+	urls := append([]string{scanner.gateway}, bcConfig.Gateways...)
+	scanner.gateways = newGatewayPool(scanner.chain, urls, bcConfig.GatewayMaxLag)
+	scanner.gateways.dial(scanner.ctx)
+	scanner.chainID = scanner.gateways.chainID
+	log.Info("get chainID success", "chainID", scanner.chainID, "gateways", len(scanner.gateways.gateways))
+
+	interval := time.Duration(bcConfig.GatewayCheckInterval) * time.Second
+	if interval == 0 {
+		interval = defaultGatewayCheckInterval * time.Second
 	}
-	log.Info("ethclient.Dail gateway success", "gateway", scanner.gateway)
-	scanner.client = ethcli
-	scanner.chainID, err = ethcli.ChainID(scanner.ctx)
-	if err != nil {
-		log.Fatal("get chainID failed", "err", err)
+	go scanner.gateways.loopCheckHealth(scanner.ctx, interval)
+}
+
+// prepare init log filters before running
+func (scanner *ethSwapScanner) prepare() {
+	if scanner.backend != nil {
+		return
 	}
-	log.Info("get chainID success", "chainID", scanner.chainID)
+	scanner.initGetlogs()
 }
 
 func (scanner *ethSwapScanner) run() {
-	scanner.cachedSwapPosts = tools.NewRing(100)
-	go scanner.repostCachedSwaps()
-
-	go scanner.initGetlogs()
-
-	scanner.processBlockTimers = make([]*time.Timer, scanner.jobCount+1)
-	for i := 0; i < len(scanner.processBlockTimers); i++ {
-		scanner.processBlockTimers[i] = time.NewTimer(scanner.processBlockTimeout)
+	if scanner.store != nil {
+		scanner.recoverPostingSwaps()
+	}
+	scanner.startPostWorkers()
+	if scanner.confirmPolicy != "" {
+		if scanner.store != nil {
+			scanner.loadUnconfirmedSwaps()
+		}
+		go scanner.loopConfirmSwaps()
+	}
+	if scanner.outbox != nil {
+		go scanner.repostCachedSwaps()
+	}
+	if scanner.store != nil {
+		go scanner.loopSwapPending()
+	}
+	if scanner.backend != nil {
+		scanner.runBackend()
+		return
 	}
 
 	wend := scanner.endHeight
 	if wend == 0 {
 		wend = scanner.loopGetLatestBlockNumber()
-		if uint64(startHeightArgument) > syncedNumber {
-			startHeightArgument = int64(syncedNumber)
+		if synced := atomic.LoadUint64(&scanner.syncedNumber); uint64(scanner.startHeight) > synced {
+			scanner.startHeight = int64(synced)
 		}
 	}
+	if scanner.isStopping() {
+		return
+	}
 
-	if startHeightArgument < 0 {
-		startHeightArgument = int64(syncedNumber)
+	if scanner.startHeight < 0 {
+		scanner.startHeight = int64(atomic.LoadUint64(&scanner.syncedNumber))
 	}
-	if startHeightArgument != 0 {
+	if scanner.startHeight != 0 {
 		var start uint64
-		if startHeightArgument > 0 {
-			start = uint64(startHeightArgument)
-		} else if startHeightArgument < 0 {
-			start = wend - uint64(-startHeightArgument)
+		if scanner.startHeight > 0 {
+			start = uint64(scanner.startHeight)
+		} else if scanner.startHeight < 0 {
+			start = wend - uint64(-scanner.startHeight)
 		}
 		scanner.doScanRangeJob(start, wend)
-		if scanner.endHeight == 0 && mongodbEnable {
-			rewriteSyncdBlockNumber(wend)
+		if scanner.endHeight == 0 && scanner.store != nil && !scanner.isStopping() {
+			scanner.rewriteSyncdBlockNumber(wend)
 		}
 	}
 	if scanner.endHeight == 0 {
 		scanner.scanLoop(wend)
 	}
-	select {}
+	<-scanner.ctx.Done()
 }
 
 func (scanner *ethSwapScanner) doScanRangeJob(start, end uint64) {
@@ -319,26 +483,25 @@ func (scanner *ethSwapScanner) doScanRangeJob(start, end uint64) {
 	if start >= end {
 		log.Fatalf("wrong scan range [%v, %v)", start, end)
 	}
-	jobs := scanner.jobCount
-	count := end - start
-	step := count / jobs
-	if step == 0 {
-		jobs = 1
-		step = count
-	}
 	wg := new(sync.WaitGroup)
-	for i := uint64(0); i < jobs; i++ {
-		from := start + i*step
-		to := start + (i+1)*step
-		if i+1 == jobs {
-			to = end
+	if scanner.scanLogs {
+		wg.Add(1)
+		go scanner.doScanLogsRangeJob(start, end, wg)
+		if !scanner.needScanBlocks() {
+			wg.Wait()
+			return
 		}
+	}
+	// slices are resumed from checkpoints in storage if the chain has unfinished range job
+	rangeJobs := newRangeJobs(scanner.chain, start, end, scanner.jobCount, scanner.store, scanner.posts.lowestHeightFrom)
+	for i := uint64(0); i < scanner.jobCount; i++ {
 		wg.Add(1)
-		go scanner.scanRange(i+1, from, to, wg)
+		go scanner.rangeWorker(i+1, rangeJobs, wg)
 	}
 	//if scanner.endHeight != 0 {
 		wg.Wait()
 	//}
+	go scanner.loopFlushRangeJobs(rangeJobs)
 }
 
 func (scanner *ethSwapScanner) scanRange(job, from, to uint64, wg *sync.WaitGroup) {
@@ -346,8 +509,18 @@ func (scanner *ethSwapScanner) scanRange(job, from, to uint64, wg *sync.WaitGrou
 	log.Info(fmt.Sprintf("[%v] scan range", job), "from", from, "to", to)
 
 	for h := from; h < to; h++ {
-		scanner.scanBlock(job, h, false)
+		scanner.waitIfPaused()
+		if scanner.isStopping() {
+			log.Info(fmt.Sprintf("[%v] scan range stopped", job), "from", from, "to", to, "height", h)
+			return
+		}
+		if !scanner.loopScanBlock(job, h) {
+			log.Info(fmt.Sprintf("[%v] scan range stopped", job), "from", from, "to", to, "height", h)
+			return
+		}
+		scanner.progress.update(job, from, to, h, false)
 	}
+	scanner.progress.update(job, from, to, to, true)
 
 	log.Info(fmt.Sprintf("[%v] scan range finish", job), "from", from, "to", to)
 }
@@ -356,72 +529,129 @@ func (scanner *ethSwapScanner) scanLoop(from uint64) {
 	stable := scanner.stableHeight
 	scanBack := scanner.scanBackHeight
 	log.Info("start scan loop job", "from", from, "stable", stable)
+	// scan on every new head if newHeads is subscribed, otherwise poll every second
+	heads := scanner.startHeadWatcher()
+	latest := scanner.loopGetLatestBlockNumber()
 	for {
-		latest := scanner.loopGetLatestBlockNumber()
 		for h := from; h <= latest; h++ {
-			scanner.scanBlock(0, h, true)
-			if mongodbEnable {
-				updateSyncdBlockNumber(h)
+			scanner.waitIfPaused()
+			if scanner.isStopping() {
+				log.Info("scan loop stopped", "height", h)
+				return
+			}
+			for err := scanner.scanCanonicalBlock(h); err != nil; err = scanner.scanCanonicalBlock(h) {
+				log.Warn("scan canonical block failed, retry later", "height", h, "err", err)
+				if !scanner.sleep(scanner.rpcInterval) {
+					break
+				}
+			}
+			if scanner.isStopping() {
+				// block may be partially scanned, do not mark it as synced
+				log.Info("scan loop stopped", "height", h)
+				return
+			}
+			scanner.progress.update(0, from, 0, h, false)
+			metrics.SetHeights(scanner.chain, latest, h)
+			if scanner.store != nil && !scanner.scanLogs {
+				scanner.updateSyncdBlockNumber(h)
+			}
+		}
+		// the blocks are synced after their logs are scanned in log scan mode,
+		// the logs of every block are scanned once unless the block is orphaned
+		if scanner.scanLogs && from <= latest {
+			logsFrom := from
+			if logsFrom < scanner.logsScannedTo {
+				logsFrom = scanner.logsScannedTo
+			}
+			if logsFrom <= latest {
+				if !scanner.scanLogsRange(logsFrom, latest, nil) {
+					log.Info("scan loop stopped", "height", logsFrom)
+					return
+				}
+				scanner.logsScannedTo = latest + 1
+			}
+			for h := from; scanner.store != nil && h <= latest; h++ {
+				scanner.updateSyncdBlockNumber(h)
 			}
 		}
 		if from+stable < latest {
 			from = latest - stable
 		}
-		if synced && params.GetHaveReloadConfig() {
-			synced = false
+		if scanner.synced && atomic.CompareAndSwapInt32(&scanner.configReloaded, 1, 0) {
+			scanner.synced = false
 			from -= scanBack
-			log.Info("scanLoop scan back", "justnow", latest, "now", from)
-			params.UpdateHaveReloadConfig(false)
+			log.Info("scanLoop scan back", "chain", scanner.chain, "justnow", latest, "now", from)
+		}
+		if latest = scanner.waitLatestBlockNumber(heads); scanner.isStopping() {
+			return
 		}
-		time.Sleep(1 * time.Second)
 	}
 }
 
-func rewriteSyncdBlockNumber(number uint64) {
-	syncedNumber = number
-	syncedCount = 0
-	err := mongodb.UpdateSyncedBlockNumber(chain, syncedNumber)
+func (scanner *ethSwapScanner) rewriteSyncdBlockNumber(number uint64) {
+	atomic.StoreUint64(&scanner.syncedNumber, number)
+	scanner.syncedCount = 0
+	saved := scanner.syncedNumberToSave()
+	err := scanner.store.UpdateSyncedBlockNumber(scanner.chain, saved)
 	if err == nil {
-		log.Info("rewriteSyncedBlockNumber", "block number", syncedNumber)
-		syncedCount = 0
+		log.Info("rewriteSyncedBlockNumber", "chain", scanner.chain, "block number", saved)
+		scanner.syncedCount = 0
 	} else {
-		log.Warn("rewriteSyncedBlockNumber failed", "err", err, "expect number", syncedNumber)
+		log.Warn("rewriteSyncedBlockNumber failed", "chain", scanner.chain, "err", err, "expect number", saved)
 	}
 }
 
-func updateSyncdBlockNumber(number uint64) {
-	//fmt.Printf("updateSyncdBlockNumber, number: %v, syncedNumber: %v, syncedCount: %v, syncdCount2Mongodb: %v\n", number, syncedNumber, syncedCount, syncdCount2Mongodb)
-	if number == syncedNumber + 1 {
-		syncedCount += 1
-		syncedNumber = number
+func (scanner *ethSwapScanner) updateSyncdBlockNumber(number uint64) {
+	if number == atomic.LoadUint64(&scanner.syncedNumber)+1 {
+		scanner.syncedCount++
+		atomic.StoreUint64(&scanner.syncedNumber, number)
 	}
-	if syncedCount >= syncdCount2Mongodb {
-		synced = true
-		err := mongodb.UpdateSyncedBlockNumber(chain, syncedNumber)
+	if scanner.syncedCount >= scanner.syncdCount2Mongodb {
+		scanner.synced = true
+		// swaps of the blocks may be still waiting to be posted
+		saved := scanner.syncedNumberToSave()
+		err := scanner.store.UpdateSyncedBlockNumber(scanner.chain, saved)
 		if err == nil {
-			log.Info("updateSyncedBlockNumber", "height", syncedNumber)
-			syncedCount = 0
+			log.Info("updateSyncedBlockNumber", "chain", scanner.chain, "height", saved)
+			scanner.syncedCount = 0
 		} else {
-			log.Warn("UpdateSyncedBlockNumber failed", "err", err, "expect number", syncedNumber)
+			log.Warn("UpdateSyncedBlockNumber failed", "chain", scanner.chain, "err", err, "expect number", saved)
 		}
 	}
 }
 
 func (scanner *ethSwapScanner) loopGetLatestBlockNumber() uint64 {
+	latestHeight := scanner.LatestHeight
+	if scanner.backend != nil {
+		latestHeight = scanner.backend.LatestHeight
+	}
 	for { // retry until success
-		header, err := scanner.client.HeaderByNumber(scanner.ctx, nil)
+		height, err := latestHeight()
 		if err == nil {
-			log.Info("get latest block number success", "height", header.Number)
-			return header.Number.Uint64()
+			log.Info("get latest block number success", "height", height)
+			metrics.LatestHeight.WithLabelValues(scanner.chain).Set(float64(height))
+			return height
 		}
 		log.Warn("get latest block number failed", "err", err)
-		time.Sleep(scanner.rpcInterval)
+		if !scanner.sleep(scanner.rpcInterval) {
+			return 0
+		}
 	}
 }
 
+// loopGetTxReceipt get tx receipt from cache, or from gateway if not cached
 func (scanner *ethSwapScanner) loopGetTxReceipt(txHash common.Hash) (receipt *types.Receipt, err error) {
 	for i := 0; i < 5; i++ { // with retry
-		receipt, err = scanner.client.TransactionReceipt(scanner.ctx, txHash)
+		receipt = scanner.receipts.get(txHash)
+		if receipt == nil {
+			err = scanner.gateways.call("eth_getTransactionReceipt", func(cli Client) (err error) {
+				receipt, err = cli.TransactionReceipt(scanner.ctx, txHash)
+				return err
+			})
+			if err == nil {
+				scanner.receipts.add(receipt)
+			}
+		}
 		if err == nil {
 			if receipt.Status != 1 {
 				log.Debug("tx with wrong receipt status", "txHash", txHash.Hex())
@@ -429,7 +659,9 @@ func (scanner *ethSwapScanner) loopGetTxReceipt(txHash common.Hash) (receipt *ty
 			}
 			return receipt, nil
 		}
-		time.Sleep(scanner.rpcInterval)
+		if !scanner.sleep(scanner.rpcInterval) {
+			break
+		}
 	}
 	return nil, err
 }
@@ -437,64 +669,105 @@ func (scanner *ethSwapScanner) loopGetTxReceipt(txHash common.Hash) (receipt *ty
 func (scanner *ethSwapScanner) loopGetBlock(height uint64) (block *types.Block, err error) {
 	blockNumber := new(big.Int).SetUint64(height)
 	for i := 0; i < 5; i++ { // with retry
-		block, err = scanner.client.BlockByNumber(scanner.ctx, blockNumber)
+		err = scanner.gateways.call("eth_getBlockByNumber", func(cli Client) (err error) {
+			block, err = cli.BlockByNumber(scanner.ctx, blockNumber)
+			return err
+		})
 		if err == nil {
 			return block, nil
 		}
 		log.Warn("get block failed", "height", height, "err", err)
-		time.Sleep(scanner.rpcInterval)
+		if !scanner.sleep(scanner.rpcInterval) {
+			break
+		}
 	}
 	return nil, err
 }
 
-func (scanner *ethSwapScanner) scanBlock(job, height uint64, cache bool) {
+func (scanner *ethSwapScanner) scanBlock(job, height uint64) error {
 	block, err := scanner.loopGetBlock(height)
 	if err != nil {
-		return
+		return err
 	}
-	blockHash := block.Hash().Hex()
-	if cache && cachedBlocks.isScanned(blockHash) {
-		return
+	return scanner.processBlock(job, block)
+}
+
+// loopScanBlock scan block until success, returns false if the scanner is stopping
+func (scanner *ethSwapScanner) loopScanBlock(job, height uint64) bool {
+	for err := scanner.scanBlock(job, height); err != nil; err = scanner.scanBlock(job, height) {
+		log.Warn(fmt.Sprintf("[%v] scan block %v failed, retry later", job, height), "err", err)
+		if !scanner.sleep(scanner.rpcInterval) {
+			return false
+		}
 	}
+	return !scanner.isStopping()
+}
+
+// processBlock scan txs of block and enqueue the found swaps,
+// nothing is enqueued if the internal txs can not be traced.
+func (scanner *ethSwapScanner) processBlock(job uint64, block *types.Block) error {
+	height := block.NumberU64()
+	blockHash := block.Hash().Hex()
 	log.Info(fmt.Sprintf("[%v] scan block %v", job, height), "hash", blockHash, "txs", len(block.Transactions()))
+	jobLabel := fmt.Sprintf("%d", job)
+	metrics.BlocksScanned.WithLabelValues(scanner.chain, jobLabel).Inc()
+	metrics.TxsScanned.WithLabelValues(scanner.chain, jobLabel).Add(float64(len(block.Transactions())))
+
+	start := time.Now()
+	traceSwaps, err := scanner.scanBlockTraces(block)
+	if err != nil {
+		return err
+	}
 
-	go scanner.getLogs(height, height, false)
+	bloom := block.Bloom()
+	scanner.prefetchReceipts(block, &bloom)
+	// logs are got by ranges in log scan mode
+	if !scanner.scanLogs && scanner.bloomMayContainRouterLogs(&bloom) {
+		scanner.getLogs(height, height, false)
+	}
 
-	scanner.processBlockTimers[job].Reset(scanner.processBlockTimeout)
-SCANTXS:
+	// all txs of block are scanned, the found swaps are posted by post workers
 	for i, tx := range block.Transactions() {
-		select {
-		case <-scanner.processBlockTimers[job].C:
-			log.Warn(fmt.Sprintf("[%v] scan block %v timeout", job, height), "hash", blockHash, "txs", len(block.Transactions()))
-			break SCANTXS
-		default:
-			log.Debug(fmt.Sprintf("[%v] scan tx in block %v index %v", job, height, i), "tx", tx.Hash().Hex())
-			scanner.scanTransaction(height, uint64(i), tx)
+		log.Debug(fmt.Sprintf("[%v] scan tx in block %v index %v", job, height, i), "tx", tx.Hash().Hex())
+		for _, swap := range scanner.scanTransaction(height, blockHash, &bloom, uint64(i), tx) {
+			scanner.enqueueSwap(swap)
 		}
 	}
-	if cache {
-		cachedBlocks.addBlock(blockHash)
+	for _, swap := range traceSwaps {
+		scanner.enqueueSwap(swap)
 	}
+	if elapsed := time.Since(start); scanner.processBlockTimeout > 0 && elapsed > scanner.processBlockTimeout {
+		log.Warn(fmt.Sprintf("[%v] scan block %v is slow", job, height), "hash", blockHash, "txs", len(block.Transactions()), "elapsed", elapsed)
+	}
+	return nil
 }
 
-func (scanner *ethSwapScanner) scanTransaction(height, index uint64, tx *types.Transaction) {
+// scanTransaction verify tx with all token configs and return the found swaps,
+// bloom is the logs bloom of block to skip unnecessary receipt fetching, nil if unknown.
+func (scanner *ethSwapScanner) scanTransaction(height uint64, blockHash string, bloom *types.Bloom, index uint64, tx *types.Transaction) (swaps []*swapPost) {
 	if tx.To() == nil {
-		return
+		return nil
 	}
 
 	txHash := tx.Hash().Hex()
 
-	for _, tokenCfg := range params.GetScanConfig().Tokens {
-		verifyErr := scanner.verifyTransaction(height, index, tx, tokenCfg)
+	for _, tokenCfg := range scanner.tokens() {
+		if scanner.isLogsCovered(tokenCfg) {
+			continue
+		}
+		found, verifyErr := scanner.verifyTransaction(height, blockHash, bloom, index, tx, tokenCfg)
 		if verifyErr != nil {
 			log.Debug("verify tx failed", "txHash", txHash, "err", verifyErr)
 		}
+		swaps = append(swaps, found...)
 	}
+	return swaps
 }
 
-func (scanner *ethSwapScanner) checkTxToAddress(tx *types.Transaction, tokenCfg *params.TokenConfig) (receipt *types.Receipt, isAcceptToAddr bool) {
+// isAcceptTx check whether tx should be verified with tokenCfg, and whether verifying it needs receipt
+func (scanner *ethSwapScanner) isAcceptTx(tx *types.Transaction, tokenCfg *params.TokenConfig) (isAcceptToAddr, needReceipt bool) {
 	isAcceptToAddr = scanner.scanReceipt // init
-	needReceipt := scanner.scanReceipt
+	needReceipt = scanner.scanReceipt
 	txtoAddress := tx.To().String()
 
 	var cmpTxTo string
@@ -523,10 +796,30 @@ func (scanner *ethSwapScanner) checkTxToAddress(tx *types.Transaction, tokenCfg
 		}
 	}
 
+	if !isAcceptToAddr {
+		return false, false
+	}
+	return true, needReceipt
+}
+
+// isReceiptRuledOut the block bloom rules out the swap logs in receipt
+func (scanner *ethSwapScanner) isReceiptRuledOut(bloom *types.Bloom, tokenCfg *params.TokenConfig) bool {
+	return !tokenCfg.IsNativeToken() && !scanner.ignoreType(tokenCfg.TxType) &&
+		!scanner.bloomMayContainToken(bloom, tokenCfg)
+}
+
+func (scanner *ethSwapScanner) checkTxToAddress(tx *types.Transaction, bloom *types.Bloom, tokenCfg *params.TokenConfig) (receipt *types.Receipt, isAcceptToAddr bool) {
+	isAcceptToAddr, needReceipt := scanner.isAcceptTx(tx, tokenCfg)
 	if !isAcceptToAddr {
 		return nil, false
 	}
 
+	if needReceipt && scanner.isReceiptRuledOut(bloom, tokenCfg) {
+		log.Debug("skip tx receipt as block bloom not match", "txHash", tx.Hash().Hex())
+		metrics.BloomSkips.WithLabelValues(scanner.chain, "eth_getTransactionReceipt").Inc()
+		return nil, false
+	}
+
 	if needReceipt {
 		r, err := scanner.loopGetTxReceipt(tx.Hash())
 		if err != nil {
@@ -539,11 +832,11 @@ func (scanner *ethSwapScanner) checkTxToAddress(tx *types.Transaction, tokenCfg
 	return receipt, true
 }
 
-func (scanner *ethSwapScanner) verifyTransaction(height, index uint64, tx *types.Transaction, tokenCfg *params.TokenConfig) (verifyErr error) {
-	receipt, isAcceptToAddr := scanner.checkTxToAddress(tx, tokenCfg)
+func (scanner *ethSwapScanner) verifyTransaction(height uint64, blockHash string, bloom *types.Bloom, index uint64, tx *types.Transaction, tokenCfg *params.TokenConfig) (swaps []*swapPost, verifyErr error) {
+	receipt, isAcceptToAddr := scanner.checkTxToAddress(tx, bloom, tokenCfg)
 	if !isAcceptToAddr {
 		log.Debug("verifyTransaction !isAcceptToAddr return", "txHash", tx.Hash().Hex())
-		return nil
+		return nil, nil
 	}
 
 	txHash := tx.Hash().Hex()
@@ -552,20 +845,18 @@ func (scanner *ethSwapScanner) verifyTransaction(height, index uint64, tx *types
 	// router swap
 	case tokenCfg.IsRouterSwapAll():
 		log.Debug("verifyTransaction IsRouterSwapAll", "txHash", txHash)
-		scanner.verifyAndPostRouterSwapTx(tx, receipt, tokenCfg)
-		return nil
+		return scanner.parseRouterSwapTx(height, blockHash, tx, receipt, tokenCfg), nil
 
 	// bridge swapin
 	case tokenCfg.DepositAddress != "":
 		if tokenCfg.IsNativeToken() {
-			scanner.postBridgeSwap(txHash, tokenCfg)
-			return nil
+			return []*swapPost{scanner.newBridgeSwap(txHash, height, blockHash, tokenCfg)}, nil
 		}
 
 		verifyErr = scanner.verifyErc20SwapinTx(tx, receipt, tokenCfg)
 		// swapin my have multiple deposit addresses for different bridges
 		if errors.Is(verifyErr, tokens.ErrTxWithWrongReceiver) {
-			return nil
+			return nil, nil
 		}
 
 	// bridge swapout
@@ -578,15 +869,15 @@ func (scanner *ethSwapScanner) verifyTransaction(height, index uint64, tx *types
 	}
 
 	if verifyErr == nil {
-		if chainIsRSK(chain) {
+		if chainIsRSK(scanner.chain) {
 			hash, err := scanner.getTxHash4RSK(height, index)
 			if err == nil {
 				txHash = hash
 			}
 		}
-		scanner.postBridgeSwap(txHash, tokenCfg)
+		swaps = append(swaps, scanner.newBridgeSwap(txHash, height, blockHash, tokenCfg))
 	}
-	return verifyErr
+	return swaps, verifyErr
 }
 
 func chainIsRSK(chain string) bool {
@@ -618,7 +909,7 @@ func (scanner *ethSwapScanner) getTxHash4RSK(height, index uint64) (string, erro
 	var i int
         for i = 0; i < 30; i++ {
                 reader := bytes.NewReader(bytesData)
-                resp, err := http.Post(scanner.gateway, "application/json", reader)
+                resp, err := http.Post(scanner.gateways.best().url, "application/json", reader)
                 if err != nil {
                         fmt.Println(err.Error())
                         return "", err
@@ -656,7 +947,7 @@ func (scanner *ethSwapScanner) getTxHash4RSK(height, index uint64) (string, erro
 	return basket.Result.Hash, nil
 }
 
-func (scanner *ethSwapScanner) postBridgeSwap(txid string, tokenCfg *params.TokenConfig) {
+func (scanner *ethSwapScanner) newBridgeSwap(txid string, height uint64, blockHash string, tokenCfg *params.TokenConfig) *swapPost {
 	pairID := tokenCfg.PairID
 	var subject, rpcMethod string
 	if tokenCfg.DepositAddress != "" {
@@ -667,16 +958,21 @@ func (scanner *ethSwapScanner) postBridgeSwap(txid string, tokenCfg *params.Toke
 		rpcMethod = "swap.Swapout"
 	}
 	log.Info(subject, "txid", txid, "pairID", pairID)
-	swap := &swapPost{
+	metrics.SwapsDetected.WithLabelValues(scanner.chain, tokenCfg.TxType).Inc()
+	return &swapPost{
 		txid:       txid,
 		pairID:     pairID,
 		rpcMethod:  rpcMethod,
 		swapServer: tokenCfg.SwapServer,
+		chain:      scanner.chain,
+		sinks:      tokenCfg.Sinks,
+
+		blockNumber: height,
+		blockHash:   blockHash,
 	}
-	scanner.postSwapPost(swap)
 }
 
-func (scanner *ethSwapScanner) postRouterSwap(txid string, logIndex int, tokenCfg *params.TokenConfig) {
+func (scanner *ethSwapScanner) newRouterSwap(txid string, logIndex int, height uint64, blockHash string, rlog *types.Log, tokenCfg *params.TokenConfig) *swapPost {
 	chainID := tokenCfg.ChainID
 
 	subject := "post router swap register"
@@ -685,91 +981,149 @@ func (scanner *ethSwapScanner) postRouterSwap(txid string, logIndex int, tokenCf
 		subject = "post gasswap router register"
 		rpcMethod = "swap.RegisterRouterSwap"
 	}
-	log.Info(subject, "swaptype", tokenCfg.TxType, "chainid", chainID, "txid", txid, "logindex", logIndex)
+	detail := scanner.decodeSwapDetail(txid, rlog)
+	if detail != nil {
+		log.Info(subject, "swaptype", tokenCfg.TxType, "chainid", chainID, "txid", txid, "logindex", logIndex,
+			"event", detail.Event, "token", detail.Token, "from", detail.From, "to", detail.To, "amount", detail.Amount, "toChainID", detail.ToChainID)
+	} else {
+		log.Info(subject, "swaptype", tokenCfg.TxType, "chainid", chainID, "txid", txid, "logindex", logIndex)
+	}
+	metrics.SwapsDetected.WithLabelValues(scanner.chain, tokenCfg.TxType).Inc()
 
-	swap := &swapPost{
+	return &swapPost{
 		txid:       txid,
 		chainID:    chainID,
 		logIndex:   fmt.Sprintf("%d", logIndex),
 		rpcMethod:  rpcMethod,
 		swapServer: tokenCfg.SwapServer,
+		chain:      scanner.chain,
+		sinks:      tokenCfg.Sinks,
+
+		blockNumber: height,
+		blockHash:   blockHash,
+		detail:      detail,
+	}
+}
+
+// decodeSwapDetail decode router swap log, returns nil if rlog is nil or decoding fails.
+// The events without fromChainID (eg. router v7 and anycall) are from the scanned chain.
+func (scanner *ethSwapScanner) decodeSwapDetail(txid string, rlog *types.Log) *events.Detail {
+	if rlog == nil {
+		return nil
+	}
+	detail, err := events.Decode(rlog.Topics, rlog.Data)
+	if err != nil {
+		log.Warn("decode router swap log failed", "txid", txid, "logIndex", rlog.Index, "err", err)
+		return nil
+	}
+	if detail.FromChainID == "" && scanner.chainID != nil {
+		detail.FromChainID = scanner.chainID.String()
+	}
+	return detail
+}
+
+func toMgoSwapDetail(detail *events.Detail) *storage.SwapDetail {
+	if detail == nil {
+		return nil
+	}
+	return &storage.SwapDetail{
+		Event:       detail.Event,
+		Token:       detail.Token,
+		From:        detail.From,
+		To:          detail.To,
+		Amount:      detail.Amount,
+		FromChainID: detail.FromChainID,
+		ToChainID:   detail.ToChainID,
+		SwapoutID:   detail.SwapoutID,
+		Path:        detail.Path,
+		TokenIDs:    detail.TokenIDs,
+		Amounts:     detail.Amounts,
+		AppID:       detail.AppID,
+		CallProxy:   detail.CallProxy,
+		CallData:    detail.CallData,
+	}
+}
+
+func fromMgoSwapDetail(detail *storage.SwapDetail) *events.Detail {
+	if detail == nil {
+		return nil
+	}
+	return &events.Detail{
+		Event:       detail.Event,
+		Token:       detail.Token,
+		From:        detail.From,
+		To:          detail.To,
+		Amount:      detail.Amount,
+		FromChainID: detail.FromChainID,
+		ToChainID:   detail.ToChainID,
+		SwapoutID:   detail.SwapoutID,
+		Path:        detail.Path,
+		TokenIDs:    detail.TokenIDs,
+		Amounts:     detail.Amounts,
+		AppID:       detail.AppID,
+		CallProxy:   detail.CallProxy,
+		CallData:    detail.CallData,
 	}
-	scanner.postSwapPost(swap)
 }
 
 func (scanner *ethSwapScanner) postSwapPost(swap *swapPost) {
-	var needCached bool
-	var needPending bool
+	scanner.beginPost()
+	defer scanner.endPost()
+	scanner.transitSwap(swap, storage.SwapPosting, "")
 	for i := 0; i < scanner.rpcRetryCount; i++ {
-		err := rpcPost(swap)
-		if err == nil {
+		err := scanner.rpcPost(swap)
+		if swap.outcome != params.PostTransient || isPostShortCircuited(err) {
 			break
 		}
-		if errors.Is(err, tokens.ErrTxNotFound) ||
-			strings.Contains(err.Error(), httpTimeoutKeywords) ||
-			strings.Contains(err.Error(), errConnectionRefused) ||
-			strings.Contains(err.Error(), errMaximumRequestLimit) {
-			needCached = true
-			needPending = true
-		}
 		time.Sleep(scanner.rpcInterval)
 	}
-	if needCached {
-		log.Warn("cache swap", "swap", swap)
-		scanner.cachedSwapPosts.Add(swap)
+	if swap.outcome == params.PostTransient {
+		scanner.cacheSwap(swap)
+	} else {
+		scanner.transitSwap(swap, swapState(swap.outcome), "")
+	}
+	if scanner.onSwap != nil {
+		scanner.onSwap(swap.toSwap())
 	}
-       if needPending {
-               if mongodbEnable {
-                       //insert mongo post pending
-                       addMongodbSwapPendingPost(swap)
-               }
-       }
-       if !needCached && !needPending {
-               if mongodbEnable {
-                       //insert mongo post
-                       addMongodbSwapPost(swap)
-               }
-       }
 }
 
-func addMongodbSwapPost(swap *swapPost) {
-       ms := &mongodb.MgoSwap{
-               Id:         swap.txid,
-               PairID:     swap.pairID,
-               RpcMethod:  swap.rpcMethod,
-               SwapServer: swap.swapServer,
-                ChainID:    swap.chainID,
-                LogIndex:   swap.logIndex,
-               Chain:      chain,
-               Timestamp:  uint64(time.Now().Unix()),
-       }
-       mongodb.AddSwap(ms, false)
+// cacheSwap keep the unposted swap in outbox and pending swaps to be reposted,
+// the one which reposts it first finishes it in the other, and the outbox dead letter drops it.
+func (scanner *ethSwapScanner) cacheSwap(swap *swapPost) {
+	log.Warn("cache swap", "swap", swap)
+	scanner.addOutboxSwap(swap)
+	scanner.transitSwap(swap, storage.SwapPendingRetry, "")
 }
 
-func addMongodbSwapPendingPost(swap *swapPost) {
-       ms := &mongodb.MgoSwap{
-               Id:         swap.txid,
-               PairID:     swap.pairID,
-               RpcMethod:  swap.rpcMethod,
-               SwapServer: swap.swapServer,
-		ChainID:    swap.chainID,
-		LogIndex:   swap.logIndex,
-               Chain:      chain,
-               Timestamp:  uint64(time.Now().Unix()),
-       }
-       mongodb.AddSwapPending(ms, false)
- }
-
 func (scanner *ethSwapScanner) repostCachedSwaps() {
 	for {
-		scanner.cachedSwapPosts.Do(func(p interface{}) bool {
-			return scanner.repostSwap(p.(*swapPost))
-		})
-		time.Sleep(10 * time.Second)
+		scanner.beginPost()
+		scanner.outbox.Do(scanner.repostOutboxSwap)
+		scanner.endPost()
+		metrics.OutboxDepth.WithLabelValues(scanner.chain, tools.OutboxPending).Set(float64(scanner.outbox.Len()))
+		metrics.OutboxDepth.WithLabelValues(scanner.chain, tools.OutboxDead).Set(float64(len(scanner.outbox.DeadLetters())))
+		if !scanner.sleep(10 * time.Second) {
+			return
+		}
 	}
 }
 
-func rpcPost(swap *swapPost) error {
+// rpcPost post swap to sink, and set the classified outcome of swap
+func (scanner *ethSwapScanner) rpcPost(swap *swapPost) error {
+	s := swap.toSwap()
+	outcome, err := scanner.sink.Post(s)
+	swap.outcome = outcome
+	swap.delivered = s.Delivered
+	metrics.SwapPosts.WithLabelValues(scanner.chain, swap.outcome).Inc()
+	if err != nil {
+		swap.postErr = err.Error()
+	} else {
+		swap.postErr = ""
+	}
+	return err
+}
+
+func doRPCPost(swap *swapPost, timeout int) error {
 	var isRouterSwap bool
 	var args interface{}
 	if swap.pairID != "" {
@@ -785,33 +1139,20 @@ func rpcPost(swap *swapPost) error {
 			"logindex": swap.logIndex,
 		}
 	} else {
+		swap.outcome = params.PostPermanent
 		return fmt.Errorf("wrong swap post item %v, no pairid and logindex", swap)
 	}
 
-	timeout := 300
 	reqID := 666
 	var result interface{}
 	err := client.RPCPostWithTimeoutAndID(&result, timeout, reqID, swap.swapServer, swap.rpcMethod, args)
 
 	if err != nil {
-		if checkSwapPostError(err, args) == nil {
-			log.Warn("post swap failed", "swap", args, "server", swap.swapServer, "err", err)
-			return nil
-		}
-		if isRouterSwap {
-			log.Warn("post router swap failed", "swap", args, "server", swap.swapServer, "err", err)
-			return err
-		}
-		if strings.Contains(err.Error(), bridgeSwapExistKeywords) {
-			err = nil // ignore this kind of error
-			log.Info("post bridge swap already exist", "swap", args)
-		} else {
-			log.Warn("post bridge swap failed", "swap", args, "server", swap.swapServer, "err", err)
-		}
-		return err
+		return checkSwapPostError(swap, err, args)
 	}
 
 	if !isRouterSwap {
+		swap.outcome = params.PostSuccess
 		log.Info("post bridge swap success", "swap", args)
 		return nil
 	}
@@ -827,78 +1168,51 @@ func rpcPost(swap *swapPost) error {
 		b, _ := json.Marshal(&result)
 		json.Unmarshal(b, &resultMap)
 		for _, value := range resultMap {
-			if strings.Contains(value.(string), routerSwapExistResult) ||
-				strings.Contains(value.(string), routerSwapExistResultTmp) {
+			if str, ok := value.(string); ok && classifyPostError(str) == params.PostAlreadyRegistered {
+				swap.outcome = params.PostAlreadyRegistered
 				log.Info("post router swap already exist", "swap", args)
 				return nil
 			}
 		}
+		swap.outcome = params.PostPermanent
 		return err
 	}
-	return checkRouterStatus(status, args)
+	return checkRouterStatus(swap, status, args)
 }
 
-func checkSwapPostError(err error, args interface{}) error {
-	if strings.Contains(err.Error(), routerSwapExistResult) ||
-		strings.Contains(err.Error(), routerSwapExistResultTmp) {
-		log.Info("post swap already exist", "swap", args)
-		return nil
-	}
-	if strings.Contains(err.Error(), swapIsClosedResult) {
-		log.Info("post swap failed, swap is closed", "swap", args)
-		return nil
-	}
-	if strings.Contains(err.Error(), swapTradeNotSupport) {
-		log.Info("post swap failed, swap trade not support", "swap", args)
-		return nil
-	}
-	if strings.Contains(err.Error(), wrongBindAddress) {
-		log.Info("post swap failed, wrong bind address", "swap", args)
+func checkSwapPostError(swap *swapPost, err error, args interface{}) error {
+	swap.outcome = classifyPostError(err.Error())
+	if isPostFinished(swap.outcome) {
+		log.Info("post swap finished", "outcome", swap.outcome, "swap", args, "err", err)
 		return nil
 	}
+	log.Warn("post swap failed", "outcome", swap.outcome, "swap", args, "server", swap.swapServer, "err", err)
 	return err
 }
 
-func checkRouterStatus(status string, args interface{}) error {
+func checkRouterStatus(swap *swapPost, status string, args interface{}) error {
 	if strings.Contains(status, postSwapSuccessResult) {
+		swap.outcome = params.PostSuccess
 		log.Info("post router swap success", "swap", args)
 		return nil
 	}
-	if strings.Contains(status, routerSwapExistResult) ||
-		strings.Contains(status, routerSwapExistResultTmp) {
-		log.Info("post router swap already exist", "swap", args)
-		return nil
-	}
-	if strings.Contains(status, txWithWrongContract) {
-		log.Info("post router swap failed, tx with wrong contract", "swap", args)
-		return nil
-	}
-	if strings.Contains(status, wrongBindAddress) {
-		log.Info("post router swap failed, wrong bind address", "swap", args)
-		return nil
-	}
-	err := errors.New(status)
-	log.Info("post router swap failed", "swap", args, "err", err)
-	return err
+	return checkSwapPostError(swap, errors.New(status), args)
 }
 
-func (scanner *ethSwapScanner) repostSwap(swap *swapPost) bool {
+// repostSwap repost swap with retries, returns the error of the last post if not finished
+func (scanner *ethSwapScanner) repostSwap(swap *swapPost) (bool, error) {
+	var err error
 	for i := 0; i < scanner.rpcRetryCount; i++ {
-		err := rpcPost(swap)
-		if err == nil {
-			return true
-		}
-		switch {
-		case strings.Contains(err.Error(), rpcQueryErrKeywords):
-		case strings.Contains(err.Error(), httpTimeoutKeywords):
-		case strings.Contains(err.Error(), errConnectionRefused):
-		case strings.Contains(err.Error(), errMaximumRequestLimit):
-		default:
-			return false
+		err = scanner.rpcPost(swap)
+		if isPostFinished(swap.outcome) {
+			return true, nil
+		}
+		if swap.outcome != params.PostTransient || isPostShortCircuited(err) {
+			return false, err
 		}
 		time.Sleep(scanner.rpcInterval)
 	}
-	return false
+	return false, err
 }
 
 func (scanner *ethSwapScanner) ignoreType(txType string) bool {
@@ -958,63 +1272,31 @@ func (scanner *ethSwapScanner) verifySwapoutTx(tx *types.Transaction, receipt *t
 	return err
 }
 
-func (scanner *ethSwapScanner) verifyAndPostRouterSwapTx(tx *types.Transaction, receipt *types.Receipt, tokenCfg *params.TokenConfig) {
+func (scanner *ethSwapScanner) parseRouterSwapTx(height uint64, blockHash string, tx *types.Transaction, receipt *types.Receipt, tokenCfg *params.TokenConfig) (swaps []*swapPost) {
 	if scanner.ignoreType(tokenCfg.TxType) {
-		scanner.postRouterSwap(tx.Hash().Hex(), 0, tokenCfg)
-		return
+		return []*swapPost{scanner.newRouterSwap(tx.Hash().Hex(), 0, height, blockHash, nil, tokenCfg)}
 	}
 	if receipt == nil {
-		log.Debug("verifyAndPostRouterSwapTx receipt is nil", "txhash", tx.Hash().Hex())
-		return
+		log.Debug("parseRouterSwapTx receipt is nil", "txhash", tx.Hash().Hex())
+		return nil
 	}
 	for i := 0; i < len(receipt.Logs); i++ {
 		rlog := receipt.Logs[i]
 		if rlog.Removed {
-			log.Debug("verifyAndPostRouterSwapTx removed", "log(i)", i, "txhash", tx.Hash().Hex())
+			log.Debug("parseRouterSwapTx removed", "log(i)", i, "txhash", tx.Hash().Hex())
 			continue
 		}
 		if !strings.EqualFold(rlog.Address.String(), tokenCfg.RouterContract) {
-			log.Debug("verifyAndPostRouterSwapTx", "address", rlog.Address.String(), "txhash", tx.Hash().Hex())
+			log.Debug("parseRouterSwapTx", "address", rlog.Address.String(), "txhash", tx.Hash().Hex())
 			continue
 		}
-		logTopic := rlog.Topics[0].Bytes()
-		switch {
-		case tokenCfg.IsRouterERC20Swap():
-			switch {
-			case bytes.Equal(logTopic, routerAnySwapOutTopic):
-			case bytes.Equal(logTopic, routerAnySwapOutTopic2):
-			case bytes.Equal(logTopic, routerAnySwapTradeTokensForTokensTopic):
-			case bytes.Equal(logTopic, routerAnySwapTradeTokensForNativeTopic):
-			case bytes.Equal(logTopic, routerCrossDexTopic):
-			case bytes.Equal(logTopic, routerAnySwapOutV7Topic):
-			case bytes.Equal(logTopic, routerAnySwapOutAndCallV7Topic):
-				log.Debug("verifyAndPostRouterSwapTx IsRouterERC20Swap", "logTopic", logTopic)
-			default:
-				continue
-			}
-		case tokenCfg.IsRouterNFTSwap():
-			switch {
-			case bytes.Equal(logTopic, routerNFT721SwapOutTopic):
-			case bytes.Equal(logTopic, routerNFT1155SwapOutTopic):
-			case bytes.Equal(logTopic, routerNFT1155SwapOutBatchTopic):
-				log.Debug("verifyAndPostRouterSwapTx IsRouterNFTSwap", "logTopic", logTopic)
-			default:
-				continue
-			}
-		case tokenCfg.IsRouterAnycallSwap():
-			switch {
-			case bytes.Equal(logTopic, routerAnycallTopic):
-			case bytes.Equal(logTopic, routerAnycallTransferSwapOutTopic):
-			case bytes.Equal(logTopic, routerAnycallV6Topic):
-			case bytes.Equal(logTopic, routerAnycallV7Topic):
-			case bytes.Equal(logTopic, routerAnycallV7Topic2):
-				log.Debug("verifyAndPostRouterSwapTx IsRouterAnycallSwap", "logTopic", logTopic)
-			default:
-				continue
-			}
+		if len(rlog.Topics) == 0 || !events.IsTokenEvent(rlog.Topics[0], tokenCfg) {
+			continue
 		}
-		scanner.postRouterSwap(tx.Hash().Hex(), i, tokenCfg)
+		log.Debug("parseRouterSwapTx", "txType", tokenCfg.TxType, "event", events.Lookup(rlog.Topics[0]).Name, "txhash", tx.Hash().Hex())
+		swaps = append(swaps, scanner.newRouterSwap(tx.Hash().Hex(), i, height, blockHash, rlog, tokenCfg))
 	}
+	return swaps
 }
 
 func (scanner *ethSwapScanner) parseErc20SwapinTxInput(input []byte, depositAddress string) error {
@@ -1100,78 +1382,112 @@ func (scanner *ethSwapScanner) parseSwapoutTxLogs(logs []*types.Log, tokenCfg *p
 	return tokens.ErrSwapoutLogNotFound
 }
 
-type cachedSacnnedBlocks struct {
-	capacity  int
-	nextIndex int
-	hashes    []string
-}
-
-var cachedBlocks = &cachedSacnnedBlocks{
-	capacity:  100,
-	nextIndex: 0,
-	hashes:    make([]string, 100),
-}
-
-func (cache *cachedSacnnedBlocks) addBlock(blockHash string) {
-	cache.hashes[cache.nextIndex] = blockHash
-	cache.nextIndex = (cache.nextIndex + 1) % cache.capacity
-}
-
-func (cache *cachedSacnnedBlocks) isScanned(blockHash string) bool {
-	for _, b := range cache.hashes {
-		if b == blockHash {
-			return true
+// InitStorage init storage by config, returns nil if storage is disabled
+func InitStorage() storage.Store {
+	storageConfig := params.GetStorageConfig()
+	if storageConfig == nil {
+		return nil
+	}
+	log.Info("InitStorage", "type", storageConfig.Type)
+	switch storageConfig.Type {
+	case params.StorageMongodb:
+		dbConfig := params.GetMongodbConfig()
+		mongodb.SetOpTimeout(time.Duration(dbConfig.Timeout) * time.Second)
+		return mongodb.NewStore([]string{dbConfig.DBURL}, dbConfig.DBName, dbConfig.UserName, dbConfig.Password)
+	case params.StoragePostgres:
+		store, err := storage.NewPostgresStore(storageConfig.DSN, time.Duration(storageConfig.Timeout)*time.Second)
+		if err != nil {
+			log.Fatal("init postgres storage failed", "err", err)
 		}
+		return store
+	case params.StorageBolt:
+		store, err := storage.NewBoltStore(storageConfig.File)
+		if err != nil {
+			log.Fatal("init bolt storage failed", "file", storageConfig.File, "err", err)
+		}
+		return store
+	default:
+		log.Fatal("unknown storage type", "type", storageConfig.Type)
 	}
-	return false
-}
-
-// InitMongodb init mongodb by config
-func InitMongodb() {
-       log.Info("InitMongodb")
-       dbConfig := params.GetMongodbConfig()
-       mongodb.MongoServerInit([]string{dbConfig.DBURL}, dbConfig.DBName, dbConfig.UserName, dbConfig.Password)
+	return nil
 }
 
 func (scanner *ethSwapScanner) loopSwapPending() {
-       log.Info("start SwapPending loop job")
+       log.Info("start SwapPending loop job", "chain", scanner.chain)
 	offset := 0
        for {
-               sp, err := mongodb.FindAllSwapPending(chain, offset, 10)
+		if count, errc := scanner.store.CountSwaps(scanner.chain, storage.SwapPendingRetry); errc == nil {
+			metrics.PendingSwaps.WithLabelValues(scanner.chain).Set(float64(count))
+		}
+               sp, err := scanner.store.FindSwaps(scanner.chain, storage.SwapPendingRetry, offset, 10)
 		lenPending := len(sp)
                if err != nil || lenPending == 0 {
 			offset = 0
-                       time.Sleep(20 * time.Second)
+			if !scanner.sleep(20 * time.Second) {
+				return
+			}
                        continue
                }
                log.Info("loopSwapPending", "swap", sp, "len", lenPending)
                for i, swap := range sp {
                        log.Info("loopSwapPending", "swap", swap, "index", i)
-                       sp := swapPost{}
-                       sp.txid = swap.Id
-                       sp.pairID = swap.PairID
-                       sp.rpcMethod = swap.RpcMethod
-                       sp.swapServer = swap.SwapServer
-			sp.chainID = swap.ChainID
-			sp.logIndex = swap.LogIndex
-			sp.chain = swap.Chain
-                       ok := scanner.repostSwap(&sp)
-                       if ok == true {
-                               mongodb.UpdateSwapPending(swap)
-                       } else {
-                               r, err := scanner.loopGetTxReceipt(common.HexToHash(swap.Id))
-                               if err != nil || (err == nil && r.Status != uint64(1)) {
-                                       log.Warn("loopSwapPending remove", "status", 0, "txHash", swap.Id)
-                                       mongodb.RemoveSwapPending(swap)
-                                       mongodb.AddSwapDeleted(swap, false)
-                               }
-                       }
+			scanner.beginPost()
+			scanner.retrySwapPending(swap)
+			scanner.endPost()
                }
 		offset += 10
 		if lenPending < 10 {
 			offset = 0
-			time.Sleep(10 * time.Second)
+			if !scanner.sleep(10 * time.Second) {
+				return
+			}
+		}
+		if !scanner.sleep(1 * time.Second) {
+			return
 		}
-               time.Sleep(1 * time.Second)
        }
 }
+
+func newSwapPostFromMgo(swap *storage.Swap) *swapPost {
+	return &swapPost{
+		txid:        swap.TxID,
+		pairID:      swap.PairID,
+		rpcMethod:   swap.RpcMethod,
+		swapServer:  swap.SwapServer,
+		chainID:     swap.ChainID,
+		logIndex:    swap.LogIndex,
+		chain:       swap.Chain,
+		blockNumber: swap.BlockNumber,
+		blockHash:   swap.BlockHash,
+		detail:      fromMgoSwapDetail(swap.Detail),
+		sinks:       swap.Sinks,
+		delivered:   swap.Delivered,
+	}
+}
+
+// retrySwapPending repost pending swap, move it to the state of post outcome,
+// or drop it if the post is still failed and the tx is failed.
+func (scanner *ethSwapScanner) retrySwapPending(swap *storage.Swap) bool {
+	sp := newSwapPostFromMgo(swap)
+	scanner.transitSwap(sp, storage.SwapPosting, "")
+	ok, _ := scanner.repostSwap(sp)
+	swap.PostOutcome = sp.outcome
+	swap.PostError = sp.postErr
+	swap.Delivered = sp.delivered
+	state := swapState(sp.outcome)
+	if state == storage.SwapPendingRetry && scanner.backend == nil {
+		r, err := scanner.loopGetTxReceipt(common.HexToHash(swap.TxID))
+		if err != nil || (err == nil && r.Status != uint64(1)) {
+			log.Warn("loopSwapPending remove", "status", 0, "txHash", swap.TxID)
+			scanner.transitSwap(sp, storage.SwapDropped, txFailedReason)
+			scanner.removeOutboxSwap(sp)
+			return false
+		}
+	}
+	scanner.transitSwap(sp, state, "")
+	if state != storage.SwapPendingRetry {
+		// the outbox is the other retry owner of the swap
+		scanner.removeOutboxSwap(sp)
+	}
+	return ok
+}
@@ -0,0 +1,38 @@
+package scanner
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/scanner/events"
+)
+
+// TestDecodeSwapDetailFromChainID the swaps of events without fromChainID are from the scanned chain
+func TestDecodeSwapDetailFromChainID(t *testing.T) {
+	var v7 *events.Event
+	for _, topic := range events.Topics(params.TxRouterERC20Swap) {
+		if event := events.Lookup(topic); event.Sig == "LogAnySwapOut(bytes32,address,address,string,uint256,uint256)" {
+			v7 = event
+		}
+	}
+	if v7 == nil {
+		t.Fatal("router v7 event is not registered")
+	}
+	data, err := v7.ABI.Inputs.NonIndexed().Pack("receiver", big.NewInt(1000), big.NewInt(56))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rlog := &types.Log{
+		Topics: []common.Hash{v7.Topic, {1}, testRouter.Hash(), testRouter.Hash()},
+		Data:   data,
+	}
+	scanner := &ethSwapScanner{chain: "eth", chainID: big.NewInt(250)}
+	detail := scanner.decodeSwapDetail("0x1", rlog)
+	if detail == nil || detail.FromChainID != "250" || detail.ToChainID != "56" || detail.Amount != "1000" {
+		t.Fatalf("wrong detail %+v", detail)
+	}
+}
@@ -0,0 +1,94 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+)
+
+const shutdownTimeout = 60 * time.Second // max time to wait for in-flight swap posts
+
+var errShutdownTimeout = errors.New("shutdown timeout, some swap posts are not finished")
+
+// handleSignals cancel the root context on SIGINT/SIGTERM,
+// exit immediately if receives the signal again.
+func handleSignals(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	log.Info("receive signal, stop scanner", "signal", sig)
+	cancel()
+	sig = <-sigs
+	log.Warn("receive signal again, exit immediately", "signal", sig)
+	os.Exit(1)
+}
+
+// isStopping the root context is cancelled
+func (scanner *ethSwapScanner) isStopping() bool {
+	return scanner.ctx.Err() != nil
+}
+
+// sleep wait for duration, return false if the scanner is stopping
+func (scanner *ethSwapScanner) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-scanner.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (scanner *ethSwapScanner) beginPost() {
+	atomic.AddInt64(&scanner.inflightPosts, 1)
+}
+
+func (scanner *ethSwapScanner) endPost() {
+	atomic.AddInt64(&scanner.inflightPosts, -1)
+}
+
+// shutdown wait for queued and in-flight swap posts, cache the swaps left in post queue,
+// flush synced block number and close outbox after the post workers exit
+func (scanner *ethSwapScanner) shutdown() (err error) {
+	log.Info("scanner is stopping, wait for in-flight swap posts", "chain", scanner.chain)
+	deadline := time.Now().Add(shutdownTimeout)
+	for atomic.LoadInt64(&scanner.inflightPosts) > 0 {
+		if time.Now().After(deadline) {
+			log.Warn("wait for in-flight swap posts timeout", "posts", atomic.LoadInt64(&scanner.inflightPosts))
+			err = errShutdownTimeout
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	scanner.stopPostWorkers()
+	if count := scanner.unconfirmed.len(); count > 0 && scanner.store == nil {
+		log.Warn("unconfirmed swaps are not kept without storage, rescan their blocks to post them", "chain", scanner.chain, "count", count)
+	}
+
+	if scanner.store != nil && scanner.syncedCount > 0 {
+		saved := scanner.syncedNumberToSave()
+		errf := scanner.store.UpdateSyncedBlockNumber(scanner.chain, saved)
+		if errf != nil {
+			log.Warn("flush synced block number failed", "chain", scanner.chain, "number", saved, "err", errf)
+			err = errf
+		} else {
+			log.Info("flush synced block number success", "chain", scanner.chain, "number", saved)
+			scanner.syncedCount = 0
+		}
+	}
+
+	if scanner.outbox != nil {
+		if errc := scanner.outbox.Close(); errc != nil {
+			log.Warn("close outbox failed", "err", errc)
+		}
+	}
+	log.Info("scanner stopped", "chain", scanner.chain, "err", err)
+	return err
+}
@@ -0,0 +1,87 @@
+package scanner
+
+import (
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/scanner/events"
+)
+
+// Swap detected swap which is delivered to sink
+type Swap struct {
+	TxID        string   `json:"txid"`
+	RPCMethod   string   `json:"rpcMethod"`
+	SwapServer  string   `json:"swapServer"`
+	Chain       string   `json:"chain,omitempty"`
+	PairID      string   `json:"pairID,omitempty"`   // bridge swap
+	ChainID     string   `json:"chainID,omitempty"`  // router swap
+	LogIndex    string   `json:"logIndex,omitempty"` // router swap
+	BlockNumber uint64   `json:"blockNumber,omitempty"`
+	BlockHash   string   `json:"blockHash,omitempty"`
+	Outcome     string   `json:"outcome,omitempty"` // outcome of the last post
+	PostError   string   `json:"postError,omitempty"`
+	Sinks       []string `json:"sinks,omitempty"`     // sink names of token config
+	Delivered   []string `json:"delivered,omitempty"` // sinks which have finished the swap
+
+	Detail *events.Detail `json:"detail,omitempty"`
+}
+
+// Sink delivers the detected swaps.
+// The returned outcome is one of the `params.Post*` outcomes,
+// the swaps with transient outcome are retried and kept in outbox.
+type Sink interface {
+	Post(swap *Swap) (outcome string, err error)
+}
+
+// SwapServerSink post swaps to the swap server of token config by json-rpc.
+// Posts are short-circuited as transient if the circuit of swap server is open
+// or its rate limit is exceeded, see `params.SwapServerConfig`.
+type SwapServerSink struct{}
+
+// Post implements Sink
+func (SwapServerSink) Post(swap *Swap) (string, error) {
+	guard := getSwapServerGuard(swap.SwapServer)
+	if err := guard.acquire(); err != nil {
+		return params.PostTransient, err
+	}
+	sp := newSwapPostFromSwap(swap)
+	err := doRPCPost(sp, guard.cfg.PostTimeout)
+	guard.release(sp.outcome)
+	return sp.outcome, err
+}
+
+func (swap *swapPost) toSwap() *Swap {
+	return &Swap{
+		TxID:        swap.txid,
+		RPCMethod:   swap.rpcMethod,
+		SwapServer:  swap.swapServer,
+		Chain:       swap.chain,
+		PairID:      swap.pairID,
+		ChainID:     swap.chainID,
+		LogIndex:    swap.logIndex,
+		BlockNumber: swap.blockNumber,
+		BlockHash:   swap.blockHash,
+		Outcome:     swap.outcome,
+		PostError:   swap.postErr,
+		Sinks:       swap.sinks,
+		Delivered:   swap.delivered,
+		Detail:      swap.detail,
+	}
+}
+
+func newSwapPostFromSwap(swap *Swap) *swapPost {
+	return &swapPost{
+		txid:        swap.TxID,
+		rpcMethod:   swap.RPCMethod,
+		swapServer:  swap.SwapServer,
+		chain:       swap.Chain,
+		pairID:      swap.PairID,
+		chainID:     swap.ChainID,
+		logIndex:    swap.LogIndex,
+		blockNumber: swap.BlockNumber,
+		blockHash:   swap.BlockHash,
+		outcome:     swap.Outcome,
+		postErr:     swap.PostError,
+		sinks:       swap.Sinks,
+		delivered:   swap.Delivered,
+		detail:      swap.Detail,
+	}
+}
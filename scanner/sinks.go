@@ -0,0 +1,283 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+const (
+	defaultSinkTimeout = 10 * time.Second
+
+	webhookSignatureHeader = "X-Gethscan-Signature" // 'sha256=<hex of hmac-sha256(secret, "<timestamp>.<body>")>'
+	webhookTimestampHeader = "X-Gethscan-Timestamp"
+)
+
+// TokenSinks deliver swaps to the sinks chosen by token configs.
+// A swap is finished only if all of its sinks have finished it,
+// the finished sinks are recorded in `Swap.Delivered` and skipped when reposting,
+// so every sink receives a swap at least once.
+type TokenSinks struct {
+	sinks map[string]Sink
+}
+
+// NewTokenSinks new token sinks with the builtin swap server sink and the configed sinks
+func NewTokenSinks(configs []*params.SinkConfig) (*TokenSinks, error) {
+	s := &TokenSinks{
+		sinks: map[string]Sink{params.DefaultSinkName: SwapServerSink{}},
+	}
+	for _, cfg := range configs {
+		sink, err := newSink(cfg)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("new sink '%v' failed: %w", cfg.Name, err)
+		}
+		s.sinks[strings.ToLower(cfg.Name)] = sink
+		log.Info("new swap sink success", "name", cfg.Name, "type", cfg.Type)
+	}
+	return s, nil
+}
+
+func newSink(cfg *params.SinkConfig) (Sink, error) {
+	timeout := defaultSinkTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	switch cfg.Type {
+	case params.SinkSwapServer:
+		return SwapServerSink{}, nil
+	case params.SinkWebhook:
+		return newWebhookSink(cfg.URL, cfg.Secret, timeout), nil
+	case params.SinkNDJSON:
+		return newNDJSONSink(cfg.File)
+	case params.SinkNATS:
+		return newNATSSink(cfg.URL, cfg.Subject, timeout)
+	default:
+		return nil, fmt.Errorf("unknown sink type '%v'", cfg.Type)
+	}
+}
+
+// Post implements Sink
+func (s *TokenSinks) Post(swap *Swap) (outcome string, err error) {
+	names := swap.Sinks
+	if len(names) == 0 {
+		names = []string{params.DefaultSinkName}
+	}
+	var errs sinkErrors
+	for _, name := range names {
+		if isSinkDelivered(swap, name) {
+			continue
+		}
+		sink := s.sinks[strings.ToLower(name)]
+		if sink == nil {
+			// the sink is removed from config, retry after restart with it
+			outcome = worseOutcome(outcome, params.PostTransient)
+			errs = append(errs, fmt.Errorf("sink %v not found", name))
+			continue
+		}
+		res, errp := sink.Post(swap)
+		if isPostFinished(res) {
+			swap.Delivered = append(swap.Delivered, name)
+		}
+		outcome = worseOutcome(outcome, res)
+		if errp != nil {
+			errs = append(errs, fmt.Errorf("sink %v: %w", name, errp))
+		}
+	}
+	if outcome == "" {
+		outcome = params.PostSuccess // all sinks are delivered before
+	}
+	if len(errs) != 0 {
+		err = errs
+	}
+	return outcome, err
+}
+
+// sinkErrors errors of sinks, `errors.Is` matches any of them
+type sinkErrors []error
+
+func (errs sinkErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is supports errors.Is
+func (errs sinkErrors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close close the sinks which hold files or connections
+func (s *TokenSinks) Close() (err error) {
+	for name, sink := range s.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if errc := closer.Close(); errc != nil {
+				log.Warn("close swap sink failed", "name", name, "err", errc)
+				err = errc
+			}
+		}
+	}
+	return err
+}
+
+func isSinkDelivered(swap *Swap, name string) bool {
+	for _, delivered := range swap.Delivered {
+		if strings.EqualFold(delivered, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// worseOutcome transient is the worst as the swap will be retried,
+// then the unfinished outcomes, then the finished outcomes.
+func worseOutcome(current, outcome string) string {
+	switch {
+	case current == "":
+		return outcome
+	case current == params.PostTransient || outcome == params.PostTransient:
+		return params.PostTransient
+	case !isPostFinished(current):
+		return current
+	case !isPostFinished(outcome):
+		return outcome
+	case current == params.PostSuccess:
+		return outcome
+	default:
+		return current
+	}
+}
+
+// marshalSinkSwap json of swap delivered to the external sinks,
+// the posting states (outcome, error, sinks and delivered) are internal and stripped.
+func marshalSinkSwap(swap *Swap) ([]byte, error) {
+	msg := *swap
+	msg.Outcome, msg.PostError = "", ""
+	msg.Sinks, msg.Delivered = nil, nil
+	return json.Marshal(&msg)
+}
+
+// webhookSink post swap json to http url.
+// 2xx responses are success, 4xx responses (except 408 and 429) are permanent.
+type webhookSink struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func newWebhookSink(url, secret string, timeout time.Duration) *webhookSink {
+	return &webhookSink{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Post implements Sink
+func (s *webhookSink) Post(swap *Swap) (string, error) {
+	body, err := marshalSinkSwap(swap)
+	if err != nil {
+		return params.PostPermanent, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return params.PostPermanent, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	if len(s.secret) != 0 {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(s.secret, timestamp, body))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Warn("post swap to webhook failed", "url", s.url, "txid", swap.TxID, "err", err)
+		return params.PostTransient, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		log.Info("post swap to webhook success", "url", s.url, "txid", swap.TxID, "logIndex", swap.LogIndex)
+		return params.PostSuccess, nil
+	case code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests:
+		return params.PostPermanent, fmt.Errorf("webhook response status %v", resp.Status)
+	default:
+		return params.PostTransient, fmt.Errorf("webhook response status %v", resp.Status)
+	}
+}
+
+// signWebhook hmac-sha256 of '<timestamp>.<body>',
+// receivers can reject replayed requests by the timestamp header.
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(timestamp))
+	_, _ = mac.Write([]byte("."))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ndjsonSink write one swap json per line
+type ndjsonSink struct {
+	lock sync.Mutex
+	w    io.Writer
+	file *os.File // nil for stdout
+}
+
+func newNDJSONSink(file string) (*ndjsonSink, error) {
+	if file == "-" {
+		return &ndjsonSink{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &ndjsonSink{w: f, file: f}, nil
+}
+
+// Post implements Sink
+func (s *ndjsonSink) Post(swap *Swap) (string, error) {
+	line, err := marshalSinkSwap(swap)
+	if err != nil {
+		return params.PostPermanent, err
+	}
+	line = append(line, '\n')
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err = s.w.Write(line); err != nil {
+		return params.PostTransient, err
+	}
+	return params.PostSuccess, nil
+}
+
+// Close implements io.Closer
+func (s *ndjsonSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
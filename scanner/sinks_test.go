@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+func TestWebhookSinkPost(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		want := "sha256=" + signWebhook([]byte("secret"), r.Header.Get(webhookTimestampHeader), body)
+		if r.Header.Get(webhookSignatureHeader) != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bodies <- body
+	}))
+	defer server.Close()
+
+	sink := newWebhookSink(server.URL, "secret", time.Second)
+	if outcome, err := sink.Post(newTestSinkSwap()); outcome != params.PostSuccess || err != nil {
+		t.Fatalf("post got outcome %v, err %v", outcome, err)
+	}
+	checkSinkPayload(t, <-bodies)
+
+	sink = newWebhookSink(server.URL, "wrong", time.Second)
+	if outcome, _ := sink.Post(newTestSinkSwap()); outcome != params.PostPermanent {
+		t.Fatalf("post with wrong signature got outcome %v", outcome)
+	}
+}
+
+func TestNDJSONSinkPost(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "swaps.ndjson")
+	sink, err := newNDJSONSink(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if outcome, err := sink.Post(newTestSinkSwap()); outcome != params.PostSuccess || err != nil {
+			t.Fatalf("post got outcome %v, err %v", outcome, err)
+		}
+	}
+	_ = sink.Close()
+	data, _ := ioutil.ReadFile(file)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %v lines, want 2", len(lines))
+	}
+	for _, line := range lines {
+		checkSinkPayload(t, []byte(line))
+	}
+}
@@ -0,0 +1,231 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+	"github.com/jowenshaw/gethclient/types/ethereum"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+var errStubNotSupported = errors.New("not supported by stub client")
+
+// stubClient in memory chain of tests, it implements Client
+type stubClient struct {
+	lock     sync.Mutex
+	chainID  *big.Int
+	headers  map[uint64]*types.Header
+	receipts map[common.Hash]*types.Receipt
+	txs      map[common.Hash]*types.Transaction
+	logs     []types.Log
+	errs     map[string]error // errors of rpc methods
+	calls    map[string]int
+
+	// filterLogs reject getLogs of range, eg. too many results
+	filterLogs func(from, to uint64) error
+}
+
+func newStubClient() *stubClient {
+	return &stubClient{
+		chainID:  big.NewInt(1),
+		headers:  make(map[uint64]*types.Header),
+		receipts: make(map[common.Hash]*types.Receipt),
+		txs:      make(map[common.Hash]*types.Transaction),
+		errs:     make(map[string]error),
+		calls:    make(map[string]int),
+	}
+}
+
+// addHeader add canonical header of height, returns its hash
+func (c *stubClient) addHeader(height uint64, parent common.Hash, extra byte) common.Hash {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	header := &types.Header{
+		Number:     new(big.Int).SetUint64(height),
+		ParentHash: parent,
+		Difficulty: big.NewInt(1),
+		Extra:      []byte{extra},
+	}
+	c.headers[height] = header
+	return header.Hash()
+}
+
+// addLog add log of tx and the receipt of tx
+func (c *stubClient) addLog(rlog types.Log) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.logs = append(c.logs, rlog)
+	receipt := c.receipts[rlog.TxHash]
+	if receipt == nil {
+		receipt = &types.Receipt{TxHash: rlog.TxHash, Status: 1, BlockNumber: new(big.Int).SetUint64(rlog.BlockNumber), BlockHash: rlog.BlockHash}
+		c.receipts[rlog.TxHash] = receipt
+	}
+	l := rlog
+	receipt.Logs = append(receipt.Logs, &l)
+}
+
+// addTx add tx included in block of height and hash, returns its hash
+func (c *stubClient) addTx(tx *types.Transaction, height uint64, blockHash common.Hash) common.Hash {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.txs[tx.Hash()] = tx
+	c.receipts[tx.Hash()] = &types.Receipt{
+		TxHash:      tx.Hash(),
+		Status:      1,
+		BlockNumber: new(big.Int).SetUint64(height),
+		BlockHash:   blockHash,
+	}
+	return tx.Hash()
+}
+
+func (c *stubClient) call(method string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls[method]++
+	return c.errs[method]
+}
+
+func (c *stubClient) callCount(method string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.calls[method]
+}
+
+func (c *stubClient) setError(method string, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.errs[method] = err
+}
+
+func (c *stubClient) ChainID(ctx context.Context) (*big.Int, error) {
+	if err := c.call("eth_chainId"); err != nil {
+		return nil, err
+	}
+	return c.chainID, nil
+}
+
+func (c *stubClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if err := c.call("eth_getBlockByNumber"); err != nil {
+		return nil, err
+	}
+	return c.header(number)
+}
+
+func (c *stubClient) header(number *big.Int) (*types.Header, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if number == nil {
+		var latest *types.Header
+		for _, header := range c.headers {
+			if latest == nil || header.Number.Cmp(latest.Number) > 0 {
+				latest = header
+			}
+		}
+		if latest == nil {
+			return nil, ethereum.NotFound
+		}
+		return latest, nil
+	}
+	header := c.headers[number.Uint64()]
+	if header == nil {
+		return nil, ethereum.NotFound
+	}
+	return header, nil
+}
+
+// BlockByNumber errors are set by the method name 'eth_getBlockByNumber:full'
+func (c *stubClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if err := c.call("eth_getBlockByNumber:full"); err != nil {
+		return nil, err
+	}
+	header, err := c.header(number)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewBlockWithHeader(header), nil
+}
+
+func (c *stubClient) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	if err := c.call("eth_getTransactionByHash"); err != nil {
+		return nil, false, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tx := c.txs[hash]
+	if tx == nil {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, false, nil
+}
+
+func (c *stubClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if err := c.call("eth_getTransactionReceipt"); err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	receipt := c.receipts[txHash]
+	if receipt == nil {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func (c *stubClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if err := c.call("eth_getLogs"); err != nil {
+		return nil, err
+	}
+	from, to := q.FromBlock.Uint64(), q.ToBlock.Uint64()
+	if c.filterLogs != nil {
+		if err := c.filterLogs(from, to); err != nil {
+			return nil, err
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var logs []types.Log
+	for _, l := range c.logs {
+		if l.BlockNumber < from || l.BlockNumber > to {
+			continue
+		}
+		for _, address := range q.Addresses {
+			if l.Address == address {
+				logs = append(logs, l)
+				break
+			}
+		}
+	}
+	return logs, nil
+}
+
+func (c *stubClient) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	return nil, errStubNotSupported
+}
+
+// newStubScanner scanner of the stub chain
+func newStubScanner(t interface {
+	Fatal(args ...interface{})
+}, client *stubClient) *ethSwapScanner {
+	gateways, err := newClientPool(context.Background(), "eth", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ethSwapScanner{
+		chain:         "eth",
+		chainCfg:      &params.ChainConfig{BlockChain: &params.BlockChainConfig{}},
+		ctx:           context.Background(),
+		gateways:      gateways,
+		chainID:       gateways.chainID,
+		unconfirmed:   newUnconfirmedSwaps(),
+		posts:         newPostQueue(nil),
+		headers:       newHeaderChain(0),
+		receipts:      newReceiptCache(0),
+		rpcRetryCount: 3,
+		stableHeight:  5,
+	}
+}
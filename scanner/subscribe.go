@@ -13,52 +13,25 @@ import (
 	"github.com/jowenshaw/gethclient/common"
         "github.com/jowenshaw/gethclient/types"
 	"github.com/anyswap/CrossChain-Bridge/log"
-)
-
-var (
-	// router
-	RouterAnySwapOutTopic                  = common.HexToHash("0x97116cf6cd4f6412bb47914d6db18da9e16ab2142f543b86e207c24fbd16b23a")
-	RouterAnySwapOutTopic2                 = common.HexToHash("0x409e0ad946b19f77602d6cf11d59e1796ddaa4828159a0b4fb7fa2ff6b161b79")
-	RouterAnySwapTradeTokensForTokensTopic = common.HexToHash("0xfea6abdf4fd32f20966dff7619354cd82cd43dc78a3bee479f04c74dbfc585b3")
-	RouterAnySwapTradeTokensForNativeTopic = common.HexToHash("0x278277e0209c347189add7bd92411973b5f6b8644f7ac62ea1be984ce993f8f4")
-        RouterCrossDexTopic                    = common.HexToHash("0x8e7e5695fff09074d4c7d6c71615fd382427677f75f460c522357233f3bd3ec3")
-
-	RouterAnySwapOutV7Topic = common.HexToHash("0x0d969ae475ff6fcaf0dcfa760d4d8607244e8d95e9bf426f8d5d69f9a3e525af")
-	RouterAnySwapOutAndCallV7Topic = common.HexToHash("0x968608314ec29f6fd1a9f6ef9e96247a4da1a683917569706e2d2b60ca7c0a6d")
 
-	// router nft
-	RouterNFT721SwapOutTopic       = common.HexToHash("0x0d45b0b9f5add3e1bb841982f1fa9303628b0b619b000cb1f9f1c3903329a4c7")
-	RouterNFT1155SwapOutTopic      = common.HexToHash("0x5058b8684cf36ffd9f66bc623fbc617a44dd65cf2273306d03d3104af0995cb0")
-	RouterNFT1155SwapOutBatchTopic = common.HexToHash("0xaa428a5ab688b49b415401782c170d216b33b15711d30cf69482f570eca8db38")
-
-	// anycall
-	RouterAnycallTopic          = common.HexToHash("0x9ca1de98ebed0a9c38ace93d3ca529edacbbe199cf1b6f0f416ae9b724d4a81c")
-	RouterAnycallTransferSwapOutTopic = common.HexToHash("0xcaac11c45e5fdb5c513e20ac229a3f9f99143580b5eb08d0fecbdd5ae8c81ef5")
-
-	RouterAnycallV6Topic        = common.HexToHash("0xa17aef042e1a5dd2b8e68f0d0d92f9a6a0b35dc25be1d12c0cb3135bfd8951c9")
-	RouterAnycallV7Topic = common.HexToHash("0x17dac14bf31c4070ebb2dc182fc25ae5df58f14162a7f24a65b103e22385af0d")
-	RouterAnycallV7Topic2 = common.HexToHash("0x36850177870d3e3dca07a29dcdc3994356392b81c60f537c1696468b1a01e61d")
+	"github.com/weijun-sh/gethscan/scanner/events"
 )
 
 var (
-	tokenSwap map[string]*params.TokenConfig = make(map[string]*params.TokenConfig, 0)
         prefixSwapRouter = "router"
         prefixSwapRouterNFT = "routernft"
 	prefixSwapRouterAnycall = "routeranycall"
-
-        fqSwapRouter        ethereum.FilterQuery
-        fqSwapRouterNFT     ethereum.FilterQuery
-	fqSwapRouterAnycall ethereum.FilterQuery
-
-	filterLogsRouterChan        chan types.Log
-        filterLogsRouterNFTChan     chan types.Log
-	filterLogsRouterAnycallChan chan types.Log
 )
 
 func (scanner *ethSwapScanner) subscribeSwap(fq ethereum.FilterQuery, ch chan types.Log) {
-        ctx := context.Background()
+        ctx := scanner.ctx
         sub := scanner.LoopSubscribe(ctx, fq, ch)
-        defer sub.Unsubscribe()
+        if sub == nil {
+                return
+        }
+        defer func() {
+                sub.Unsubscribe()
+        }()
 
         for {// check
                 select {
@@ -66,109 +39,118 @@ func (scanner *ethSwapScanner) subscribeSwap(fq ethereum.FilterQuery, ch chan ty
                         log.Info("Subscribe swap error restart", "error", err)
                         sub.Unsubscribe()
                         sub = scanner.LoopSubscribe(ctx, fq, ch)
+                        if sub == nil {
+                                return
+                        }
+                case <-ctx.Done():
+                        return
                 }
         }
 }
 
 func (scanner *ethSwapScanner) subscribe() {
         log.Info("start subscribe")
-	if len(fqSwapRouter.Addresses) > 0 {
-                go scanner.subscribeSwap(fqSwapRouter, filterLogsRouterChan)
+	if len(scanner.fqSwapRouter.Addresses) > 0 {
+                go scanner.subscribeSwap(scanner.fqSwapRouter, scanner.filterLogsRouterChan)
         }
-        if len(fqSwapRouterNFT.Addresses) > 0 {
-                go scanner.subscribeSwap(fqSwapRouterNFT, filterLogsRouterNFTChan)
+        if len(scanner.fqSwapRouterNFT.Addresses) > 0 {
+                go scanner.subscribeSwap(scanner.fqSwapRouterNFT, scanner.filterLogsRouterNFTChan)
         }
-        if len(fqSwapRouterAnycall.Addresses) > 0 {
-                scanner.subscribeSwap(fqSwapRouterAnycall, filterLogsRouterAnycallChan)
+        if len(scanner.fqSwapRouterAnycall.Addresses) > 0 {
+                scanner.subscribeSwap(scanner.fqSwapRouterAnycall, scanner.filterLogsRouterAnycallChan)
         }
 }
 
 func (scanner *ethSwapScanner) LoopSubscribe(ctx context.Context, fq ethereum.FilterQuery, ch chan types.Log) ethereum.Subscription {
         for {
-                sub, err := scanner.client.SubscribeFilterLogs(ctx, fq, ch)
+                var sub ethereum.Subscription
+                err := scanner.gateways.call("eth_subscribe", func(cli Client) (err error) {
+                        sub, err = cli.SubscribeFilterLogs(ctx, fq, ch)
+                        return err
+                })
                 if err == nil {
                         return sub
                 }
                 log.Info("Subscribe logs failed, retry in 1 second", "error", err)
-                time.Sleep(time.Second * 1)
+                if !scanner.sleep(time.Second * 1) {
+                        return nil
+                }
         }
 }
 
 func (scanner *ethSwapScanner) initGetlogs() {
-        initFilterChan()
-        defer closeFilterChain()
-
-        initFilerLogs()
+        scanner.initFilterChan()
+        scanner.initFilerLogs()
 
 	go scanner.loopFilterChain()
-	select {}
 }
 
-func initFilterChan() {
-        filterLogsRouterChan = make(chan types.Log, 128)
-        filterLogsRouterNFTChan = make(chan types.Log, 128)
-        filterLogsRouterAnycallChan = make(chan types.Log, 128)
+func (scanner *ethSwapScanner) initFilterChan() {
+        scanner.filterLogsRouterChan = make(chan types.Log, 128)
+        scanner.filterLogsRouterNFTChan = make(chan types.Log, 128)
+        scanner.filterLogsRouterAnycallChan = make(chan types.Log, 128)
 }
 
-func closeFilterChain() {
-        close(filterLogsRouterChan)
-        close(filterLogsRouterNFTChan)
-        close(filterLogsRouterAnycallChan)
+func (scanner *ethSwapScanner) closeFilterChain() {
+        close(scanner.filterLogsRouterChan)
+        close(scanner.filterLogsRouterNFTChan)
+        close(scanner.filterLogsRouterAnycallChan)
 }
 
-func initFilerLogs() {
-        tokenSwap = make(map[string]*params.TokenConfig, 0)
+func (scanner *ethSwapScanner) initFilerLogs() {
+        tokenSwap := make(map[string]*params.TokenConfig, 0)
 	var tokenRouterAddresses = make([]common.Address, 0)
         var tokenRouterAnycallAddresses = make([]common.Address, 0)
         var tokenRouterNFTAddresses = make([]common.Address, 0)
-        tokens := params.GetScanConfig().Tokens
+        tokens := scanner.tokens()
         for _, tokenCfg := range tokens {
                 switch {
                 // router swap
                 case tokenCfg.IsRouterSwap():
 			log.Debug("initFilerLogs", "IsRouterSwap", tokenCfg.RouterContract)
                         key := strings.ToLower(fmt.Sprintf("%v-%v", prefixSwapRouter, tokenCfg.RouterContract))
-                        addTokenSwap(key, tokenCfg)
+                        addTokenSwap(tokenSwap, key, tokenCfg)
                         tokenRouterAddresses = append(tokenRouterAddresses, common.HexToAddress(tokenCfg.RouterContract))
 
                 // router NFT
                 case tokenCfg.IsRouterNFTSwap():
 			log.Debug("initFilerLogs", "IsRouterNFTSwap", tokenCfg.RouterContract)
                         key := strings.ToLower(fmt.Sprintf("%v-%v", prefixSwapRouterNFT, tokenCfg.RouterContract))
-                        addTokenSwap(key, tokenCfg)
+                        addTokenSwap(tokenSwap, key, tokenCfg)
                         tokenRouterNFTAddresses = append(tokenRouterNFTAddresses, common.HexToAddress(tokenCfg.RouterContract))
                 // router anycall swap
                 case tokenCfg.IsRouterAnycallSwap():
 			log.Debug("initFilerLogs", "IsRouterAnycallSwap", tokenCfg.RouterContract)
                         key := strings.ToLower(fmt.Sprintf("%v-%v", prefixSwapRouterAnycall, common.HexToAddress(tokenCfg.RouterContract)))
-                        addTokenSwap(key, tokenCfg)
+                        addTokenSwap(tokenSwap, key, tokenCfg)
                         tokenRouterAnycallAddresses = append(tokenRouterAnycallAddresses, common.HexToAddress(tokenCfg.RouterContract))
 		}
         }
         //router anycall
         if len(tokenRouterAnycallAddresses) > 0 {
                 topicsAnycall := make([][]common.Hash, 0)
-                topicsAnycall = append(topicsAnycall, []common.Hash{RouterAnycallTopic, RouterAnycallV6Topic, RouterAnycallTransferSwapOutTopic, RouterAnycallV7Topic, RouterAnycallV7Topic2})
-                fqSwapRouterAnycall.Addresses = tokenRouterAnycallAddresses
-                fqSwapRouterAnycall.Topics = topicsAnycall
+                topicsAnycall = append(topicsAnycall, events.Topics(params.TxRouterAnycallSwap))
+                scanner.fqSwapRouterAnycall.Addresses = tokenRouterAnycallAddresses
+                scanner.fqSwapRouterAnycall.Topics = topicsAnycall
         }
 	//router nft
         if len(tokenRouterNFTAddresses) > 0 {
                 topicsNFT := make([][]common.Hash, 0)
-                topicsNFT = append(topicsNFT, []common.Hash{RouterNFT721SwapOutTopic, RouterNFT1155SwapOutTopic, RouterNFT1155SwapOutBatchTopic})
-                fqSwapRouterNFT.Addresses = tokenRouterNFTAddresses
-                fqSwapRouterNFT.Topics = topicsNFT
+                topicsNFT = append(topicsNFT, events.Topics(params.TxRouterNFTSwap))
+                scanner.fqSwapRouterNFT.Addresses = tokenRouterNFTAddresses
+                scanner.fqSwapRouterNFT.Topics = topicsNFT
         }
         //router
         if len(tokenRouterAddresses) > 0 {
                 topicsRouter := make([][]common.Hash, 0)
-                topicsRouter = append(topicsRouter, []common.Hash{RouterAnySwapOutTopic, RouterAnySwapOutTopic2, RouterAnySwapTradeTokensForTokensTopic, RouterAnySwapTradeTokensForNativeTopic, RouterCrossDexTopic, RouterAnySwapOutV7Topic, RouterAnySwapOutAndCallV7Topic})
-                fqSwapRouter.Addresses = tokenRouterAddresses
-                fqSwapRouter.Topics = topicsRouter
+                topicsRouter = append(topicsRouter, events.Topics(params.TxRouterERC20Swap))
+                scanner.fqSwapRouter.Addresses = tokenRouterAddresses
+                scanner.fqSwapRouter.Topics = topicsRouter
         }
+        scanner.tokenSwap = tokenSwap
 }
 
-func addTokenSwap(key string, tokenCfg *params.TokenConfig) {
+func addTokenSwap(tokenSwap map[string]*params.TokenConfig, key string, tokenCfg *params.TokenConfig) {
         if tokenSwap[key] != nil {
                 log.Fatal("addTokenSwap duplicate", "key", key)
         }
@@ -181,77 +163,112 @@ func addTokenSwap(key string, tokenCfg *params.TokenConfig) {
 func (scanner *ethSwapScanner) loopFilterChain() {
         for {
                 select {
-                case rlog := <-filterLogsRouterChan:
-                        txhash := rlog.TxHash.String()
-                        logIndex := scanner.getIndexPosition(rlog.TxHash, rlog.Index)
-                        log.Debug("filterLogsRouterChan", "txhash", txhash, "logIndex", logIndex)
-                        key := strings.ToLower(fmt.Sprintf("%v-%v", prefixSwapRouter, rlog.Address))
-                        token := tokenSwap[key]
-                        if token == nil {
-                                log.Debug("filterLogsRouterChan", "txhash", txhash, "key not config", key)
-                                continue
-                        }
-                        scanner.postRouterSwap(txhash, logIndex, token)
+                case <-scanner.ctx.Done():
+                        return
 
-                case rlog := <-filterLogsRouterNFTChan:
-                        txhash := rlog.TxHash.String()
-                        logIndex := scanner.getIndexPosition(rlog.TxHash, rlog.Index)
-                        log.Debug("filterLogsRouterNFTChan", "txhash", txhash, "logIndex", logIndex)
-                        key := strings.ToLower(fmt.Sprintf("%v-%v", prefixSwapRouterNFT, rlog.Address))
-                        token := tokenSwap[key]
-                        if token == nil {
-                                log.Debug("filterLogsRouterNFTChan", "txhash", txhash, "key not config", key)
-                                continue
-                        }
-                        scanner.postRouterSwap(txhash, logIndex, token)
+                case rlog := <-scanner.filterLogsRouterChan:
+                        scanner.retryRouterLog(prefixSwapRouter, &rlog)
 
-                case rlog := <-filterLogsRouterAnycallChan:
-                        txhash := rlog.TxHash.String()
-                        logIndex := scanner.getIndexPosition(rlog.TxHash, rlog.Index)
-                        log.Info("filterLogsRouterAnycallChan", "txhash", txhash, "logIndex", logIndex)
-                        key := strings.ToLower(fmt.Sprintf("%v-%v", prefixSwapRouterAnycall, rlog.Address))
-                        token := tokenSwap[key]
-                        if token == nil {
-                                log.Debug("filterLogsRouterAnycallChan", "txhash", txhash, "key not config", key)
-                                continue
-                        }
-                        scanner.postRouterSwap(txhash, logIndex, token)
+                case rlog := <-scanner.filterLogsRouterNFTChan:
+                        scanner.retryRouterLog(prefixSwapRouterNFT, &rlog)
+
+                case rlog := <-scanner.filterLogsRouterAnycallChan:
+                        scanner.retryRouterLog(prefixSwapRouterAnycall, &rlog)
                 }
         }
 }
 
-func (scanner *ethSwapScanner) getIndexPosition(txhash common.Hash, index uint) int {
-        r, err := scanner.loopGetTxReceipt(txhash)
-        if err != nil {
-                log.Warn("get tx receipt error", "txHash", txhash, "err", err)
-                return 0
-        }
-        for i, log := range r.Logs {
-                if log.Index == index {
-                        return i
-                }
-        }
-        return 0
+// handleRouterLog enqueue the router swap of log, the swap is not enqueued if error is returned
+func (scanner *ethSwapScanner) handleRouterLog(prefix string, rlog *types.Log) error {
+	swap, err := scanner.routerSwapOfLog(prefix, rlog)
+	if swap != nil {
+		scanner.enqueueSwap(swap)
+	}
+	return err
+}
+
+// retryRouterLog handle router log until success or the scanner is stopping
+func (scanner *ethSwapScanner) retryRouterLog(prefix string, rlog *types.Log) {
+	for {
+		err := scanner.handleRouterLog(prefix, rlog)
+		if err == nil {
+			return
+		}
+		log.Warn("handle router log failed, retry later", "prefix", prefix, "txhash", rlog.TxHash.Hex(), "index", rlog.Index, "err", err)
+		if !scanner.sleep(scanner.rpcInterval) {
+			return
+		}
+	}
+}
+
+// routerSwapOfLog router swap of log, it's nil if the log is of orphaned block or not configured.
+// Error is returned if the position of log in tx is not got, it should be retried.
+func (scanner *ethSwapScanner) routerSwapOfLog(prefix string, rlog *types.Log) (*swapPost, error) {
+	txhash := rlog.TxHash.String()
+	if rlog.Removed || !scanner.isCanonicalLog(rlog) {
+		log.Warn("ignore log of orphaned block", "prefix", prefix, "txhash", txhash, "block", rlog.BlockNumber, "hash", rlog.BlockHash.Hex())
+		return nil, nil
+	}
+	key := strings.ToLower(fmt.Sprintf("%v-%v", prefix, rlog.Address))
+	token := scanner.tokenSwap[key]
+	if token == nil {
+		log.Debug("handle router log", "txhash", txhash, "key not config", key)
+		return nil, nil
+	}
+	logIndex, err := scanner.getIndexPosition(rlog)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("handle router log", "prefix", prefix, "txhash", txhash, "logIndex", logIndex)
+	return scanner.newRouterSwap(txhash, logIndex, rlog.BlockNumber, rlog.BlockHash.Hex(), rlog, token), nil
+}
+
+// isCanonicalLog the log is not of a block which differs from the scanned canonical one
+func (scanner *ethSwapScanner) isCanonicalLog(rlog *types.Log) bool {
+	if scanner.headers == nil {
+		return true
+	}
+	header := scanner.headers.get(rlog.BlockNumber)
+	return header == nil || header.hash == rlog.BlockHash
+}
+
+// getIndexPosition position of log in the logs of tx, the receipt should be of the block of log
+func (scanner *ethSwapScanner) getIndexPosition(rlog *types.Log) (int, error) {
+	r, err := scanner.loopGetTxReceipt(rlog.TxHash)
+	if err != nil {
+		return 0, fmt.Errorf("get tx receipt failed: %w", err)
+	}
+	if r.BlockHash != rlog.BlockHash {
+		// the cached receipt may be of another fork, get it again when retrying
+		scanner.receipts.removeBlock(r.BlockHash)
+		return 0, fmt.Errorf("receipt is of block %v, log is of block %v", r.BlockHash.Hex(), rlog.BlockHash.Hex())
+	}
+	for i, l := range r.Logs {
+		if l.Index == rlog.Index {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("log %v is not found in receipt", rlog.Index)
 }
 
 func (scanner *ethSwapScanner) getLogsSwapRouter(from, to uint64, cache bool) {
-        if len(fqSwapRouter.Addresses) > 0 {
+        if len(scanner.fqSwapRouter.Addresses) > 0 {
 		log.Debug("getLogsSwapRouter", "from", from, "to", to)
-                scanner.filterLogs(from, to, fqSwapRouter, filterLogsRouterChan, cache)
+                scanner.filterLogs(from, to, scanner.fqSwapRouter, prefixSwapRouter, cache)
         }
 }
 
 func (scanner *ethSwapScanner) getLogsSwapRouterNFT(from, to uint64, cache bool) {
-        if len(fqSwapRouterNFT.Addresses) > 0 {
+        if len(scanner.fqSwapRouterNFT.Addresses) > 0 {
 		log.Debug("getLogsSwapRouterNFT", "from", from, "to", to)
-                scanner.filterLogs(from, to, fqSwapRouterNFT, filterLogsRouterNFTChan, cache)
+                scanner.filterLogs(from, to, scanner.fqSwapRouterNFT, prefixSwapRouterNFT, cache)
         }
 }
 
 func (scanner *ethSwapScanner) getLogsSwapRouterAnycall(from, to uint64, cache bool) {
-        if len(fqSwapRouterAnycall.Addresses) > 0 {
+        if len(scanner.fqSwapRouterAnycall.Addresses) > 0 {
 		log.Debug("getLogsSwapRouterAnycall", "from", from, "to", to)
-                scanner.filterLogs(from, to, fqSwapRouterAnycall, filterLogsRouterAnycallChan, cache)
+                scanner.filterLogs(from, to, scanner.fqSwapRouterAnycall, prefixSwapRouterAnycall, cache)
         }
 }
 
@@ -262,12 +279,17 @@ func (scanner *ethSwapScanner) getLogs(from, to uint64, cache bool) {
         scanner.getLogsSwapRouterAnycall(from, to, cache)
 }
 
-func (scanner *ethSwapScanner) filterLogs(from, to uint64, fq ethereum.FilterQuery, ch chan types.Log, cache bool) {
-        ctx := context.Background()
+// filterLogs get logs in range and handle them before return
+func (scanner *ethSwapScanner) filterLogs(from, to uint64, fq ethereum.FilterQuery, prefix string, cache bool) {
+        ctx := scanner.ctx
         fq.FromBlock = big.NewInt(int64(from))
         fq.ToBlock = big.NewInt(int64(to))
         for i := 0; i < scanner.rpcRetryCount; i++ {
-                logs, err := scanner.client.FilterLogs(ctx, fq)
+                var logs []types.Log
+                err := scanner.gateways.call("eth_getLogs", func(cli Client) (err error) {
+                        logs, err = cli.FilterLogs(ctx, fq)
+                        return err
+                })
                 if err == nil {
                         for _, l := range logs {
                                 //blockhash := l.BlockHash.String()
@@ -277,7 +299,10 @@ func (scanner *ethSwapScanner) filterLogs(from, to uint64, fq ethereum.FilterQue
                                 //}
                                 //cachedBlocks.addBlock(blockhash)
 				log.Debug("filterLogs log success", "from", from, "to", to, "address", l.Address, "topic", l.Topics[0])
-                                ch <- l
+                                if scanner.isStopping() {
+                                        return
+                                }
+                                scanner.retryRouterLog(prefix, &l)
                         }
                         //log.Info("filterLogs success", "block", height)
                         return
@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/metrics"
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/tools"
+)
+
+// max time to wait for the rate limit of swap server, the post is short-circuited if exceeded
+const maxRateLimitWait = 1 * time.Second
+
+var (
+	errCircuitOpen = errors.New("swap server circuit is open")
+	errRateLimited = errors.New("swap server rate limit exceeded")
+)
+
+// swapServerGuard circuit breaker and rate limiter of swap server
+type swapServerGuard struct {
+	url     string
+	cfg     params.SwapServerConfig
+	breaker *tools.CircuitBreaker
+	limiter *tools.RateLimiter
+}
+
+var (
+	swapServerGuards    = make(map[string]*swapServerGuard)
+	swapServerGuardLock sync.Mutex
+)
+
+// getSwapServerGuard get guard of swap server, it's renewed if the config is changed by reloading
+func getSwapServerGuard(url string) *swapServerGuard {
+	cfg := params.GetSwapServerConfig(url)
+	key := strings.ToLower(url)
+	swapServerGuardLock.Lock()
+	defer swapServerGuardLock.Unlock()
+	guard, exist := swapServerGuards[key]
+	if !exist || guard.cfg != *cfg {
+		guard = &swapServerGuard{
+			url:     url,
+			cfg:     *cfg,
+			breaker: tools.NewCircuitBreaker(cfg.FailureThreshold, time.Duration(cfg.OpenTimeout)*time.Second),
+			limiter: tools.NewRateLimiter(cfg.RateLimit, cfg.RateBurst),
+		}
+		swapServerGuards[key] = guard
+		metrics.SwapServerCircuit.WithLabelValues(url).Set(circuitStateValue(tools.CircuitClosed))
+	}
+	return guard
+}
+
+// acquire check circuit and wait for rate limit,
+// the acquired post must be released with its outcome.
+func (g *swapServerGuard) acquire() error {
+	if !g.breaker.Allow() {
+		return errCircuitOpen
+	}
+	if g.breaker.State() == tools.CircuitHalfOpen {
+		log.Info("probe swap server of half-open circuit", "server", g.url)
+		metrics.SwapServerCircuit.WithLabelValues(g.url).Set(circuitStateValue(tools.CircuitHalfOpen))
+	}
+	delay, ok := g.limiter.Reserve(maxRateLimitWait)
+	if !ok {
+		g.breaker.Cancel()
+		return errRateLimited
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return nil
+}
+
+// release report post outcome, only transient outcome is failure of the swap server
+func (g *swapServerGuard) release(outcome string) {
+	state, changed := g.breaker.Done(outcome != params.PostTransient)
+	if !changed {
+		return
+	}
+	metrics.SwapServerCircuit.WithLabelValues(g.url).Set(circuitStateValue(state))
+	if state == tools.CircuitOpen {
+		log.Warn("swap server circuit is open", "server", g.url, "openTimeout", g.cfg.OpenTimeout)
+	} else {
+		log.Info("swap server circuit is "+state, "server", g.url)
+	}
+}
+
+func circuitStateValue(state string) float64 {
+	switch state {
+	case tools.CircuitOpen:
+		return 1
+	case tools.CircuitHalfOpen:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// isPostShortCircuited the post is skipped by circuit breaker or rate limiter,
+// it should be kept in pending swaps or outbox without retrying now.
+func isPostShortCircuited(err error) bool {
+	return errors.Is(err, errCircuitOpen) || errors.Is(err, errRateLimited)
+}
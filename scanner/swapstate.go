@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"errors"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+
+	"github.com/weijun-sh/gethscan/params"
+	"github.com/weijun-sh/gethscan/storage"
+)
+
+const (
+	txFailedReason    = "tx failed"
+	interruptedReason = "interrupted when posting"
+	outboxDeadReason  = "reached max attempts of outbox"
+)
+
+var errSwapKept = errors.New("swap record is kept")
+
+// swapState the state of swap after post outcome
+func swapState(outcome string) string {
+	switch outcome {
+	case params.PostSuccess:
+		return storage.SwapRegistered
+	case params.PostAlreadyRegistered:
+		return storage.SwapAlreadyRegistered
+	case params.PostTransient:
+		return storage.SwapPendingRetry
+	default:
+		return storage.SwapRejected
+	}
+}
+
+func (scanner *ethSwapScanner) newMgoSwap(swap *swapPost) *storage.Swap {
+	return &storage.Swap{
+		Id:         swap.key(),
+		TxID:       swap.txid,
+		PairID:     swap.pairID,
+		RpcMethod:  swap.rpcMethod,
+		SwapServer: swap.swapServer,
+		ChainID:    swap.chainID,
+		LogIndex:   swap.logIndex,
+		Chain:      scanner.chain,
+		Detail:     toMgoSwapDetail(swap.detail),
+	}
+}
+
+// transitSwap move swap record to state, the record is added if not exist.
+// Detecting an existing swap keeps its record unless the former block is orphaned.
+func (scanner *ethSwapScanner) transitSwap(swap *swapPost, state, reason string) {
+	if scanner.store == nil {
+		return
+	}
+	errMsg := swap.postErr
+	if state == storage.SwapDetected || state == storage.SwapPosting {
+		errMsg = ""
+	}
+	update := func(ms *storage.Swap) error {
+		if state == storage.SwapDetected && ms.State != "" && ms.State != storage.SwapOrphaned {
+			return errSwapKept
+		}
+		ms.BlockNumber = swap.blockNumber
+		ms.BlockHash = swap.blockHash
+		ms.PostOutcome = swap.outcome
+		ms.PostError = swap.postErr
+		ms.Sinks = swap.sinks
+		ms.Delivered = swap.delivered
+		if swap.detail != nil {
+			ms.Detail = toMgoSwapDetail(swap.detail)
+		}
+		return ms.Transit(state, reason, errMsg)
+	}
+	key := swap.key()
+	_, err := scanner.store.UpdateSwap(key, update)
+	if errors.Is(err, storage.ErrNotFound) {
+		ms := scanner.newMgoSwap(swap)
+		if err = update(ms); err == nil {
+			err = scanner.store.AddSwap(ms)
+		}
+		if errors.Is(err, storage.ErrSwapExists) { // added by others at the same time
+			_, err = scanner.store.UpdateSwap(key, update)
+		}
+	}
+	if err != nil && !errors.Is(err, errSwapKept) {
+		log.Warn("update swap state failed", "chain", scanner.chain, "txid", swap.txid, "key", key, "state", state, "err", err)
+	}
+}
+
+// recoverPostingSwaps move the swaps which were posting when last stopped to be reposted
+func (scanner *ethSwapScanner) recoverPostingSwaps() {
+	swaps, err := scanner.store.FindSwaps(scanner.chain, storage.SwapPosting, 0, 0)
+	if err != nil {
+		log.Warn("find posting swaps failed", "chain", scanner.chain, "err", err)
+		return
+	}
+	for _, ms := range swaps {
+		_, err = scanner.store.UpdateSwap(ms.Id, func(ms *storage.Swap) error {
+			if ms.State != storage.SwapPosting {
+				return errSwapKept
+			}
+			return ms.Transit(storage.SwapPendingRetry, interruptedReason, "")
+		})
+		if err != nil && !errors.Is(err, errSwapKept) {
+			log.Warn("recover posting swap failed", "chain", scanner.chain, "key", ms.Id, "err", err)
+		}
+	}
+	if len(swaps) != 0 {
+		log.Info("recover posting swaps", "chain", scanner.chain, "count", len(swaps))
+	}
+}
+
+// markSwapsOrphaned move swaps of orphaned block to orphaned state
+func (scanner *ethSwapScanner) markSwapsOrphaned(height uint64, blockHash, reason string) {
+	err := storage.MarkSwapsOrphaned(scanner.store, scanner.chain, blockHash, reason)
+	if err != nil {
+		log.Warn("mark orphaned swaps failed", "height", height, "hash", blockHash, "err", err)
+	}
+}
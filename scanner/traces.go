@@ -0,0 +1,253 @@
+package scanner
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	"github.com/jowenshaw/gethclient/types"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+const (
+	traceMethodDebug  = "debug_traceBlockByNumber"
+	traceMethodParity = "trace_block"
+
+	debugTraceTimeout = "60s" // timeout of tracing a block with callTracer
+)
+
+// callFrame call frame of `callTracer`
+type callFrame struct {
+	Type  string       `json:"type"`
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Value string       `json:"value"`
+	Error string       `json:"error"`
+	Calls []*callFrame `json:"calls"`
+}
+
+type debugTraceResult struct {
+	Result *callFrame `json:"result"`
+	Error  string     `json:"error"`
+}
+
+// parityTrace flat trace of `trace_block`
+type parityTrace struct {
+	Action struct {
+		CallType string `json:"callType"`
+		From     string `json:"from"`
+		To       string `json:"to"`
+		Value    string `json:"value"`
+	} `json:"action"`
+	Error               string  `json:"error"`
+	TraceAddress        []int   `json:"traceAddress"`
+	TransactionHash     string  `json:"transactionHash"`
+	TransactionPosition *uint64 `json:"transactionPosition"`
+	Type                string  `json:"type"`
+}
+
+// internalTransfer native value transferred by internal call
+type internalTransfer struct {
+	txIndex int
+	to      string
+	value   *big.Int
+}
+
+func checkTraceMethod(method string) error {
+	switch method {
+	case "", traceMethodDebug, traceMethodParity:
+		return nil
+	default:
+		return fmt.Errorf("unsupported trace method '%v', supported are %v", method, []string{traceMethodDebug, traceMethodParity})
+	}
+}
+
+// nativeSwapinTokens native swapin tokens which can be deposited by internal txs
+func (scanner *ethSwapScanner) nativeSwapinTokens() (tokens []*params.TokenConfig) {
+	for _, tokenCfg := range scanner.tokens() {
+		if tokenCfg.IsNativeToken() && tokenCfg.DepositAddress != "" {
+			tokens = append(tokens, tokenCfg)
+		}
+	}
+	return tokens
+}
+
+// scanBlockTraces find native swapins which are deposited by internal txs (eg. multisig, aggregator),
+// the swapins deposited by top level txs are already found by `verifyTransaction`.
+// The block should be scanned again if it can not be traced.
+func (scanner *ethSwapScanner) scanBlockTraces(block *types.Block) (swaps []*swapPost, err error) {
+	if scanner.traceMethod == "" {
+		return nil, nil
+	}
+	tokens := scanner.nativeSwapinTokens()
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	height := block.NumberU64()
+	var transfers []*internalTransfer
+	for i := 0; i < scanner.rpcRetryCount; i++ {
+		transfers, err = scanner.getInternalTransfers(block)
+		if err == nil {
+			break
+		}
+		log.Warn("trace block failed", "method", scanner.traceMethod, "height", height, "err", err)
+		if !scanner.sleep(scanner.rpcInterval) {
+			break
+		}
+	}
+	if err != nil {
+		log.Error("trace block failed after retries", "method", scanner.traceMethod, "height", height, "err", err)
+		return nil, err
+	}
+
+	txs := block.Transactions()
+	posted := make(map[string]bool)
+	for _, transfer := range transfers {
+		if transfer.txIndex < 0 || transfer.txIndex >= len(txs) {
+			continue
+		}
+		txHash := txs[transfer.txIndex].Hash().Hex()
+		for _, tokenCfg := range tokens {
+			if !strings.EqualFold(transfer.to, tokenCfg.DepositAddress) {
+				continue
+			}
+			key := strings.ToLower(txHash + ":" + tokenCfg.PairID + ":" + tokenCfg.SwapServer)
+			if posted[key] {
+				continue
+			}
+			posted[key] = true
+			log.Info("found internal native swapin", "txid", txHash, "to", transfer.to, "value", transfer.value, "pairID", tokenCfg.PairID)
+			swaps = append(swaps, scanner.newBridgeSwap(txHash, height, block.Hash().Hex(), tokenCfg))
+		}
+	}
+	return swaps, nil
+}
+
+func (scanner *ethSwapScanner) getInternalTransfers(block *types.Block) ([]*internalTransfer, error) {
+	switch scanner.traceMethod {
+	case traceMethodDebug:
+		return scanner.debugTraceBlock(block)
+	case traceMethodParity:
+		return scanner.parityTraceBlock(block)
+	default:
+		return nil, fmt.Errorf("unsupported trace method %v", scanner.traceMethod)
+	}
+}
+
+func (scanner *ethSwapScanner) debugTraceBlock(block *types.Block) (transfers []*internalTransfer, err error) {
+	var results []*debugTraceResult
+	tracerConfig := map[string]interface{}{
+		"tracer":  "callTracer",
+		"timeout": debugTraceTimeout,
+	}
+	err = scanner.gateways.callRPC(traceMethodDebug, func(cli BatchClient) error {
+		return cli.CallContext(scanner.ctx, &results, traceMethodDebug, fmt.Sprintf("0x%x", block.NumberU64()), tracerConfig)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(block.Transactions()) {
+		return nil, fmt.Errorf("get %v traces of block %v with %v txs", len(results), block.NumberU64(), len(block.Transactions()))
+	}
+	for i, res := range results {
+		if res == nil || res.Result == nil {
+			return nil, fmt.Errorf("trace tx %v of block %v failed: %v", i, block.NumberU64(), res)
+		}
+		// the top level call is checked by tx scanning, and the calls of failed frame are reverted
+		if res.Error != "" || res.Result.Error != "" {
+			continue
+		}
+		transfers = collectCallTransfers(i, res.Result.Calls, transfers)
+	}
+	return transfers, nil
+}
+
+func collectCallTransfers(txIndex int, calls []*callFrame, transfers []*internalTransfer) []*internalTransfer {
+	for _, call := range calls {
+		if call == nil || call.Error != "" {
+			continue
+		}
+		if strings.EqualFold(call.Type, "CALL") {
+			if value := parseTraceValue(call.Value); value != nil {
+				transfers = append(transfers, &internalTransfer{txIndex: txIndex, to: call.To, value: value})
+			}
+		}
+		transfers = collectCallTransfers(txIndex, call.Calls, transfers)
+	}
+	return transfers
+}
+
+func (scanner *ethSwapScanner) parityTraceBlock(block *types.Block) (transfers []*internalTransfer, err error) {
+	var traces []*parityTrace
+	err = scanner.gateways.callRPC(traceMethodParity, func(cli BatchClient) error {
+		return cli.CallContext(scanner.ctx, &traces, traceMethodParity, fmt.Sprintf("0x%x", block.NumberU64()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	// trace addresses of failed calls, the sub calls of them are reverted
+	failed := make(map[string][][]int)
+	for _, trace := range traces {
+		if trace == nil || trace.TransactionPosition == nil {
+			continue // block and uncle rewards
+		}
+		if trace.Error != "" {
+			failed[trace.TransactionHash] = append(failed[trace.TransactionHash], trace.TraceAddress)
+		}
+	}
+	for _, trace := range traces {
+		if trace == nil || trace.TransactionPosition == nil || len(trace.TraceAddress) == 0 {
+			continue
+		}
+		if trace.Type != "call" || trace.Action.CallType != "call" {
+			continue
+		}
+		if isTraceReverted(trace.TraceAddress, failed[trace.TransactionHash]) {
+			continue
+		}
+		value := parseTraceValue(trace.Action.Value)
+		if value == nil {
+			continue
+		}
+		transfers = append(transfers, &internalTransfer{
+			txIndex: int(*trace.TransactionPosition),
+			to:      trace.Action.To,
+			value:   value,
+		})
+	}
+	return transfers, nil
+}
+
+// isTraceReverted whether the trace or one of its ancestors (including the top level call) failed
+func isTraceReverted(traceAddress []int, failed [][]int) bool {
+	for _, prefix := range failed {
+		if len(prefix) > len(traceAddress) {
+			continue
+		}
+		match := true
+		for i := range prefix {
+			if prefix[i] != traceAddress[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTraceValue parse hex value, returns nil if it's empty or zero
+func parseTraceValue(value string) *big.Int {
+	if value == "" {
+		return nil
+	}
+	v, ok := new(big.Int).SetString(strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X"), 16)
+	if !ok || v.Sign() <= 0 {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,204 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/jowenshaw/gethclient/common"
+	"github.com/jowenshaw/gethclient/types"
+	rpc "github.com/jowenshaw/gethrpc"
+
+	"github.com/weijun-sh/gethscan/params"
+)
+
+const testDepositAddress = "0xD0d0D0D0d0D0D0D0d0d0D0D0D0d0d0D0d0D0d0D0"
+
+// traceClient stub client which replies the raw rpc calls with recorded fixtures in testdata
+type traceClient struct {
+	*stubClient
+	err error
+}
+
+func (c *traceClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if err := c.call(method); err != nil {
+		return err
+	}
+	if c.err != nil {
+		return c.err
+	}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", method+".json"))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (c *traceClient) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	return errStubNotSupported
+}
+
+func newTraceScanner(t *testing.T, method string, err error) (*ethSwapScanner, *traceClient) {
+	client := &traceClient{stubClient: newStubClient(), err: err}
+	scanner := newStubScanner(t, client.stubClient)
+	gateways, errp := newClientPool(context.Background(), "eth", client)
+	if errp != nil {
+		t.Fatal(errp)
+	}
+	scanner.gateways = gateways
+	scanner.traceMethod = method
+	bcConfig := &params.BlockChainConfig{Chain: "eth"}
+	chainCfg, errc := params.NewChainConfig(bcConfig, []*params.TokenConfig{{
+		TxType:         params.TxSwapin,
+		PairID:         "ETH",
+		TokenAddress:   "native",
+		DepositAddress: testDepositAddress,
+		SwapServer:     "http://127.0.0.1:1",
+	}})
+	if errc != nil {
+		t.Fatal(errc)
+	}
+	scanner.chainCfg = chainCfg
+	return scanner, client
+}
+
+// newTraceBlock block of the two txs in trace fixtures
+func newTraceBlock() *types.Block {
+	to := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	txs := []*types.Transaction{
+		types.NewTransaction(0, to, big.NewInt(0), 100000, big.NewInt(1), nil),
+		types.NewTransaction(1, to, big.NewInt(0), 100000, big.NewInt(1), nil),
+	}
+	header := &types.Header{Number: big.NewInt(100), Difficulty: big.NewInt(1)}
+	return types.NewBlockWithHeader(header).WithBody(txs, nil)
+}
+
+func checkTransfers(t *testing.T, transfers []*internalTransfer) {
+	want := []*internalTransfer{
+		{txIndex: 0, to: "0xd0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0d0", value: big.NewInt(1e18)},
+		{txIndex: 0, to: "0x5555555555555555555555555555555555555555", value: big.NewInt(2)},
+	}
+	if len(transfers) != len(want) {
+		t.Fatalf("got %v transfers, want %v", len(transfers), len(want))
+	}
+	for i, transfer := range transfers {
+		if transfer.txIndex != want[i].txIndex || transfer.to != want[i].to || transfer.value.Cmp(want[i].value) != 0 {
+			t.Errorf("transfer %v is %+v, want %+v", i, transfer, want[i])
+		}
+	}
+}
+
+func TestDebugTraceBlock(t *testing.T) {
+	scanner, _ := newTraceScanner(t, traceMethodDebug, nil)
+	transfers, err := scanner.getInternalTransfers(newTraceBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTransfers(t, transfers)
+}
+
+func TestParityTraceBlock(t *testing.T) {
+	scanner, _ := newTraceScanner(t, traceMethodParity, nil)
+	transfers, err := scanner.getInternalTransfers(newTraceBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTransfers(t, transfers)
+}
+
+func TestDebugTraceBlockMismatch(t *testing.T) {
+	scanner, _ := newTraceScanner(t, traceMethodDebug, nil)
+	header := &types.Header{Number: big.NewInt(100), Difficulty: big.NewInt(1)}
+	block := types.NewBlockWithHeader(header) // no txs
+	if _, err := scanner.getInternalTransfers(block); err == nil {
+		t.Fatal("traces of other block are accepted")
+	}
+}
+
+func TestIsTraceReverted(t *testing.T) {
+	failed := [][]int{{1}, {2, 0}}
+	tests := []struct {
+		traceAddress []int
+		reverted     bool
+	}{
+		{[]int{0}, false},
+		{[]int{1}, true},
+		{[]int{1, 3, 2}, true},
+		{[]int{2}, false},
+		{[]int{2, 0, 1}, true},
+		{[]int{2, 1}, false},
+	}
+	for _, test := range tests {
+		if got := isTraceReverted(test.traceAddress, failed); got != test.reverted {
+			t.Errorf("isTraceReverted(%v) is %v, want %v", test.traceAddress, got, test.reverted)
+		}
+	}
+	if !isTraceReverted([]int{0, 1}, [][]int{{}}) {
+		t.Error("calls of failed top level call are not reverted")
+	}
+}
+
+func TestParseTraceValue(t *testing.T) {
+	for value, want := range map[string]*big.Int{
+		"":                  nil,
+		"0x0":               nil,
+		"0x":                nil,
+		"0X10":              big.NewInt(16),
+		"0xde0b6b3a7640000": big.NewInt(1e18),
+		"wrong":             nil,
+	} {
+		got := parseTraceValue(value)
+		if (got == nil) != (want == nil) || (got != nil && got.Cmp(want) != 0) {
+			t.Errorf("parseTraceValue(%q) is %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestScanBlockTraces(t *testing.T) {
+	scanner, _ := newTraceScanner(t, traceMethodParity, nil)
+	block := newTraceBlock()
+	swaps, err := scanner.scanBlockTraces(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swaps) != 1 {
+		t.Fatalf("found %v internal swapins, want 1", len(swaps))
+	}
+	if swap := swaps[0]; swap.txid != block.Transactions()[0].Hash().Hex() || swap.pairID != "ETH" || swap.blockNumber != 100 {
+		t.Fatalf("wrong internal swapin %+v", swap)
+	}
+}
+
+// TestScanBlockTracesError the block is not marked scanned if it can not be traced
+func TestScanBlockTracesError(t *testing.T) {
+	errTrace := errors.New("trace is not available")
+	scanner, client := newTraceScanner(t, traceMethodDebug, errTrace)
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+	client.headers[1] = header
+
+	if _, err := scanner.scanBlockTraces(newTraceBlock()); !errors.Is(err, errTrace) {
+		t.Fatalf("scan block traces got error %v, want %v", err, errTrace)
+	}
+	if n := client.callCount(traceMethodDebug); n != scanner.rpcRetryCount {
+		t.Fatalf("traced %v times, want %v", n, scanner.rpcRetryCount)
+	}
+	if err := scanner.scanCanonicalBlock(1); !errors.Is(err, errTrace) {
+		t.Fatalf("scan block got error %v, want %v", err, errTrace)
+	}
+	if scanner.headers.get(1) != nil {
+		t.Fatal("block which can not be traced is marked scanned")
+	}
+
+	client.err = nil
+	scanner.traceMethod = traceMethodParity // traces of fixture are not checked with the block txs
+	if err := scanner.scanCanonicalBlock(1); err != nil {
+		t.Fatal(err)
+	}
+	if scanner.headers.get(1) == nil {
+		t.Fatal("block is not marked scanned after traced")
+	}
+}
@@ -0,0 +1,377 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/weijun-sh/gethscan/metrics"
+)
+
+// buckets of bolt database, swaps are keyed by SwapKey
+var (
+	bucketMeta        = []byte("meta")
+	bucketSwap        = []byte("swap")
+	bucketSwapState   = []byte("swapState")   // chain \0 state \0 big endian block number, swap key -> nil
+	bucketSwapBlock   = []byte("swapBlock")   // chain \0 block hash \0 swap key -> nil
+	bucketSwapTx      = []byte("swapTx")      // txid \0 swap key -> nil
+	bucketSyncedBlock = []byte("syncedBlock") // chain -> big endian block number
+	bucketRangeJob    = []byte("rangeJob")
+
+	keySchemaVersion = []byte("schemaVersion")
+)
+
+// boltMigrations schema migrations in order, never change the applied ones
+var boltMigrations = []func(tx *bolt.Tx) error{
+	// 1: swaps keyed by SwapKey with states and their indexes, synced blocks and range jobs
+	func(tx *bolt.Tx) error {
+		buckets := [][]byte{bucketSwap, bucketSwapState, bucketSwapBlock, bucketSwapTx, bucketSyncedBlock, bucketRangeJob}
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// BoltStore implements Store with an embedded bolt database file, for single node installs.
+// Swaps are indexed by state, block and txid in index buckets, which are updated with the swap.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var _ Store = (*BoltStore)(nil)
+
+// NewBoltStore open bolt database file and apply the migrations
+func NewBoltStore(file string) (*BoltStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt file '%v' failed: %w", file, err)
+	}
+	s := &BoltStore{db: db}
+	if err = s.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate apply the migrations which are not applied, each in a transaction
+func (s *BoltStore) migrate() error {
+	var applied uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		if v := meta.Get(keySchemaVersion); len(v) == 8 {
+			applied = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for version := applied + 1; version <= uint64(len(boltMigrations)); version++ {
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			if err := boltMigrations[version-1](tx); err != nil {
+				return err
+			}
+			return tx.Bucket(bucketMeta).Put(keySchemaVersion, encodeUint64(version))
+		})
+		if err != nil {
+			return fmt.Errorf("migration %v failed: %w", version, err)
+		}
+		log.Info("[bolt] apply migration success", "version", version)
+	}
+	return nil
+}
+
+func encodeUint64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// indexPrefix join the parts of index key, every part is ended with 0
+func indexPrefix(parts ...string) []byte {
+	var prefix []byte
+	for _, part := range parts {
+		prefix = append(prefix, part...)
+		prefix = append(prefix, 0)
+	}
+	return prefix
+}
+
+// swapIndexes index keys of swap in every index bucket
+func swapIndexes(swap *Swap) map[string][]byte {
+	stateKey := append(indexPrefix(swap.Chain, swap.State), encodeUint64(swap.BlockNumber)...)
+	return map[string][]byte{
+		string(bucketSwapState): append(stateKey, swap.Id...),
+		string(bucketSwapBlock): append(indexPrefix(swap.Chain, swap.BlockHash), swap.Id...),
+		string(bucketSwapTx):    append(indexPrefix(swap.TxID), swap.Id...),
+	}
+}
+
+// putSwap save swap and replace the index keys of old, old is nil for new swap
+func putSwap(tx *bolt.Tx, swap, old *Swap) error {
+	swap.TxID = NormalizeTxID(swap.TxID)
+	data, err := json.Marshal(swap)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		for bucket, key := range swapIndexes(old) {
+			if err = tx.Bucket([]byte(bucket)).Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	for bucket, key := range swapIndexes(swap) {
+		if err = tx.Bucket([]byte(bucket)).Put(key, []byte{}); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(bucketSwap).Put([]byte(swap.Id), data)
+}
+
+func getSwap(tx *bolt.Tx, key string) (*Swap, error) {
+	data := tx.Bucket(bucketSwap).Get([]byte(key))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	swap := &Swap{}
+	if err := json.Unmarshal(data, swap); err != nil {
+		return nil, fmt.Errorf("decode swap %v failed: %w", key, err)
+	}
+	return swap, nil
+}
+
+// findSwapsByIndex swaps of the index keys with prefix in key order,
+// skip is the length of index key before swap key, limit 0 means no limit
+func (s *BoltStore) findSwapsByIndex(bucket, prefix []byte, skip, offset, limit int) ([]*Swap, error) {
+	result := make([]*Swap, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if limit > 0 && len(result) >= limit {
+				break
+			}
+			swap, err := getSwap(tx, string(k[skip:]))
+			if err != nil {
+				return fmt.Errorf("index %s of %s is broken: %w", k, bucket, err)
+			}
+			result = append(result, swap)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// AddSwap implements Store
+func (s *BoltStore) AddSwap(swap *Swap) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketSwap).Get([]byte(swap.Id)) != nil {
+			return ErrSwapExists
+		}
+		swap.Version = 1
+		return putSwap(tx, swap, nil)
+	})
+	metrics.StorageError("bolt", "AddSwap", err)
+	if err == nil {
+		log.Info("[bolt] AddSwap success", "swap", swap)
+	} else {
+		log.Warn("[bolt] AddSwap failed", "swap", swap, "err", err)
+	}
+	return err
+}
+
+// UpdateSwap implements Store
+func (s *BoltStore) UpdateSwap(key string, f func(swap *Swap) error) (swap *Swap, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		swap, err = getSwap(tx, key)
+		if err != nil {
+			return err
+		}
+		old := *swap
+		if err = f(swap); err != nil {
+			return err
+		}
+		swap.Version++
+		return putSwap(tx, swap, &old)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info("[bolt] UpdateSwap success", "key", key, "state", swap.State, "attempts", swap.Attempts)
+	return swap, nil
+}
+
+// FindSwap implements Store
+func (s *BoltStore) FindSwap(key string) (swap *Swap, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		swap, err = getSwap(tx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return swap, nil
+}
+
+// FindSwapsByTxID implements Store
+func (s *BoltStore) FindSwapsByTxID(txid string) ([]*Swap, error) {
+	prefix := indexPrefix(NormalizeTxID(txid))
+	result, err := s.findSwapsByIndex(bucketSwapTx, prefix, len(prefix), 0, 0)
+	metrics.StorageError("bolt", "FindSwapsByTxID", err)
+	return result, err
+}
+
+// FindSwaps implements Store
+func (s *BoltStore) FindSwaps(chain, state string, offset, limit int) ([]*Swap, error) {
+	prefix := indexPrefix(chain, state)
+	result, err := s.findSwapsByIndex(bucketSwapState, prefix, len(prefix)+8, offset, limit)
+	metrics.StorageError("bolt", "FindSwaps", err)
+	return result, err
+}
+
+// FindSwapsByBlock implements Store
+func (s *BoltStore) FindSwapsByBlock(chain, blockHash string) ([]*Swap, error) {
+	prefix := indexPrefix(chain, blockHash)
+	result, err := s.findSwapsByIndex(bucketSwapBlock, prefix, len(prefix), 0, 0)
+	metrics.StorageError("bolt", "FindSwapsByBlock", err)
+	return result, err
+}
+
+// CountSwaps implements Store
+func (s *BoltStore) CountSwaps(chain, state string) (count int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		prefix := indexPrefix(chain, state)
+		c := tx.Bucket(bucketSwapState).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			count++
+		}
+		return nil
+	})
+	metrics.StorageError("bolt", "CountSwaps", err)
+	return count, err
+}
+
+// FindSyncedBlockNumber implements Store
+func (s *BoltStore) FindSyncedBlockNumber(chain string) (number uint64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketSyncedBlock).Get([]byte(chain))
+		if len(v) != 8 {
+			return ErrNotFound
+		}
+		number = binary.BigEndian.Uint64(v)
+		return nil
+	})
+	metrics.StorageError("bolt", "FindSyncedBlockNumber", err)
+	return number, err
+}
+
+// InitSyncedBlockNumber implements Store
+func (s *BoltStore) InitSyncedBlockNumber(chain string, number uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSyncedBlock).Put([]byte(chain), encodeUint64(number))
+	})
+}
+
+// UpdateSyncedBlockNumber implements Store
+func (s *BoltStore) UpdateSyncedBlockNumber(chain string, number uint64) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSyncedBlock)
+		if b.Get([]byte(chain)) == nil {
+			return ErrNotFound
+		}
+		return b.Put([]byte(chain), encodeUint64(number))
+	})
+	metrics.StorageError("bolt", "UpdateSyncedBlockNumber", err)
+	return err
+}
+
+// FindRangeJobs implements Store
+func (s *BoltStore) FindRangeJobs(chain string) ([]*RangeJob, error) {
+	var result []*RangeJob
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRangeJob).ForEach(func(k, v []byte) error {
+			job := &RangeJob{}
+			if err := json.Unmarshal(v, job); err != nil {
+				return fmt.Errorf("decode range job %s failed: %w", k, err)
+			}
+			if job.Chain == chain {
+				result = append(result, job)
+			}
+			return nil
+		})
+	})
+	metrics.StorageError("bolt", "FindRangeJobs", err)
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		if a.End != b.End {
+			return a.End < b.End
+		}
+		return a.From < b.From
+	})
+	return result, err
+}
+
+// UpsertRangeJob implements Store
+func (s *BoltStore) UpsertRangeJob(job *RangeJob) error {
+	job.Timestamp = uint64(time.Now().Unix())
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRangeJob).Put([]byte(job.Id), data)
+	})
+	metrics.StorageError("bolt", "UpsertRangeJob", err)
+	return err
+}
+
+// DeleteRangeJobs implements Store
+func (s *BoltStore) DeleteRangeJobs(chain string, start, end uint64) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketRangeJob)
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			job := &RangeJob{}
+			if err := json.Unmarshal(v, job); err != nil {
+				return fmt.Errorf("decode range job %s failed: %w", k, err)
+			}
+			if job.Chain == chain && job.Start == start && job.End == end {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		for _, k := range keys {
+			if err == nil {
+				err = b.Delete(k)
+			}
+		}
+		return err
+	})
+	metrics.StorageError("bolt", "DeleteRangeJobs", err)
+	return err
+}
+
+// Close implements Store
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestBoltStore(t *testing.T) *BoltStore {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestBoltSwapsOfOneTx(t *testing.T) {
+	testSwapsOfOneTx(t, newTestBoltStore(t))
+}
+
+func TestBoltUpdateSwapAbort(t *testing.T) {
+	testUpdateSwapAbort(t, newTestBoltStore(t))
+}
+
+func TestBoltMarkSwapsOrphaned(t *testing.T) {
+	testMarkSwapsOrphaned(t, newTestBoltStore(t))
+}
+
+func TestBoltFindSwaps(t *testing.T) {
+	testFindSwaps(t, newTestBoltStore(t))
+}
+
+func TestBoltSyncedBlockNumber(t *testing.T) {
+	testSyncedBlockNumber(t, newTestBoltStore(t))
+}
+
+func TestBoltRangeJobs(t *testing.T) {
+	testRangeJobs(t, newTestBoltStore(t))
+}
+
+func TestBoltFindSwapsByTxID(t *testing.T) {
+	testFindSwapsByTxID(t, newTestBoltStore(t))
+}
+
+// TestBoltSwapIndexes the index keys follow the updates of swap
+func TestBoltSwapIndexes(t *testing.T) {
+	s := newTestBoltStore(t)
+	swap := newTestSwap("0x1", "1")
+	addTestSwaps(t, s, swap)
+	_, err := s.UpdateSwap(swap.Id, func(swap *Swap) error {
+		swap.BlockNumber, swap.BlockHash = 101, "0xdef"
+		return swap.Transit(SwapPosting, "", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swaps, _ := s.FindSwapsByBlock("eth", "0xabc"); len(swaps) != 0 {
+		t.Fatalf("found %v swaps of the old block", len(swaps))
+	}
+	if swaps, _ := s.FindSwapsByBlock("eth", "0xdef"); len(swaps) != 1 || swaps[0].State != SwapPosting {
+		t.Fatalf("found %v swaps of the new block", len(swaps))
+	}
+	if n, _ := s.CountSwaps("eth", SwapDetected); n != 0 {
+		t.Fatalf("count detected swaps got %v, want 0", n)
+	}
+	if swaps, _ := s.FindSwaps("eth", SwapPosting, 0, 0); len(swaps) != 1 || swaps[0].BlockNumber != 101 {
+		t.Fatalf("found %v posting swaps", len(swaps))
+	}
+}
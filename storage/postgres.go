@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anyswap/CrossChain-Bridge/log"
+	_ "github.com/lib/pq" // postgres driver
+
+	"github.com/weijun-sh/gethscan/metrics"
+)
+
+type postgresMigration func(ctx context.Context, tx *sql.Tx) error
+
+func sqlMigration(query string) postgresMigration {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	}
+}
+
+// postgresMigrations schema migrations in order, never change the applied ones
+var postgresMigrations = []postgresMigration{
+	// 1: swaps keyed by SwapKey with states, synced blocks and range jobs
+	sqlMigration(`CREATE TABLE swaps (
+		id           TEXT PRIMARY KEY,
+		txid         TEXT NOT NULL,
+		chain        TEXT NOT NULL,
+		state        TEXT NOT NULL,
+		block_number BIGINT NOT NULL,
+		block_hash   TEXT NOT NULL,
+		timestamp    BIGINT NOT NULL,
+		data         JSONB NOT NULL
+	);
+	CREATE INDEX swaps_txid_idx ON swaps (txid);
+	CREATE INDEX swaps_block_idx ON swaps (chain, block_hash);
+	CREATE INDEX swaps_state_idx ON swaps (chain, state, block_number);
+	CREATE TABLE synced_blocks (
+		chain        TEXT PRIMARY KEY,
+		block_number BIGINT NOT NULL
+	);
+	CREATE TABLE range_jobs (
+		id        TEXT PRIMARY KEY,
+		chain     TEXT NOT NULL,
+		start_at  BIGINT NOT NULL,
+		end_at    BIGINT NOT NULL,
+		from_at   BIGINT NOT NULL,
+		data      JSONB NOT NULL
+	);
+	CREATE INDEX range_jobs_range_idx ON range_jobs (chain, start_at, end_at, from_at);`),
+}
+
+// PostgresStore implements Store with postgresql
+type PostgresStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+var _ Store = (*PostgresStore)(nil)
+
+// NewPostgresStore connect postgres by dsn and apply the migrations
+func NewPostgresStore(dsn string, timeout time.Duration) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &PostgresStore{db: db, timeout: timeout}
+	ctx, cancel := newContext(timeout)
+	err = db.PingContext(ctx)
+	cancel()
+	if err == nil {
+		err = s.migrate()
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate apply the migrations which are not applied, each in a transaction
+func (s *PostgresStore) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	var applied int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&applied)
+	if err != nil {
+		return err
+	}
+	for version := applied + 1; version <= len(postgresMigrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if err = postgresMigrations[version-1](context.Background(), tx); err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			_ = tx.Rollback()
+		}
+		if err != nil {
+			return fmt.Errorf("migration %v failed: %w", version, err)
+		}
+		log.Info("[postgres] apply migration success", "version", version)
+	}
+	return nil
+}
+
+func (s *PostgresStore) context() (context.Context, context.CancelFunc) {
+	return newContext(s.timeout)
+}
+
+// execer is *sql.DB or *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// putSwapRow insert or overwrite swap, returns whether it's written
+func putSwapRow(ctx context.Context, e execer, swap *Swap, overwrite bool) (bool, error) {
+	swap.TxID = NormalizeTxID(swap.TxID)
+	data, err := json.Marshal(swap)
+	if err != nil {
+		return false, err
+	}
+	query := `INSERT INTO swaps (id, txid, chain, state, block_number, block_hash, timestamp, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	if overwrite {
+		query += ` ON CONFLICT (id) DO UPDATE SET chain = EXCLUDED.chain, block_number = EXCLUDED.block_number,
+			block_hash = EXCLUDED.block_hash, timestamp = EXCLUDED.timestamp, state = EXCLUDED.state, data = EXCLUDED.data`
+	} else {
+		query += ` ON CONFLICT (id) DO NOTHING`
+	}
+	res, err := e.ExecContext(ctx, query, swap.Id, swap.TxID, swap.Chain, swap.State, swap.BlockNumber, swap.BlockHash, swap.Timestamp, data)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n != 0, err
+}
+
+func querySwaps(ctx context.Context, e execer, query string, args ...interface{}) ([]*Swap, error) {
+	rows, err := e.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]*Swap, 0)
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		swap := &Swap{}
+		if err = json.Unmarshal(data, swap); err != nil {
+			return nil, err
+		}
+		result = append(result, swap)
+	}
+	return result, rows.Err()
+}
+
+func (s *PostgresStore) inTx(f func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(ctx, tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddSwap implements Store
+func (s *PostgresStore) AddSwap(swap *Swap) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	swap.Version = 1
+	added, err := putSwapRow(ctx, s.db, swap, false)
+	if err == nil && !added {
+		err = ErrSwapExists
+	}
+	metrics.StorageError("postgres", "AddSwap", err)
+	if err == nil {
+		log.Info("[postgres] AddSwap success", "swap", swap)
+	} else {
+		log.Warn("[postgres] AddSwap failed", "swap", swap, "err", err)
+	}
+	return err
+}
+
+// UpdateSwap implements Store, the row is locked until updated
+func (s *PostgresStore) UpdateSwap(key string, f func(swap *Swap) error) (swap *Swap, err error) {
+	err = s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		swaps, err := querySwaps(ctx, tx, `SELECT data FROM swaps WHERE id = $1 FOR UPDATE`, key)
+		if err != nil {
+			return err
+		}
+		if len(swaps) == 0 {
+			return ErrNotFound
+		}
+		swap = swaps[0]
+		if err = f(swap); err != nil {
+			return err
+		}
+		swap.Version++
+		_, err = putSwapRow(ctx, tx, swap, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info("[postgres] UpdateSwap success", "key", key, "state", swap.State, "attempts", swap.Attempts)
+	return swap, nil
+}
+
+// FindSwap implements Store
+func (s *PostgresStore) FindSwap(key string) (*Swap, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	swaps, err := querySwaps(ctx, s.db, `SELECT data FROM swaps WHERE id = $1`, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(swaps) == 0 {
+		return nil, ErrNotFound
+	}
+	return swaps[0], nil
+}
+
+// FindSwapsByTxID implements Store
+func (s *PostgresStore) FindSwapsByTxID(txid string) ([]*Swap, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	swaps, err := querySwaps(ctx, s.db, `SELECT data FROM swaps WHERE txid = $1 ORDER BY id`, NormalizeTxID(txid))
+	metrics.StorageError("postgres", "FindSwapsByTxID", err)
+	return swaps, err
+}
+
+// FindSwaps implements Store
+func (s *PostgresStore) FindSwaps(chain, state string, offset, limit int) ([]*Swap, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	var limitArg interface{} // null is no limit
+	if limit > 0 {
+		limitArg = limit
+	}
+	swaps, err := querySwaps(ctx, s.db, `SELECT data FROM swaps WHERE chain = $1 AND state = $2
+		ORDER BY block_number, id OFFSET $3 LIMIT $4`, chain, state, offset, limitArg)
+	metrics.StorageError("postgres", "FindSwaps", err)
+	return swaps, err
+}
+
+// FindSwapsByBlock implements Store
+func (s *PostgresStore) FindSwapsByBlock(chain, blockHash string) ([]*Swap, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	swaps, err := querySwaps(ctx, s.db, `SELECT data FROM swaps WHERE chain = $1 AND block_hash = $2 ORDER BY id`, chain, blockHash)
+	metrics.StorageError("postgres", "FindSwapsByBlock", err)
+	return swaps, err
+}
+
+// CountSwaps implements Store
+func (s *PostgresStore) CountSwaps(chain, state string) (count int, err error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM swaps WHERE chain = $1 AND state = $2`, chain, state).Scan(&count)
+	metrics.StorageError("postgres", "CountSwaps", err)
+	return count, err
+}
+
+// FindSyncedBlockNumber implements Store
+func (s *PostgresStore) FindSyncedBlockNumber(chain string) (number uint64, err error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	err = s.db.QueryRowContext(ctx, `SELECT block_number FROM synced_blocks WHERE chain = $1`, chain).Scan(&number)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	metrics.StorageError("postgres", "FindSyncedBlockNumber", err)
+	return number, err
+}
+
+// InitSyncedBlockNumber implements Store
+func (s *PostgresStore) InitSyncedBlockNumber(chain string, number uint64) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO synced_blocks (chain, block_number) VALUES ($1, $2)
+		ON CONFLICT (chain) DO UPDATE SET block_number = EXCLUDED.block_number`, chain, number)
+	return err
+}
+
+// UpdateSyncedBlockNumber implements Store
+func (s *PostgresStore) UpdateSyncedBlockNumber(chain string, number uint64) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `UPDATE synced_blocks SET block_number = $2 WHERE chain = $1`, chain, number)
+	if err == nil {
+		if n, errn := res.RowsAffected(); errn == nil && n == 0 {
+			err = ErrNotFound
+		}
+	}
+	metrics.StorageError("postgres", "UpdateSyncedBlockNumber", err)
+	return err
+}
+
+// FindRangeJobs implements Store
+func (s *PostgresStore) FindRangeJobs(chain string) ([]*RangeJob, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM range_jobs
+		WHERE chain = $1 ORDER BY start_at, end_at, from_at`, chain)
+	if err != nil {
+		metrics.StorageError("postgres", "FindRangeJobs", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*RangeJob
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		job := &RangeJob{}
+		if err = json.Unmarshal(data, job); err != nil {
+			return nil, err
+		}
+		result = append(result, job)
+	}
+	err = rows.Err()
+	metrics.StorageError("postgres", "FindRangeJobs", err)
+	return result, err
+}
+
+// UpsertRangeJob implements Store
+func (s *PostgresStore) UpsertRangeJob(job *RangeJob) error {
+	job.Timestamp = uint64(time.Now().Unix())
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO range_jobs (id, chain, start_at, end_at, from_at, data) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data`, job.Id, job.Chain, job.Start, job.End, job.From, data)
+	metrics.StorageError("postgres", "UpsertRangeJob", err)
+	return err
+}
+
+// DeleteRangeJobs implements Store
+func (s *PostgresStore) DeleteRangeJobs(chain string, start, end uint64) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `DELETE FROM range_jobs WHERE chain = $1 AND start_at = $2 AND end_at = $3`, chain, start, end)
+	metrics.StorageError("postgres", "DeleteRangeJobs", err)
+	return err
+}
+
+// Close implements Store
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
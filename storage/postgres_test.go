@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// testPostgresDSNEnv dsn of the postgres database for tests, the tests are skipped if not set.
+// The tables of the database are dropped by the tests.
+const testPostgresDSNEnv = "GETHSCAN_TEST_POSTGRES_DSN"
+
+func newTestPostgresStore(t *testing.T) *PostgresStore {
+	dsn := os.Getenv(testPostgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%v is not set", testPostgresDSNEnv)
+	}
+	dropTables := func(s *PostgresStore) {
+		_, err := s.db.Exec(`DROP TABLE IF EXISTS swaps, synced_blocks, range_jobs, schema_migrations`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := NewPostgresStore(dsn, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropTables(s)
+	if err = s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		dropTables(s)
+		_ = s.Close()
+	})
+	return s
+}
+
+func TestPostgresSwapsOfOneTx(t *testing.T) {
+	testSwapsOfOneTx(t, newTestPostgresStore(t))
+}
+
+func TestPostgresUpdateSwapAbort(t *testing.T) {
+	testUpdateSwapAbort(t, newTestPostgresStore(t))
+}
+
+func TestPostgresMarkSwapsOrphaned(t *testing.T) {
+	testMarkSwapsOrphaned(t, newTestPostgresStore(t))
+}
+
+func TestPostgresFindSwaps(t *testing.T) {
+	testFindSwaps(t, newTestPostgresStore(t))
+}
+
+func TestPostgresSyncedBlockNumber(t *testing.T) {
+	testSyncedBlockNumber(t, newTestPostgresStore(t))
+}
+
+func TestPostgresRangeJobs(t *testing.T) {
+	testRangeJobs(t, newTestPostgresStore(t))
+}
+
+func TestPostgresFindSwapsByTxID(t *testing.T) {
+	testFindSwapsByTxID(t, newTestPostgresStore(t))
+}
@@ -0,0 +1,121 @@
+// Package storage persists detected swaps and scanning checkpoints.
+// Every swap is kept in one record with its lifecycle state and the history of transitions,
+// the record is updated atomically so that a swap is never lost when crashing.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// ErrNotFound record is not found
+var ErrNotFound = errors.New("not found")
+
+// Swap record of detected swap
+type Swap struct {
+	Id          string      `bson:"_id" json:"id"` // key of swap, see SwapKey
+	TxID        string      `bson:"txid" json:"txid"`
+	PairID      string      `bson:"pairID" json:"pairID"`       //"FXSv4"
+	RpcMethod   string      `bson:"rpcMethod" json:"rpcMethod"` //"swap.Swapin"
+	SwapServer  string      `bson:"swapServer" json:"swapServer"`
+	ChainID     string      `bson:"chainid" json:"chainid"`
+	LogIndex    string      `bson:"logIndex" json:"logIndex"`
+	Chain       string      `bson:"chain" json:"chain"`
+	Timestamp   uint64      `bson:"timestamp" json:"timestamp"` // time of the last transition
+	BlockNumber uint64      `bson:"blockNumber" json:"blockNumber"`
+	BlockHash   string      `bson:"blockHash" json:"blockHash"`
+	Reason      string      `bson:"reason,omitempty" json:"reason,omitempty"`
+	PostOutcome string      `bson:"postOutcome,omitempty" json:"postOutcome,omitempty"`
+	PostError   string      `bson:"postError,omitempty" json:"postError,omitempty"`
+	Sinks       []string    `bson:"sinks,omitempty" json:"sinks,omitempty"`
+	Delivered   []string    `bson:"delivered,omitempty" json:"delivered,omitempty"`
+	Detail      *SwapDetail `bson:"detail,omitempty" json:"detail,omitempty"`
+
+	State     string            `bson:"state" json:"state"`
+	Attempts  int               `bson:"attempts" json:"attempts"` // post attempts
+	LastError string            `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	History   []*SwapTransition `bson:"history,omitempty" json:"history,omitempty"`
+	Version   uint64            `bson:"version" json:"version"` // increased by every update
+}
+
+// SwapDetail decoded payload of router swap event
+type SwapDetail struct {
+	Event       string   `bson:"event" json:"event"`
+	Token       string   `bson:"token,omitempty" json:"token,omitempty"`
+	From        string   `bson:"from,omitempty" json:"from,omitempty"`
+	To          string   `bson:"to,omitempty" json:"to,omitempty"`
+	Amount      string   `bson:"amount,omitempty" json:"amount,omitempty"`
+	FromChainID string   `bson:"fromChainID,omitempty" json:"fromChainID,omitempty"`
+	ToChainID   string   `bson:"toChainID,omitempty" json:"toChainID,omitempty"`
+	SwapoutID   string   `bson:"swapoutID,omitempty" json:"swapoutID,omitempty"`
+	Path        []string `bson:"path,omitempty" json:"path,omitempty"`
+	TokenIDs    []string `bson:"tokenIDs,omitempty" json:"tokenIDs,omitempty"`
+	Amounts     []string `bson:"amounts,omitempty" json:"amounts,omitempty"`
+	AppID       string   `bson:"appID,omitempty" json:"appID,omitempty"`
+	CallProxy   string   `bson:"callProxy,omitempty" json:"callProxy,omitempty"`
+	CallData    string   `bson:"callData,omitempty" json:"callData,omitempty"`
+}
+
+// RangeJob checkpoint of a slice of range job
+type RangeJob struct {
+	Id        string `bson:"_id" json:"id"` //"chain:start-end:from"
+	Chain     string `bson:"chain" json:"chain"`
+	Start     uint64 `bson:"start" json:"start"` // range job [start, end)
+	End       uint64 `bson:"end" json:"end"`
+	From      uint64 `bson:"from" json:"from"` // slice [from, to)
+	To        uint64 `bson:"to" json:"to"`
+	Cursor    uint64 `bson:"cursor" json:"cursor"` // next block to scan
+	Finished  bool   `bson:"finished" json:"finished"`
+	Timestamp uint64 `bson:"timestamp" json:"timestamp"`
+}
+
+// SwapKey key of swap record, a tx may have several swaps of different logs or pairs
+func SwapKey(swapServer, txid, pairID, logIndex string) string {
+	return fmt.Sprintf("%v:%v:%v:%v", swapServer, txid, pairID, logIndex)
+}
+
+// NormalizeTxID txids are stored in lower case, and matched exactly after normalized
+func NormalizeTxID(txid string) string {
+	return strings.ToLower(txid)
+}
+
+// Store storage of swaps and checkpoints, swaps are keyed by SwapKey.
+// Finding or updating a swap which does not exist returns ErrNotFound.
+type Store interface {
+	// AddSwap add new swap record, returns ErrSwapExists if exists
+	AddSwap(swap *Swap) error
+	// UpdateSwap apply f to the stored swap and save it atomically,
+	// nothing is saved if f returns error, and the error is returned.
+	UpdateSwap(key string, f func(swap *Swap) error) (*Swap, error)
+
+	FindSwap(key string) (*Swap, error)
+	// FindSwapsByTxID find swaps of tx, txid is matched after normalized
+	FindSwapsByTxID(txid string) ([]*Swap, error)
+	// FindSwaps find swaps of chain in state, sorted by block number, limit 0 means no limit
+	FindSwaps(chain, state string, offset, limit int) ([]*Swap, error)
+	FindSwapsByBlock(chain, blockHash string) ([]*Swap, error)
+	CountSwaps(chain, state string) (int, error)
+
+	FindSyncedBlockNumber(chain string) (uint64, error)
+	InitSyncedBlockNumber(chain string, number uint64) error
+	UpdateSyncedBlockNumber(chain string, number uint64) error
+
+	// FindRangeJobs find slices of all range jobs of chain, sorted by start, end and from
+	FindRangeJobs(chain string) ([]*RangeJob, error)
+	UpsertRangeJob(job *RangeJob) error
+	DeleteRangeJobs(chain string, start, end uint64) error
+
+	Close() error
+}
+
+func newContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
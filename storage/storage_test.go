@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+// the tests of Store run with every backend
+
+func newTestSwap(txid, logIndex string) *Swap {
+	swap := &Swap{
+		Id:          SwapKey("server", txid, "", logIndex),
+		TxID:        txid,
+		SwapServer:  "server",
+		LogIndex:    logIndex,
+		Chain:       "eth",
+		BlockNumber: 100,
+		BlockHash:   "0xabc",
+	}
+	_ = swap.Transit(SwapDetected, "", "")
+	return swap
+}
+
+func addTestSwaps(t *testing.T, s Store, swaps ...*Swap) {
+	t.Helper()
+	for _, swap := range swaps {
+		if err := s.AddSwap(swap); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testSwapsOfOneTx(t *testing.T, s Store) {
+	first, second := newTestSwap("0x1", "1"), newTestSwap("0x1", "2")
+	addTestSwaps(t, s, first, second)
+	if err := s.AddSwap(newTestSwap("0x1", "1")); !errors.Is(err, ErrSwapExists) {
+		t.Fatalf("add existing swap got error %v, want %v", err, ErrSwapExists)
+	}
+	_, err := s.UpdateSwap(second.Id, func(swap *Swap) error {
+		return swap.Transit(SwapPosting, "", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.FindSwap(first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != SwapDetected || got.LogIndex != "1" {
+		t.Fatalf("the other swap of tx is changed, state %v logIndex %v", got.State, got.LogIndex)
+	}
+	swaps, err := s.FindSwapsByTxID("0x1")
+	if err != nil || len(swaps) != 2 {
+		t.Fatalf("find swaps of tx got %v swaps, err %v", len(swaps), err)
+	}
+	if n, _ := s.CountSwaps("eth", SwapPosting); n != 1 {
+		t.Fatalf("count posting swaps got %v, want 1", n)
+	}
+}
+
+func testUpdateSwapAbort(t *testing.T, s Store) {
+	swap := newTestSwap("0x1", "1")
+	addTestSwaps(t, s, swap)
+	abort := errors.New("abort")
+	_, err := s.UpdateSwap(swap.Id, func(swap *Swap) error {
+		swap.State = SwapDropped
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Fatalf("got error %v, want %v", err, abort)
+	}
+	got, _ := s.FindSwap(swap.Id)
+	if got.State != SwapDetected || got.Version != 1 {
+		t.Fatalf("aborted update is saved, state %v version %v", got.State, got.Version)
+	}
+	if _, err = s.UpdateSwap("missing", func(*Swap) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("update missing swap got error %v, want %v", err, ErrNotFound)
+	}
+	if _, err = s.FindSwap("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("find missing swap got error %v, want %v", err, ErrNotFound)
+	}
+}
+
+func testMarkSwapsOrphaned(t *testing.T, s Store) {
+	orphaned, other := newTestSwap("0x1", "1"), newTestSwap("0x2", "1")
+	other.BlockHash = "0xdef"
+	addTestSwaps(t, s, orphaned, other)
+	if err := MarkSwapsOrphaned(s, "eth", "0xabc", "orphaned block"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.FindSwap(orphaned.Id); got.State != SwapOrphaned || got.Reason != "orphaned block" {
+		t.Fatalf("swap of orphaned block has state %v reason %v", got.State, got.Reason)
+	}
+	if got, _ := s.FindSwap(other.Id); got.State != SwapDetected {
+		t.Fatalf("swap of other block has state %v", got.State)
+	}
+}
+
+// testFindSwaps swaps of chain in state are sorted by block number and paged
+func testFindSwaps(t *testing.T, s Store) {
+	for i, height := range []uint64{103, 101, 102} {
+		swap := newTestSwap("0x1", string(rune('1'+i)))
+		swap.BlockNumber = height
+		addTestSwaps(t, s, swap)
+	}
+	other := newTestSwap("0x2", "1")
+	other.Chain = "bsc"
+	addTestSwaps(t, s, other)
+
+	swaps, err := s.FindSwaps("eth", SwapDetected, 1, 1)
+	if err != nil || len(swaps) != 1 || swaps[0].BlockNumber != 102 {
+		t.Fatalf("find the second swap got %+v, err %v", swaps, err)
+	}
+	if swaps, _ = s.FindSwaps("eth", SwapDetected, 0, 0); len(swaps) != 3 || swaps[0].BlockNumber != 101 {
+		t.Fatalf("find all swaps got %v swaps", len(swaps))
+	}
+	if swaps, _ = s.FindSwaps("eth", SwapDetected, 3, 10); len(swaps) != 0 {
+		t.Fatalf("find swaps beyond the last got %v swaps", len(swaps))
+	}
+	if n, _ := s.CountSwaps("bsc", SwapDetected); n != 1 {
+		t.Fatalf("count swaps of other chain got %v, want 1", n)
+	}
+}
+
+func testSyncedBlockNumber(t *testing.T, s Store) {
+	if err := s.UpdateSyncedBlockNumber("eth", 10); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("update missing synced block number got error %v, want %v", err, ErrNotFound)
+	}
+	if _, err := s.FindSyncedBlockNumber("eth"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("find missing synced block number got error %v, want %v", err, ErrNotFound)
+	}
+	if err := s.InitSyncedBlockNumber("eth", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateSyncedBlockNumber("eth", 20); err != nil {
+		t.Fatal(err)
+	}
+	if number, err := s.FindSyncedBlockNumber("eth"); err != nil || number != 20 {
+		t.Fatalf("synced block number is %v, err %v, want 20", number, err)
+	}
+}
+
+func testRangeJobs(t *testing.T, s Store) {
+	for _, job := range []*RangeJob{
+		{Id: "eth:1-9:5", Chain: "eth", Start: 1, End: 9, From: 5, To: 9, Cursor: 5},
+		{Id: "eth:1-9:1", Chain: "eth", Start: 1, End: 9, From: 1, To: 5, Cursor: 3},
+		{Id: "eth:1-5:1", Chain: "eth", Start: 1, End: 5, From: 1, To: 5, Cursor: 1},
+	} {
+		if err := s.UpsertRangeJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.UpsertRangeJob(&RangeJob{Id: "bsc:1-9:1", Chain: "bsc", Start: 1, End: 9, From: 1, To: 9}); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := s.FindRangeJobs("eth")
+	if err != nil || len(jobs) != 3 {
+		t.Fatalf("find range jobs got %v jobs, err %v", len(jobs), err)
+	}
+	if jobs[0].Id != "eth:1-5:1" || jobs[1].Id != "eth:1-9:1" || jobs[1].Cursor != 3 || jobs[2].Id != "eth:1-9:5" {
+		t.Fatalf("wrong order of range jobs %v %v %v", jobs[0].Id, jobs[1].Id, jobs[2].Id)
+	}
+	if err = s.DeleteRangeJobs("eth", 1, 9); err != nil {
+		t.Fatal(err)
+	}
+	if jobs, _ = s.FindRangeJobs("eth"); len(jobs) != 1 || jobs[0].Id != "eth:1-5:1" {
+		t.Fatalf("%v range jobs are left after deleted, want the one of other range", len(jobs))
+	}
+}
+
+// testFindSwapsByTxID txids are stored in lower case and matched after normalized
+func testFindSwapsByTxID(t *testing.T, s Store) {
+	addTestSwaps(t, s, newTestSwap("0xAB", "1"), newTestSwap("0xab1", "1"))
+	for _, txid := range []string{"0xab", "0xAB"} {
+		swaps, err := s.FindSwapsByTxID(txid)
+		if err != nil || len(swaps) != 1 || swaps[0].TxID != "0xab" {
+			t.Fatalf("find swaps of tx %v got %+v, err %v", txid, swaps, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// states of swap lifecycle
+const (
+	SwapDetected          = "detected"           // found in block, waiting for confirmation or posting
+	SwapPosting           = "posting"            // posting to swap server
+	SwapRegistered        = "registered"         // registered by swap server
+	SwapAlreadyRegistered = "already-registered" // registered before by others
+	SwapRejected          = "rejected"           // rejected by swap server, never succeed by retrying
+	SwapPendingRetry      = "pending-retry"      // post failed, to be reposted
+	SwapDropped           = "dropped"            // dropped by admin, or the tx is failed
+	SwapOrphaned          = "orphaned"           // the block is orphaned by chain reorganization
+)
+
+// maxSwapHistory max transitions kept in swap record, the first one is always kept
+const maxSwapHistory = 100
+
+var (
+	// ErrSwapExists swap record exists
+	ErrSwapExists = errors.New("swap exists")
+	// ErrSwapConflict swap record is changed by others when updating
+	ErrSwapConflict = errors.New("swap is updated concurrently")
+
+	errSkipUpdate = errors.New("skip update") // abort updating without error
+)
+
+// swapTransitions valid transitions of swap states.
+// Finished swaps can be posted again by rescanning, and swaps of orphaned blocks
+// are detected again if the tx is included in another block.
+var swapTransitions = map[string][]string{
+	"":                    {SwapDetected, SwapPosting, SwapPendingRetry},
+	SwapDetected:          {SwapPosting, SwapPendingRetry, SwapDropped, SwapOrphaned},
+	SwapPosting:           {SwapPosting, SwapRegistered, SwapAlreadyRegistered, SwapRejected, SwapPendingRetry, SwapOrphaned},
+	SwapPendingRetry:      {SwapPosting, SwapPendingRetry, SwapDropped, SwapOrphaned},
+	SwapRegistered:        {SwapPosting, SwapOrphaned},
+	SwapAlreadyRegistered: {SwapPosting, SwapOrphaned},
+	SwapRejected:          {SwapPosting, SwapDropped, SwapOrphaned},
+	SwapDropped:           {SwapPosting, SwapOrphaned},
+	SwapOrphaned:          {SwapDetected},
+}
+
+// SwapTransition a transition of swap state
+type SwapTransition struct {
+	From      string `bson:"from,omitempty" json:"from,omitempty"`
+	To        string `bson:"to" json:"to"`
+	Timestamp uint64 `bson:"timestamp" json:"timestamp"`
+	Attempt   int    `bson:"attempt,omitempty" json:"attempt,omitempty"`
+	Reason    string `bson:"reason,omitempty" json:"reason,omitempty"`
+	Error     string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// IsSwapState is valid swap state
+func IsSwapState(state string) bool {
+	_, exist := swapTransitions[state]
+	return exist && state != ""
+}
+
+// CanTransit can swap transit from state to state
+func CanTransit(from, to string) bool {
+	for _, state := range swapTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transit move swap to state and record the transition,
+// posting counts an attempt, and a non empty errMsg is kept as the last error.
+func (swap *Swap) Transit(to, reason, errMsg string) error {
+	if !CanTransit(swap.State, to) {
+		return fmt.Errorf("swap %v can not transit from '%v' to '%v'", swap.Id, swap.State, to)
+	}
+	now := uint64(time.Now().Unix())
+	if to == SwapPosting {
+		swap.Attempts++
+	}
+	if errMsg != "" {
+		swap.LastError = errMsg
+	}
+	if reason != "" {
+		swap.Reason = reason
+	}
+	swap.History = append(swap.History, &SwapTransition{
+		From:      swap.State,
+		To:        to,
+		Timestamp: now,
+		Attempt:   swap.Attempts,
+		Reason:    reason,
+		Error:     errMsg,
+	})
+	if len(swap.History) > maxSwapHistory {
+		swap.History = append(swap.History[:1], swap.History[len(swap.History)-maxSwapHistory+1:]...)
+	}
+	swap.State = to
+	swap.Timestamp = now
+	return nil
+}
+
+// MarkSwapsOrphaned move swaps of orphaned block to orphaned state with reason
+func MarkSwapsOrphaned(store Store, chain, blockHash, reason string) error {
+	swaps, err := store.FindSwapsByBlock(chain, blockHash)
+	if err != nil {
+		return err
+	}
+	for _, swap := range swaps {
+		if swap.State == SwapOrphaned {
+			continue
+		}
+		_, err = store.UpdateSwap(swap.Id, func(swap *Swap) error {
+			if !strings.EqualFold(swap.BlockHash, blockHash) {
+				return errSkipUpdate // detected again in another block
+			}
+			return swap.Transit(SwapOrphaned, reason, "")
+		})
+		if err != nil && !errors.Is(err, errSkipUpdate) {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacy swap tables before swap states
+const (
+	LegacySwap        = "swap"
+	LegacyPending     = "pending"
+	LegacyDeleted     = "deleted"
+	LegacyUnconfirmed = "unconfirmed"
+)
+
+// RekeyLegacySwap set key of swap which is keyed by txid before, returns false if it's keyed already
+func RekeyLegacySwap(swap *Swap) bool {
+	if swap.TxID != "" {
+		return false
+	}
+	swap.TxID = NormalizeTxID(swap.Id)
+	swap.Id = SwapKey(swap.SwapServer, swap.TxID, swap.PairID, swap.LogIndex)
+	return true
+}
+
+// MigrateLegacySwap set state of swap in legacy table, the legacy timestamp is the transition time
+func MigrateLegacySwap(swap *Swap, table string) {
+	var state string
+	switch table {
+	case LegacySwap:
+		switch swap.PostOutcome {
+		case "AlreadyRegistered":
+			state = SwapAlreadyRegistered
+		case "Closed", "NotSupported", "WrongBindAddress", "Permanent":
+			state = SwapRejected
+		default:
+			state = SwapRegistered
+		}
+	case LegacyPending:
+		state = SwapPendingRetry
+	case LegacyUnconfirmed:
+		state = SwapDetected
+	default:
+		state = SwapDropped
+		if strings.HasPrefix(swap.Reason, "orphaned") {
+			state = SwapOrphaned
+		}
+	}
+	swap.State = state
+	swap.LastError = swap.PostError
+	swap.History = []*SwapTransition{{
+		To:        state,
+		Timestamp: swap.Timestamp,
+		Reason:    "migrated from " + table,
+		Error:     swap.PostError,
+	}}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestSwapTransit(t *testing.T) {
+	swap := &Swap{Id: "key"}
+	steps := []struct {
+		to       string
+		errMsg   string
+		attempts int
+	}{
+		{SwapDetected, "", 0},
+		{SwapPosting, "", 1},
+		{SwapPendingRetry, "timeout", 1},
+		{SwapPosting, "", 2},
+		{SwapRegistered, "", 2},
+	}
+	for _, step := range steps {
+		if err := swap.Transit(step.to, "", step.errMsg); err != nil {
+			t.Fatalf("transit to %v failed: %v", step.to, err)
+		}
+		if swap.State != step.to || swap.Attempts != step.attempts {
+			t.Fatalf("got state %v attempts %v, want %v %v", swap.State, swap.Attempts, step.to, step.attempts)
+		}
+	}
+	if swap.LastError != "timeout" {
+		t.Errorf("last error is %q, want %q", swap.LastError, "timeout")
+	}
+	if len(swap.History) != len(steps) {
+		t.Fatalf("history has %v transitions, want %v", len(swap.History), len(steps))
+	}
+	if h := swap.History[2]; h.From != SwapPosting || h.To != SwapPendingRetry || h.Error != "timeout" || h.Attempt != 1 {
+		t.Errorf("wrong transition %+v", h)
+	}
+}
+
+func TestSwapTransitInvalid(t *testing.T) {
+	invalid := [][2]string{
+		{"", SwapRegistered},
+		{SwapDetected, SwapRegistered},
+		{SwapPendingRetry, SwapRegistered},
+		{SwapRegistered, SwapPendingRetry},
+		{SwapOrphaned, SwapPosting},
+	}
+	for _, item := range invalid {
+		swap := &Swap{Id: "key", State: item[0]}
+		if err := swap.Transit(item[1], "", ""); err == nil {
+			t.Errorf("transit from %q to %q should fail", item[0], item[1])
+		}
+		if swap.State != item[0] || len(swap.History) != 0 {
+			t.Errorf("failed transit from %q to %q changed the swap", item[0], item[1])
+		}
+	}
+}
+
+func TestSwapHistoryLimit(t *testing.T) {
+	swap := &Swap{Id: "key"}
+	_ = swap.Transit(SwapDetected, "found", "")
+	for i := 0; i < 2*maxSwapHistory; i++ {
+		if err := swap.Transit(SwapPosting, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(swap.History) != maxSwapHistory {
+		t.Fatalf("history has %v transitions, want %v", len(swap.History), maxSwapHistory)
+	}
+	if swap.History[0].Reason != "found" {
+		t.Errorf("the first transition is not kept")
+	}
+	if last := swap.History[len(swap.History)-1]; last.Attempt != 2*maxSwapHistory {
+		t.Errorf("the last transition has attempt %v, want %v", last.Attempt, 2*maxSwapHistory)
+	}
+}
+
+func TestMigrateLegacySwap(t *testing.T) {
+	tests := []struct {
+		table   string
+		outcome string
+		reason  string
+		state   string
+	}{
+		{LegacySwap, "Success", "", SwapRegistered},
+		{LegacySwap, "", "", SwapRegistered},
+		{LegacySwap, "AlreadyRegistered", "", SwapAlreadyRegistered},
+		{LegacySwap, "NotSupported", "", SwapRejected},
+		{LegacyPending, "Transient", "", SwapPendingRetry},
+		{LegacyUnconfirmed, "", "", SwapDetected},
+		{LegacyDeleted, "", "dropped by admin", SwapDropped},
+		{LegacyDeleted, "", "orphaned block 0x1 at height 1", SwapOrphaned},
+	}
+	for _, test := range tests {
+		swap := &Swap{Id: "0x1", PostOutcome: test.outcome, Reason: test.reason, Timestamp: 10}
+		MigrateLegacySwap(swap, test.table)
+		if swap.State != test.state {
+			t.Errorf("legacy %v %v %q got state %v, want %v", test.table, test.outcome, test.reason, swap.State, test.state)
+		}
+		if len(swap.History) != 1 || swap.History[0].Timestamp != 10 {
+			t.Errorf("wrong history of migrated swap %+v", swap.History)
+		}
+	}
+}
+
+func TestRekeyLegacySwap(t *testing.T) {
+	swap := &Swap{Id: "0x1", SwapServer: "server", PairID: "pair", LogIndex: "2"}
+	if !RekeyLegacySwap(swap) {
+		t.Fatal("legacy swap is not rekeyed")
+	}
+	if swap.TxID != "0x1" || swap.Id != SwapKey("server", "0x1", "pair", "2") {
+		t.Fatalf("wrong rekeyed swap id %v txid %v", swap.Id, swap.TxID)
+	}
+	if RekeyLegacySwap(swap) {
+		t.Fatal("rekeyed swap is rekeyed again")
+	}
+}
@@ -0,0 +1,95 @@
+package tools
+
+import (
+	"sync"
+	"time"
+)
+
+// circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "halfopen"
+)
+
+// CircuitBreaker opens after consecutive failures, rejects calls when open,
+// and allows one probe call (half-open) after the open timeout.
+// The circuit is closed if the probe succeeds, or opened again if it fails.
+type CircuitBreaker struct {
+	lock        sync.Mutex
+	threshold   int
+	openTimeout time.Duration
+	state       string
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+// NewCircuitBreaker new circuit breaker, never opens if threshold <= 0
+func NewCircuitBreaker(threshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		state:       CircuitClosed,
+	}
+}
+
+// Allow whether a call is allowed, the allowed call must be finished by `Done` or `Cancel`
+func (b *CircuitBreaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Done report the result of allowed call, returns the state and whether it's changed
+func (b *CircuitBreaker) Done(success bool) (state string, changed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	old := b.state
+	b.probing = false
+	if success {
+		b.failures = 0
+		b.state = CircuitClosed
+		return b.state, b.state != old
+	}
+	b.failures++
+	switch {
+	case b.state == CircuitHalfOpen:
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	case b.state == CircuitClosed && b.threshold > 0 && b.failures >= b.threshold:
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+	return b.state, b.state != old
+}
+
+// Cancel cancel the allowed call which has no result
+func (b *CircuitBreaker) Cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.probing = false
+}
+
+// State get state
+func (b *CircuitBreaker) State() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
@@ -0,0 +1,74 @@
+package tools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(2, time.Minute)
+	if !b.Allow() {
+		t.Fatal("closed circuit rejects call")
+	}
+	if state, changed := b.Done(false); state != CircuitClosed || changed {
+		t.Fatalf("circuit is %v changed %v after one failure", state, changed)
+	}
+	b.Allow()
+	if state, changed := b.Done(false); state != CircuitOpen || !changed {
+		t.Fatalf("circuit is %v changed %v after threshold failures", state, changed)
+	}
+	if b.Allow() {
+		t.Fatal("open circuit allows call before timeout")
+	}
+
+	// only one probe is allowed after timeout
+	b.openedAt = time.Now().Add(-time.Minute)
+	if !b.Allow() || b.State() != CircuitHalfOpen {
+		t.Fatalf("half open circuit rejects probe, state %v", b.State())
+	}
+	if b.Allow() {
+		t.Fatal("half open circuit allows another call when probing")
+	}
+	if state, changed := b.Done(false); state != CircuitOpen || !changed {
+		t.Fatalf("circuit is %v changed %v after probe failed", state, changed)
+	}
+	if b.Allow() {
+		t.Fatal("circuit is not opened again after probe failed")
+	}
+
+	b.openedAt = time.Now().Add(-time.Minute)
+	b.Allow()
+	if state, changed := b.Done(true); state != CircuitClosed || !changed {
+		t.Fatalf("circuit is %v changed %v after probe succeeded", state, changed)
+	}
+	b.Allow()
+	if state, _ := b.Done(false); state != CircuitClosed {
+		t.Fatal("failures are not reset after success")
+	}
+}
+
+// TestCircuitBreakerCancel the cancelled probe does not block the next probe
+func TestCircuitBreakerCancel(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Minute)
+	b.Allow()
+	b.Done(false)
+	b.openedAt = time.Now().Add(-time.Minute)
+	if !b.Allow() {
+		t.Fatal("probe is rejected")
+	}
+	b.Cancel()
+	if !b.Allow() || b.State() != CircuitHalfOpen {
+		t.Fatal("probe is rejected after the last one is cancelled")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := NewCircuitBreaker(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		b.Allow()
+		b.Done(false)
+	}
+	if !b.Allow() || b.State() != CircuitClosed {
+		t.Fatal("circuit without threshold is opened")
+	}
+}
@@ -0,0 +1,313 @@
+package tools
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// outbox item states
+const (
+	OutboxPending = "pending"
+	OutboxDone    = "done"
+	OutboxDead    = "dead"
+)
+
+var (
+	// ErrOutboxSkip returned by `Do` callback to keep the item as it is, without counting an attempt
+	ErrOutboxSkip = errors.New("skip outbox item")
+	// ErrOutboxDead returned by `Do` callback to move the item to dead letters at once, eg. it never succeeds by retrying
+	ErrOutboxDead = errors.New("dead outbox item")
+)
+
+// OutboxItem outbox item
+type OutboxItem struct {
+	ID        string          `json:"id"`
+	Payload   json.RawMessage `json:"payload"`
+	State     string          `json:"state"`
+	Attempts  int             `json:"attempts"`
+	NextRetry int64           `json:"nextRetry"`
+	LastError string          `json:"lastError,omitempty"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// Outbox durable outbox on an append-only log file
+type Outbox struct {
+	path string
+	file *os.File
+	lock sync.Mutex
+
+	items   map[string]*OutboxItem
+	records int // records in log file
+
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+// OpenOutbox open outbox file and replay items in it
+func OpenOutbox(path string, maxAttempts int, backoff, maxBackoff time.Duration) (*Outbox, error) {
+	o := &Outbox{
+		path:        path,
+		items:       make(map[string]*OutboxItem),
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+	}
+	if err := o.replay(); err != nil {
+		return nil, err
+	}
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *Outbox) replay() error {
+	f, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		item := &OutboxItem{}
+		if err := json.Unmarshal(scanner.Bytes(), item); err != nil {
+			continue // ignore partial written record
+		}
+		if item.State == OutboxDone {
+			delete(o.items, item.ID)
+		} else {
+			o.items[item.ID] = item
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrite log file with live items only
+func (o *Outbox) compact() error {
+	tmpPath := o.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, item := range o.items {
+		data, _ := json.Marshal(item)
+		_, _ = w.Write(append(data, '\n'))
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if errc := tmp.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		return err
+	}
+	if o.file != nil {
+		_ = o.file.Close()
+		o.file = nil
+	}
+	if err = os.Rename(tmpPath, o.path); err != nil {
+		return err
+	}
+	o.file, err = os.OpenFile(o.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	o.records = len(o.items)
+	return err
+}
+
+func (o *Outbox) write(item *OutboxItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if _, err = o.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	o.records++
+	return o.file.Sync()
+}
+
+// Add add payload with id, overwrite if exist
+func (o *Outbox) Add(id string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	item := &OutboxItem{
+		ID:        id,
+		Payload:   data,
+		State:     OutboxPending,
+		Timestamp: time.Now().Unix(),
+	}
+	if err = o.write(item); err != nil {
+		return err
+	}
+	o.items[id] = item
+	return nil
+}
+
+// Do iterate due pending items, remove the item if do returns nil,
+// otherwise retry it later with exponential backoff,
+// and move it to dead letters if exceeds max attempts or do returns ErrOutboxDead.
+// The item is left unchanged if do returns ErrOutboxSkip.
+func (o *Outbox) Do(do func(*OutboxItem) error) {
+	o.lock.Lock()
+	now := time.Now()
+	due := make([]*OutboxItem, 0)
+	for _, item := range o.sortedItems() {
+		if item.State == OutboxPending && item.NextRetry <= now.Unix() {
+			due = append(due, item)
+		}
+	}
+	o.lock.Unlock()
+
+	for _, item := range due {
+		err := do(item)
+
+		o.lock.Lock()
+		if o.items[item.ID] != item || errors.Is(err, ErrOutboxSkip) {
+			o.lock.Unlock()
+			continue // removed or replaced meanwhile, or skipped
+		}
+		item.Attempts++
+		item.Timestamp = time.Now().Unix()
+		switch {
+		case err == nil:
+			item.State = OutboxDone
+			item.LastError = ""
+			delete(o.items, item.ID)
+		case errors.Is(err, ErrOutboxDead), o.maxAttempts > 0 && item.Attempts >= o.maxAttempts:
+			item.State = OutboxDead
+			item.LastError = err.Error()
+		default:
+			item.LastError = err.Error()
+			item.NextRetry = time.Now().Add(o.retryDelay(item.Attempts)).Unix()
+		}
+		_ = o.write(item)
+		o.lock.Unlock()
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.records > 2*len(o.items)+100 {
+		_ = o.compact()
+	}
+}
+
+// IsLastAttempt the item is moved to dead letters if this attempt fails
+func (o *Outbox) IsLastAttempt(item *OutboxItem) bool {
+	return o.maxAttempts > 0 && item.Attempts+1 >= o.maxAttempts
+}
+
+// Remove remove items which match the filter
+func (o *Outbox) Remove(filter func(*OutboxItem) bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for id, item := range o.items {
+		if filter(item) {
+			item.State = OutboxDone
+			item.Timestamp = time.Now().Unix()
+			_ = o.write(item)
+			delete(o.items, id)
+		}
+	}
+}
+
+// Retry retry item as soon as possible, including dead item
+func (o *Outbox) Retry(id string) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	item, exist := o.items[id]
+	if !exist {
+		return false
+	}
+	item.State = OutboxPending
+	item.NextRetry = 0
+	item.Timestamp = time.Now().Unix()
+	_ = o.write(item)
+	return true
+}
+
+// Items get copy of all items sorted by timestamp
+func (o *Outbox) Items() []*OutboxItem {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	items := make([]*OutboxItem, 0, len(o.items))
+	for _, item := range o.sortedItems() {
+		cpy := *item
+		items = append(items, &cpy)
+	}
+	return items
+}
+
+// Len number of pending items
+func (o *Outbox) Len() (count int) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for _, item := range o.items {
+		if item.State == OutboxPending {
+			count++
+		}
+	}
+	return count
+}
+
+// DeadLetters get dead items
+func (o *Outbox) DeadLetters() (items []*OutboxItem) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for _, item := range o.sortedItems() {
+		if item.State == OutboxDead {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Close close log file
+func (o *Outbox) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.file.Close()
+}
+
+func (o *Outbox) retryDelay(attempts int) time.Duration {
+	delay := o.backoff
+	for i := 1; i < attempts && delay < o.maxBackoff; i++ {
+		delay *= 2
+	}
+	if o.maxBackoff > 0 && delay > o.maxBackoff {
+		delay = o.maxBackoff
+	}
+	return delay
+}
+
+func (o *Outbox) sortedItems() []*OutboxItem {
+	items := make([]*OutboxItem, 0, len(o.items))
+	for _, item := range o.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Timestamp < items[j].Timestamp
+	})
+	return items
+}
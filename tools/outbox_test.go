@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestOutbox(t *testing.T, path string, maxAttempts int) *Outbox {
+	o, err := OpenOutbox(path, maxAttempts, time.Second, 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = o.Close() })
+	return o
+}
+
+func TestOutboxDead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	o := openTestOutbox(t, path, 100)
+	for _, id := range []string{"permanent", "transient"} {
+		if err := o.Add(id, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.Do(func(item *OutboxItem) error {
+		if item.ID == "permanent" {
+			return fmt.Errorf("%w: rejected", ErrOutboxDead)
+		}
+		return errors.New("timeout")
+	})
+	dead := o.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != "permanent" || dead[0].Attempts != 1 || dead[0].LastError != "dead outbox item: rejected" {
+		t.Fatalf("wrong dead letters %+v", dead)
+	}
+	if o.Len() != 1 {
+		t.Fatalf("%v pending items, want 1", o.Len())
+	}
+
+	// dead letters are kept after reopened
+	_ = o.Close()
+	o = openTestOutbox(t, path, 100)
+	if dead = o.DeadLetters(); len(dead) != 1 || dead[0].ID != "permanent" {
+		t.Fatalf("wrong dead letters after reopened %+v", dead)
+	}
+	if !o.Retry("permanent") || o.Len() != 2 {
+		t.Fatal("retry dead letter failed")
+	}
+}
+
+func TestOutboxMaxAttempts(t *testing.T) {
+	o := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.log"), 2)
+	if err := o.Add("id", "payload"); err != nil {
+		t.Fatal(err)
+	}
+	fail := func(*OutboxItem) error { return errors.New("timeout") }
+	o.Do(fail)
+	if o.Len() != 1 {
+		t.Fatal("item is dead before max attempts")
+	}
+	o.items["id"].NextRetry = 0 // due now
+	o.Do(fail)
+	if o.Len() != 0 || len(o.DeadLetters()) != 1 {
+		t.Fatal("item is not dead after max attempts")
+	}
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	o := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.log"), 0)
+	for attempts, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if got := o.retryDelay(attempts); got != want {
+			t.Errorf("retry delay of %v attempts is %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// TestOutboxBackoff failed items are not due until the backoff passes, and are replayed after reopened
+func TestOutboxBackoff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	o := openTestOutbox(t, path, 0)
+	if err := o.Add("id", "payload"); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	fail := func(*OutboxItem) error {
+		calls++
+		return errors.New("timeout")
+	}
+	o.Do(fail)
+	o.Do(fail) // not due yet
+	if calls != 1 {
+		t.Fatalf("item is tried %v times during backoff, want 1", calls)
+	}
+	item := o.Items()[0]
+	if item.Attempts != 1 || item.LastError != "timeout" || item.NextRetry == 0 {
+		t.Fatalf("wrong failed item %+v", item)
+	}
+
+	// pending items are replayed with attempts after reopened
+	_ = o.Close()
+	o = openTestOutbox(t, path, 0)
+	items := o.Items()
+	if len(items) != 1 || items[0].Attempts != 1 || items[0].State != OutboxPending || string(items[0].Payload) != `"payload"` {
+		t.Fatalf("wrong replayed items %+v", items)
+	}
+	o.items["id"].NextRetry = 0 // due now
+	o.Do(func(item *OutboxItem) error { return nil })
+	if o.Len() != 0 || len(o.Items()) != 0 {
+		t.Fatal("item is not removed after success")
+	}
+
+	// done items are not replayed
+	_ = o.Close()
+	if o = openTestOutbox(t, path, 0); len(o.Items()) != 0 {
+		t.Fatalf("done items are replayed %+v", o.Items())
+	}
+}
+
+func TestOutboxSkip(t *testing.T) {
+	o := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.log"), 1)
+	if err := o.Add("id", "payload"); err != nil {
+		t.Fatal(err)
+	}
+	o.Do(func(*OutboxItem) error { return ErrOutboxSkip })
+	if item := o.Items()[0]; item.Attempts != 0 || item.State != OutboxPending {
+		t.Fatalf("skipped item is changed %+v", item)
+	}
+}
@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter token bucket rate limiter
+type RateLimiter struct {
+	lock   sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter new rate limiter, unlimited if rate <= 0
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Reserve take a token and return the delay to wait before using it.
+// If the delay exceeds maxWait, the token is not taken and false is returned.
+func (l *RateLimiter) Reserve(maxWait time.Duration) (time.Duration, bool) {
+	if l.rate <= 0 {
+		return 0, true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if delay > maxWait {
+		return 0, false
+	}
+	l.tokens--
+	return delay, true
+}
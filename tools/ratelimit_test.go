@@ -0,0 +1,46 @@
+package tools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(10, 2)
+	for i := 0; i < 2; i++ {
+		if delay, ok := l.Reserve(0); !ok || delay != 0 {
+			t.Fatalf("reserve burst token %v got delay %v ok %v", i, delay, ok)
+		}
+	}
+	if _, ok := l.Reserve(10 * time.Millisecond); ok {
+		t.Fatal("reserve token which needs waiting longer than max wait")
+	}
+	delay, ok := l.Reserve(time.Second)
+	if !ok || delay <= 50*time.Millisecond || delay > 100*time.Millisecond {
+		t.Fatalf("reserve token got delay %v ok %v, want about 100ms", delay, ok)
+	}
+	// the reserved token is taken, the next one waits longer
+	if delay, ok = l.Reserve(time.Second); !ok || delay <= 150*time.Millisecond {
+		t.Fatalf("reserve next token got delay %v ok %v, want about 200ms", delay, ok)
+	}
+
+	// tokens are refilled up to burst
+	l.last = l.last.Add(-time.Hour)
+	for i := 0; i < 2; i++ {
+		if delay, ok := l.Reserve(0); !ok || delay != 0 {
+			t.Fatalf("reserve refilled token %v got delay %v ok %v", i, delay, ok)
+		}
+	}
+	if _, ok := l.Reserve(0); ok {
+		t.Fatal("tokens are refilled beyond burst")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	l := NewRateLimiter(0, 0)
+	for i := 0; i < 1000; i++ {
+		if delay, ok := l.Reserve(0); !ok || delay != 0 {
+			t.Fatalf("unlimited reserve got delay %v ok %v", delay, ok)
+		}
+	}
+}